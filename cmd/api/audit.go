@@ -0,0 +1,205 @@
+package main
+
+import (
+	"backend/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// The actions we record in the audit log
+const (
+	AuditMovieCreate       = "movie.create"
+	AuditMovieUpdate       = "movie.update"
+	AuditMovieDelete       = "movie.delete"
+	AuditPersonCreate      = "person.create"
+	AuditPersonUpdate      = "person.update"
+	AuditPersonDelete      = "person.delete"
+	AuditCreditCreate      = "credit.create"
+	AuditCreditDelete      = "credit.delete"
+	AuditAPIKeyCreate      = "api_key.create"
+	AuditAPIKeyRevoke      = "api_key.revoke"
+	AuditUserCreate        = "user.create"
+	AuditUserUpdate        = "user.update"
+	AuditUserDisable       = "user.disable"
+	AuditUserEnable        = "user.enable"
+	AuditUserPasswordReset = "user.password_reset"
+	AuditUserDelete        = "user.delete"
+	AuditUserSessionsKill  = "user.sessions_revoke"
+	AuditUserImpersonate   = "user.impersonate"
+	AuditUserShadowHide    = "user.shadow_hide"
+	AuditUserShadowUnhide  = "user.shadow_unhide"
+	AuditReviewModerate    = "review.moderate"
+	AuditLockoutClear      = "lockout.clear"
+)
+
+// audit records a change in the audit log: who made it (from the claims authRequired put on the request
+// context), from where, and what changed. before is nil for things that were created, after for things
+// that were deleted. The change has already happened by the time we get here, so errors are only logged
+func (app *application) audit(r *http.Request, action, entityType string, entityID any, before, after any) {
+	claims := claimsFromContext(r)
+	if claims == nil {
+		log.Printf("audit: %s on %s %v has no actor", action, entityType, entityID)
+		return
+	}
+
+	event := models.AuditEvent{
+		ActorID:    actorID(claims),
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   fmt.Sprint(entityID),
+		RequestID:  middleware.GetReqID(r.Context()),
+		IP:         clientIP(r),
+	}
+	if claims.APIKeyID != 0 {
+		event.APIKeyID = &claims.APIKeyID
+	}
+
+	var err error
+	event.Before, event.After, err = auditDiff(before, after)
+	if err != nil {
+		log.Printf("audit: %s on %s %v: %v", action, entityType, entityID, err)
+	}
+
+	err = app.DB.InsertAuditEvent(event)
+	if err != nil {
+		log.Printf("audit: %s on %s %v: %v", action, entityType, entityID, err)
+	}
+}
+
+// actorID is the id of the user making the request. When an admin is impersonating someone, the admin
+// is the one acting
+func actorID(claims *Claims) int {
+	actor := claims.Subject
+	if claims.Impersonated() {
+		actor = claims.Actor.Subject
+	}
+	id, _ := strconv.Atoi(actor)
+	return id
+}
+
+// auditDiff compares the JSON of before & after, and keeps only the top level fields that differ.
+// When either side is nil the other is kept whole
+func auditDiff(before, after any) (json.RawMessage, json.RawMessage, error) {
+	beforeFields, err := auditFields(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	afterFields, err := auditFields(after)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if beforeFields != nil && afterFields != nil {
+		for key, value := range beforeFields {
+			if other, ok := afterFields[key]; ok && reflect.DeepEqual(value, other) {
+				delete(beforeFields, key)
+				delete(afterFields, key)
+			}
+		}
+	}
+
+	b, err := marshalFields(beforeFields)
+	if err != nil {
+		return nil, nil, err
+	}
+	a, err := marshalFields(afterFields)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return b, a, nil
+}
+
+// auditFields turns v into a map of its JSON fields, so hidden fields (json:"-") never reach the log
+func auditFields(v any) (map[string]any, error) {
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Pointer && reflect.ValueOf(v).IsNil()) {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]any
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+func marshalFields(fields map[string]any) (json.RawMessage, error) {
+	if fields == nil {
+		return nil, nil
+	}
+	return json.Marshal(fields)
+}
+
+// AllAuditEvents lists the audit log, newest first, eg. /admin/audit?actor=1&entity_type=movie&entity_id=3&from=2024-01-01T00:00:00Z
+func (app *application) AllAuditEvents(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	query := models.AuditQuery{
+		EntityType: q.Get("entity_type"),
+		EntityID:   q.Get("entity_id"),
+	}
+
+	var err error
+	if v := q.Get("actor"); v != "" {
+		query.ActorID, err = strconv.Atoi(v)
+		if err != nil {
+			app.errorJSON(w, errors.New("actor must be a user id"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	for name, dst := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+		if v := q.Get(name); v != "" {
+			*dst, err = time.Parse(time.RFC3339, v)
+			if err != nil {
+				app.errorJSON(w, fmt.Errorf("%s must be an RFC 3339 time, eg. 2024-01-31T00:00:00Z", name), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	query.Page, query.PageSize, err = pageParams(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	events, total, err := app.DB.AllAuditEvents(query)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if events == nil {
+		events = []*models.AuditEvent{}
+	}
+
+	var payload = struct {
+		Events   []*models.AuditEvent `json:"events"`
+		Page     int                  `json:"page"`
+		PageSize int                  `json:"page_size"`
+		Total    int                  `json:"total"`
+	}{
+		Events:   events,
+		Page:     query.Page,
+		PageSize: query.PageSize,
+		Total:    total,
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"backend/internal/models"
 	"errors"
 	"fmt"
 	"net/http"
@@ -13,9 +14,12 @@ import (
 type Auth struct {
 	Issuer      string // eg domain.com
 	Audience    string // who is it intended for
-	Secret      string
+	Keys        *KeyRing
 	TokenExpiry time.Duration
 
+	// Leeway is how much clock skew we tolerate when checking a token's exp, nbf & iat claims
+	Leeway time.Duration
+
 	// optional but common for custom expiry period for the token (longer than the default short period)
 	RefreshExpiry time.Duration
 
@@ -26,11 +30,46 @@ type Auth struct {
 	CookieName   string
 }
 
+// The kinds of token we issue, carried in the 'token_type' claim. Only access tokens are accepted as
+// bearer tokens & only refresh tokens can be exchanged at /refresh, so one can't be replayed as the other.
+// MFA tokens prove the first step of a two-step login & can only be exchanged at /authenticate/mfa
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+	TokenTypeMFA     = "mfa"
+)
+
+// Authentication methods (RFC 8176) we record in the 'amr' claim. AMRFederated isn't in the RFC, it
+// marks logins through the OIDC provider, whose own amr values are recorded alongside it
+const (
+	AMRPassword  = "pwd"
+	AMROTP       = "otp"
+	AMRMFA       = "mfa"
+	AMRFederated = "fed"
+)
+
+// The errors ValidateToken & GetTokenFromHeaderAndVerify return, so callers can tell why a token was refused
+var (
+	ErrNoAuthHeader      = errors.New("no auth header")
+	ErrInvalidAuthHeader = errors.New("invalid auth header")
+	ErrTokenExpired      = errors.New("expired token")
+	ErrTokenNotYetValid  = errors.New("token not valid yet")
+	ErrTokenWrongType    = errors.New("wrong token type")
+	ErrTokenAudience     = errors.New("invalid audience")
+	ErrTokenIssuer       = errors.New("invalid issuer")
+	ErrTokenInvalid      = errors.New("invalid token")
+)
+
 // set minimum data required to issue a token
 type jwtUser struct {
 	ID        int    `json:"id"`
 	FirstName string `json:"first_name"`
 	LastName  string `json:"last_name"`
+	Role      string `json:"role"`
+	// AMR lists how the user proved who they are when they logged in, eg. password & one-time code
+	AMR []string `json:"amr"`
+	// SessionID is the refresh token family the tokens belong to, see models.Session
+	SessionID string `json:"sid"`
 }
 
 type TokenPairs struct {
@@ -44,12 +83,74 @@ type TokenPairs struct {
 type Claims struct {
 	// NOTES: 'jwt.RegisteredClaims' is from the 'jwt-go' package you would have installed already
 	jwt.RegisteredClaims
+	// Role is the user's role (viewer, editor or admin) at the time the token was issued
+	Role string `json:"role"`
+	// TokenType is TokenTypeAccess, TokenTypeRefresh or TokenTypeMFA
+	TokenType string `json:"token_type"`
+	// AMR lists the authentication methods used to log in, carried over from token to token on refresh
+	AMR []string `json:"amr,omitempty"`
+	// SessionID identifies the login the token was issued for
+	SessionID string `json:"sid,omitempty"`
+
+	// Actor is set on impersonation tokens (RFC 8693 'act' claim): the admin who is acting as the user in
+	// Subject. Everything else in the claims is about the user being impersonated
+	Actor *Actor `json:"act,omitempty"`
+
+	// APIKeyID & Scopes are only set when the request was authenticated with an API key rather than a JWT
+	APIKeyID int      `json:"-"`
+	Scopes   []string `json:"-"`
+}
+
+// Actor identifies who is really making the request when one user acts as another
+type Actor struct {
+	Subject string `json:"sub"`
+}
+
+// Impersonated reports whether the token was issued to an admin acting as another user
+func (c *Claims) Impersonated() bool {
+	return c.Actor != nil
+}
+
+// HasScope reports whether the request may do what scope allows. API keys carry their own scopes,
+// users get the scopes of their role
+func (c *Claims) HasScope(scope string) bool {
+	scopes := c.Scopes
+	if c.APIKeyID == 0 {
+		scopes = models.RoleScopes[c.Role]
+	}
+
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// UsedMFA reports whether the user passed a second factor when they logged in
+func (c *Claims) UsedMFA() bool {
+	for _, method := range c.AMR {
+		if method == AMROTP || method == AMRMFA {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRole reports whether the claims carry one of the given roles
+func (c *Claims) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		if c.Role == role {
+			return true
+		}
+	}
+	return false
 }
 
 // To start, we need to generate a token
 func (j *Auth) GenerateTokenPair(user *jwtUser) (TokenPairs, error) {
-	// Create a token (an empty token object)
-	token := jwt.New(jwt.SigningMethodHS256)
+	// Create a token (an empty token object) for our current signing key
+	token := j.Keys.NewToken()
 
 	// set the claims (what does this token claim to be eg name, subject, issuer, audience, etc)
 	//	there are a few ways to do this, but map claims is the easiest approach
@@ -59,29 +160,55 @@ func (j *Auth) GenerateTokenPair(user *jwtUser) (TokenPairs, error) {
 	claims["sub"] = fmt.Sprint(user.ID) // now the claims subject ('sub') will always be the ID of the user in our DB
 	claims["aud"] = j.Audience
 	claims["iss"] = j.Issuer
+	claims["role"] = user.Role // lets us authorise requests without a DB lookup
 	claims["iat"] = time.Now().UTC().Unix()
-	claims["typ"] = "JWT"
+	claims["nbf"] = time.Now().UTC().Unix()
+	claims["token_type"] = TokenTypeAccess
+	if len(user.AMR) > 0 {
+		claims["amr"] = user.AMR
+	}
+	if user.SessionID != "" {
+		claims["sid"] = user.SessionID
+	}
 
 	// set the expiry for the JWT (as short period of time)
 	claims["exp"] = time.Now().UTC().Add(j.TokenExpiry).Unix()
 
 	// create a signed token (sign the token)
-	signedAccessToken, err := token.SignedString([]byte(j.Secret))
+	signedAccessToken, err := j.Keys.Sign(token)
 	if err != nil {
 		return TokenPairs{}, err
 	}
 
 	// create a refresh token and set claims (there'll be fewer claims in the refresh token)
-	refreshToken := jwt.New(jwt.SigningMethodHS256)
+	refreshToken := j.Keys.NewToken()
 	refreshTokenClaims := refreshToken.Claims.(jwt.MapClaims)
 	refreshTokenClaims["sub"] = fmt.Sprint(user.ID)
+	refreshTokenClaims["aud"] = j.Audience
+	refreshTokenClaims["iss"] = j.Issuer
 	refreshTokenClaims["iat"] = time.Now().UTC().Unix()
+	refreshTokenClaims["nbf"] = time.Now().UTC().Unix()
+	refreshTokenClaims["token_type"] = TokenTypeRefresh
+	if len(user.AMR) > 0 {
+		refreshTokenClaims["amr"] = user.AMR
+	}
+	if user.SessionID != "" {
+		refreshTokenClaims["sid"] = user.SessionID
+	}
+
+	// a unique id makes every refresh token (and so its hash in the token store) unique,
+	// even when two are issued for the same user in the same second
+	jti, err := randomString(16)
+	if err != nil {
+		return TokenPairs{}, err
+	}
+	refreshTokenClaims["jti"] = jti
 
 	// set the expiry for the refresh token (it will be longer than the expiry of the JWT itself)
 	refreshTokenClaims["exp"] = time.Now().UTC().Add(j.RefreshExpiry).Unix()
 
 	// create a signed refresh token
-	signedRefreshToken, err := refreshToken.SignedString([]byte(j.Secret))
+	signedRefreshToken, err := j.Keys.Sign(refreshToken)
 	if err != nil {
 		return TokenPairs{}, err
 	}
@@ -96,6 +223,45 @@ func (j *Auth) GenerateTokenPair(user *jwtUser) (TokenPairs, error) {
 	return tokenPairs, nil
 }
 
+// GenerateMFAToken issues the short lived token a user gets after passing the first step of a login
+// (their password, or single sign-on) when they have two-factor authentication turned on. amr is how
+// they passed it. It is only good for exchanging, together with a valid one-time code, for a real token pair
+func (j *Auth) GenerateMFAToken(userID int, amr []string, expiry time.Duration) (string, error) {
+	token := j.Keys.NewToken()
+
+	claims := token.Claims.(jwt.MapClaims)
+	claims["sub"] = fmt.Sprint(userID)
+	claims["aud"] = j.Audience
+	claims["iss"] = j.Issuer
+	claims["iat"] = time.Now().UTC().Unix()
+	claims["nbf"] = time.Now().UTC().Unix()
+	claims["exp"] = time.Now().UTC().Add(expiry).Unix()
+	claims["token_type"] = TokenTypeMFA
+	claims["amr"] = amr
+
+	return j.Keys.Sign(token)
+}
+
+// GenerateImpersonationToken issues an access token for user that carries actorID in its 'act' claim.
+// There's no refresh token to go with it, so it's only good until it expires
+func (j *Auth) GenerateImpersonationToken(user *jwtUser, actorID int, expiry time.Duration) (string, error) {
+	token := j.Keys.NewToken()
+
+	claims := token.Claims.(jwt.MapClaims)
+	claims["name"] = fmt.Sprintf("%s %s", user.FirstName, user.LastName)
+	claims["sub"] = fmt.Sprint(user.ID)
+	claims["aud"] = j.Audience
+	claims["iss"] = j.Issuer
+	claims["role"] = user.Role
+	claims["iat"] = time.Now().UTC().Unix()
+	claims["nbf"] = time.Now().UTC().Unix()
+	claims["exp"] = time.Now().UTC().Add(expiry).Unix()
+	claims["token_type"] = TokenTypeAccess
+	claims["act"] = map[string]string{"sub": fmt.Sprint(actorID)}
+
+	return j.Keys.Sign(token)
+}
+
 func (j *Auth) GetRefreshCookie(refreshToken string) *http.Cookie {
 	return &http.Cookie{
 		Name:    j.CookieName,
@@ -133,7 +299,8 @@ func (j *Auth) GetExpiredRefreshCookie() *http.Cookie {
 }
 
 // GetTokenFromHeaderAndVerify is a handy function that can be used allover our app to restrict user access to
-// routes. A handy place to call it is in middleware
+// routes. A handy place to call it is in middleware. For impersonation tokens the claims carry both
+// identities: the user being impersonated in Subject & the admin acting as them in Actor
 func (j *Auth) GetTokenFromHeaderAndVerify(w http.ResponseWriter, r *http.Request) (string, *Claims, error) {
 	// add a header to our response
 	w.Header().Add("Vary", "Authorization")
@@ -143,47 +310,99 @@ func (j *Auth) GetTokenFromHeaderAndVerify(w http.ResponseWriter, r *http.Reques
 
 	// sanity check
 	if authHeader == "" {
-		return "", nil, errors.New("no auth header")
+		return "", nil, ErrNoAuthHeader
 	}
 
 	// split the header on spaces-coz we expect to see the word 'Bearer' followed by space, followed by the JWT
 	headerParts := strings.Split(authHeader, " ")
 	if len(headerParts) != 2 {
-		return "", nil, errors.New("invalid auth header")
+		return "", nil, ErrInvalidAuthHeader
 	}
 
 	// check if header includes the word 'Bearer' at the first index of the split header content
 	if headerParts[0] != "Bearer" {
-		return "", nil, errors.New("invalid auth header")
+		return "", nil, ErrInvalidAuthHeader
 	}
 
 	// at this point we have the word 'Bearer' in the header
 	token := headerParts[1]
 
+	// only access tokens may be used as bearer tokens
+	claims, err := j.ValidateToken(token, TokenTypeAccess)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, claims, nil
+}
+
+// ValidateToken is the one place we check tokens we issued, whether they came from the Authorization
+// header or the refresh cookie. On top of the signature, it checks the issuer, audience, exp, nbf & iat
+// (allowing for Leeway of clock skew) and that the token is of the expected type
+func (j *Auth) ValidateToken(token, tokenType string) (*Claims, error) {
 	// declare an empty claims into which we will read our claims
 	claims := &Claims{}
 
 	// parse the token
-	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		// validate the signing mechanism
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
-		}
-		return []byte(j.Secret), nil
-	})
+	// the key ring picks the key by the token's 'kid' header & rejects any algorithm that doesn't
+	// belong to that key
+	_, err := jwt.ParseWithClaims(token, claims, j.Keys.Keyfunc,
+		jwt.WithValidMethods(j.Keys.Algorithms()),
+		jwt.WithIssuer(j.Issuer),
+		jwt.WithAudience(j.Audience),
+		jwt.WithLeeway(j.Leeway),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
 	// check for errors (this will include expired tokens)
 	if err != nil {
-		// does the error have thje prefix 'token is expired by'
-		if strings.HasPrefix(err.Error(), "token is expired by") {
-			return "", nil, errors.New("expired token")
+		switch {
+		case errors.Is(err, jwt.ErrTokenExpired):
+			return nil, ErrTokenExpired
+		case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+			return nil, ErrTokenNotYetValid
+		case errors.Is(err, jwt.ErrTokenInvalidAudience):
+			return nil, ErrTokenAudience
+		case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+			return nil, ErrTokenIssuer
+		default:
+			return nil, fmt.Errorf("%w: %v", ErrTokenInvalid, err)
 		}
-		return "", nil, err
 	}
 
-	// confirm that this token was actually issue by us
-	if claims.Issuer != j.Issuer {
-		return "", nil, errors.New("invalid issuer")
+	if claims.TokenType != tokenType {
+		return nil, ErrTokenWrongType
 	}
 
-	return token, claims, nil
+	// only access tokens are ever issued with an actor, and the actor must say who they are
+	if claims.Actor != nil && (tokenType != TokenTypeAccess || claims.Actor.Subject == "") {
+		return nil, ErrTokenInvalid
+	}
+
+	return claims, nil
+}
+
+// WWWAuthenticate builds the WWW-Authenticate header (RFC 6750) telling the client why its token was refused
+func (j *Auth) WWWAuthenticate(err error) string {
+	realm := fmt.Sprintf(`Bearer realm="%s"`, j.Audience)
+
+	switch {
+	case errors.Is(err, ErrNoAuthHeader):
+		// the client didn't try to authenticate, so there's no error to report
+		return realm
+	case errors.Is(err, ErrInvalidAuthHeader):
+		return realm + `, error="invalid_request", error_description="malformed authorization header"`
+	case errors.Is(err, ErrTokenExpired):
+		return realm + `, error="invalid_token", error_description="the access token expired"`
+	case errors.Is(err, ErrTokenNotYetValid):
+		return realm + `, error="invalid_token", error_description="the access token is not valid yet"`
+	case errors.Is(err, ErrTokenWrongType):
+		return realm + `, error="invalid_token", error_description="not an access token"`
+	case errors.Is(err, ErrTokenAudience):
+		return realm + `, error="invalid_token", error_description="the access token is not meant for this audience"`
+	case errors.Is(err, ErrTokenIssuer):
+		return realm + `, error="invalid_token", error_description="the access token was issued by someone else"`
+	default:
+		return realm + `, error="invalid_token"`
+	}
 }
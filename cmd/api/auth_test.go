@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// accessClaims are the claims of a valid access token, for a test to spoil
+func accessClaims(auth Auth) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"sub":        "1",
+		"aud":        auth.Audience,
+		"iss":        auth.Issuer,
+		"iat":        now.Unix(),
+		"nbf":        now.Unix(),
+		"exp":        now.Add(auth.TokenExpiry).Unix(),
+		"token_type": TokenTypeAccess,
+	}
+}
+
+// signed signs claims with the ring's current key
+func signed(t *testing.T, keys *KeyRing, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := keys.NewToken()
+	token.Claims = claims
+	s, err := keys.Sign(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestAuthRequiredWWWAuthenticate(t *testing.T) {
+	auth := newTestAuth(t)
+	// an old HMAC key being rotated out makes HS256 an accepted algorithm, so it's down to the key ring
+	// to refuse HS256 tokens claiming to be from the EdDSA key
+	auth.Keys.AddVerificationKey(newHMACKey("old secret"))
+	realm := `Bearer realm="example.com"`
+
+	with := func(change func(jwt.MapClaims)) string {
+		claims := accessClaims(auth)
+		change(claims)
+		return "Bearer " + signed(t, auth.Keys, claims)
+	}
+	inFuture := time.Now().Add(auth.Leeway + time.Minute).Unix()
+
+	// a token from a key we don't know
+	stranger := newTestAuth(t)
+	unknownKid := "Bearer " + signed(t, stranger.Keys, accessClaims(auth))
+
+	// an HMAC token claiming to be from our EdDSA key, signed with its public key as the secret
+	signing := auth.Keys.signing
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims(auth))
+	forged.Header["kid"] = signing.ID
+	forgedToken, err := forged.SignedString([]byte(signing.verifyKey.(ed25519.PublicKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		want   string
+		status int
+	}{
+		{"valid", with(func(jwt.MapClaims) {}), "", http.StatusOK},
+		{"nbf within the leeway", with(func(c jwt.MapClaims) { c["nbf"] = time.Now().Add(auth.Leeway / 2).Unix() }), "", http.StatusOK},
+		{"no header", "", realm, http.StatusUnauthorized},
+		{"malformed header", "Bearer", realm + `, error="invalid_request", error_description="malformed authorization header"`, http.StatusUnauthorized},
+		{"not a bearer token", "Token abc", realm + `, error="invalid_request", error_description="malformed authorization header"`, http.StatusUnauthorized},
+		{"expired", with(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }), realm + `, error="invalid_token", error_description="the access token expired"`, http.StatusUnauthorized},
+		{"nbf in the future", with(func(c jwt.MapClaims) { c["nbf"] = inFuture }), realm + `, error="invalid_token", error_description="the access token is not valid yet"`, http.StatusUnauthorized},
+		{"iat in the future", with(func(c jwt.MapClaims) { c["iat"] = inFuture }), realm + `, error="invalid_token", error_description="the access token is not valid yet"`, http.StatusUnauthorized},
+		{"refresh token", with(func(c jwt.MapClaims) { c["token_type"] = TokenTypeRefresh }), realm + `, error="invalid_token", error_description="not an access token"`, http.StatusUnauthorized},
+		{"wrong audience", with(func(c jwt.MapClaims) { c["aud"] = "someone-else.com" }), realm + `, error="invalid_token", error_description="the access token is not meant for this audience"`, http.StatusUnauthorized},
+		{"wrong issuer", with(func(c jwt.MapClaims) { c["iss"] = "someone-else.com" }), realm + `, error="invalid_token", error_description="the access token was issued by someone else"`, http.StatusUnauthorized},
+		{"unknown kid", unknownKid, realm + `, error="invalid_token"`, http.StatusUnauthorized},
+		{"alg mismatch", "Bearer " + forgedToken, realm + `, error="invalid_token"`, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t)
+			app.auth = auth
+
+			r := httptest.NewRequest("GET", "/admin/movies", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+
+			app.authRequired(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})).ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Errorf("got status %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("WWW-Authenticate"); got != tt.want {
+				t.Errorf("got WWW-Authenticate %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
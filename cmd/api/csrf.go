@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Routes authenticated by the refresh cookie (/refresh & /logout) are protected from cross-site request
+// forgery twice over: the request must come from one of our allowed origins, and it must carry a
+// double-submit token, ie. the X-CSRF-Token header must match the gus_csrf_token cookie. Another site can
+// make the browser send our cookies, but it can't read them to put the token in a header
+const (
+	csrfCookieName = "gus_csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+)
+
+var errCSRF = errors.New("forbidden: cross-site request refused")
+
+// csrfProtect refuses cross-site requests to cookie authenticated routes
+func (app *application) csrfProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.sameOrigin(r) {
+			app.errorJSON(w, errCSRF, http.StatusForbidden)
+			return
+		}
+
+		cookie, err := r.Cookie(csrfCookieName)
+		header := r.Header.Get(csrfHeaderName)
+		if err != nil || cookie.Value == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) != 1 {
+			app.errorJSON(w, errors.New("forbidden: missing or invalid csrf token"), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sameOrigin checks the Origin header, or the Referer when there is no Origin, against the allow-list.
+// Requests with neither are refused, browsers always send at least one of them on a POST
+func (app *application) sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		referer, err := url.Parse(r.Header.Get("Referer"))
+		if err != nil || referer.Host == "" {
+			return false
+		}
+		origin = referer.Scheme + "://" + referer.Host
+	}
+
+	return app.allowedOrigin(origin)
+}
+
+// allowedOrigin reports whether origin (eg. "http://localhost:3000") is on the -allowed-origins list
+func (app *application) allowedOrigin(origin string) bool {
+	for _, allowed := range app.AllowedOrigins {
+		if strings.EqualFold(origin, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// setCSRFCookie starts a new double-submit token. JS on the frontend can read it when it shares our
+// domain, otherwise it fetches it from /csrf-token
+func (app *application) setCSRFCookie(w http.ResponseWriter) (string, error) {
+	token, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Path:     "/",
+		Value:    token,
+		Expires:  time.Now().Add(app.auth.RefreshExpiry),
+		MaxAge:   int(app.auth.RefreshExpiry.Seconds()),
+		SameSite: http.SameSiteLaxMode,
+		HttpOnly: false, // the frontend has to be able to read it
+		Secure:   true,
+	})
+
+	return token, nil
+}
+
+// expiredCSRFCookie deletes the double-submit token on logout
+func (app *application) expiredCSRFCookie() *http.Cookie {
+	return &http.Cookie{
+		Name:     csrfCookieName,
+		Path:     "/",
+		Value:    "",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   true,
+	}
+}
+
+// csrfToken gives the frontend its double-submit token, starting one if there isn't one yet. Only our
+// allowed origins can read the response (see enableCORS), so handing it out here is safe
+func (app *application) csrfToken(w http.ResponseWriter, r *http.Request) {
+	var token string
+
+	cookie, err := r.Cookie(csrfCookieName)
+	if err == nil && cookie.Value != "" {
+		token = cookie.Value
+	} else {
+		token, err = app.setCSRFCookie(w)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var payload = struct {
+		CSRFToken string `json:"csrf_token"`
+	}{
+		CSRFToken: token,
+	}
+
+	// don't let caches hand one user's token to another
+	headers := http.Header{}
+	headers.Set("Cache-Control", "no-store")
+
+	_ = app.writeJSON(w, http.StatusOK, payload, headers)
+}
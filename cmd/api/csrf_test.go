@@ -0,0 +1,56 @@
+package main
+
+import (
+	"backend/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRefreshCSRF(t *testing.T) {
+	const csrfToken = "double-submit-token"
+
+	tests := []struct {
+		name    string
+		origin  string
+		referer string
+		header  string
+		want    int
+	}{
+		{"same origin with a matching token", "http://localhost:3000", "", csrfToken, http.StatusOK},
+		{"foreign origin", "https://evil.example", "", csrfToken, http.StatusForbidden},
+		{"referer when there's no origin", "", "http://localhost:3000/movies", csrfToken, http.StatusOK},
+		{"foreign referer", "", "https://evil.example/movies", csrfToken, http.StatusForbidden},
+		{"neither origin nor referer", "", "", csrfToken, http.StatusForbidden},
+		{"missing token", "http://localhost:3000", "", "", http.StatusForbidden},
+		{"mismatched token", "http://localhost:3000", "", "another-token", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t)
+			app.DB = &stubDB{users: map[int]*models.User{1: {ID: 1, Role: models.RoleViewer}}}
+			tokens := issueTestTokens(t, app, "family")
+
+			r := httptest.NewRequest("POST", "/refresh", nil)
+			r.AddCookie(&http.Cookie{Name: app.auth.CookieName, Value: tokens.RefreshToken})
+			r.AddCookie(&http.Cookie{Name: csrfCookieName, Value: csrfToken})
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.referer != "" {
+				r.Header.Set("Referer", tt.referer)
+			}
+			if tt.header != "" {
+				r.Header.Set(csrfHeaderName, tt.header)
+			}
+
+			w := httptest.NewRecorder()
+			app.routes().ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("got %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
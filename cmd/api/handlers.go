@@ -14,7 +14,6 @@ import (
 	"time"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/golang-jwt/jwt/v5"
 )
 
 func (app *application) Home(writer http.ResponseWriter, reader *http.Request) {
@@ -31,14 +30,33 @@ func (app *application) Home(writer http.ResponseWriter, reader *http.Request) {
 	_ = app.writeJSON(writer, http.StatusOK, payload)
 }
 
+// AllMovies lists the catalogue a page at a time, see movieQueryFromURL for the parameters
 func (app *application) AllMovies(writer http.ResponseWriter, reader *http.Request) {
-	movies, err := app.DB.AllMovies()
+	query, err := movieQueryFromURL(reader)
 	if err != nil {
 		app.errorJSON(writer, err)
 		return
 	}
 
-	_ = app.writeJSON(writer, http.StatusOK, movies)
+	app.listMovies(writer, reader, query)
+}
+
+// SearchMovies is a full text search of movie titles & descriptions, eg. /movies/search?q="star wars" -clone.
+// Results come best match first (unless sorted otherwise), with the matching words highlighted, and take
+// the same paging & filter parameters as AllMovies
+func (app *application) SearchMovies(w http.ResponseWriter, r *http.Request) {
+	query, err := movieQueryFromURL(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if query.Search == "" {
+		app.errorJSON(w, errors.New("q is required"))
+		return
+	}
+
+	app.listMovies(w, r, query)
 }
 
 // authenticate is responsible for handling authentication for our application
@@ -54,9 +72,20 @@ func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 		app.errorJSON(w, err, http.StatusBadRequest)
 		return
 	}
+	email := normalizeEmail(requestPayload.Email)
+
+	// refuse straight away if this account or client has failed too often recently
+	err = app.checkLoginAllowed(r, email)
+	if err != nil {
+		app.throttledJSON(w, err)
+		return
+	}
+
 	// validate user against the DB
-	user, err := app.DB.GetUserByEmail(requestPayload.Email)
+	user, err := app.DB.GetUserByEmail(email)
 	if err != nil {
+		app.burnPasswordCheck(requestPayload.Password)
+		app.recordLoginFailure(r, email)
 		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
 		return
 	}
@@ -64,103 +93,222 @@ func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 	// check the user-supplied password matches whats in the DB
 	valid, err := user.PasswordMatches(requestPayload.Password)
 	if err != nil || !valid {
+		app.recordLoginFailure(r, email)
 		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
 		return
 	}
 
+	// this is the only time we see the plain text password, so it's when old hashes get upgraded
+	app.upgradePasswordHash(user, requestPayload.Password)
+
+	// only checked after the password, so these can't be used to find out which emails have accounts
+	if user.DisabledAt != nil {
+		app.errorJSON(w, errAccountDisabled, http.StatusForbidden)
+		return
+	}
+
+	if app.RequireVerifiedEmail && user.EmailVerifiedAt == nil {
+		app.errorJSON(w, errors.New("please verify your email address before logging in"), http.StatusForbidden)
+		return
+	}
+
+	// with two-factor authentication on, the password alone only gets the user a challenge token,
+	// which they exchange together with a one-time code at /authenticate/mfa
+	if user.TOTPEnabledAt != nil {
+		mfaToken, err := app.auth.GenerateMFAToken(user.ID, []string{AMRPassword}, mfaTokenExpiry)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+
+		var payload = struct {
+			MFARequired bool   `json:"mfa_required"`
+			MFAToken    string `json:"mfa_token"`
+		}{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+		}
+
+		app.writeJSON(w, http.StatusAccepted, payload)
+		return
+	}
+
+	app.logUserIn(w, r, user, []string{AMRPassword})
+}
+
+// logUserIn issues a token pair for a user that has passed every step of logging in, sets the refresh
+// cookie & sends the tokens back. amr records how they authenticated
+func (app *application) logUserIn(w http.ResponseWriter, r *http.Request, user *models.User, amr []string) {
+	tokens, err := app.startSession(w, r, user, amr)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusAccepted, tokens)
+}
+
+// upgradePasswordHash rehashes the user's password if the stored hash was made with an older algorithm
+// or weaker settings than the current ones. Failing to upgrade doesn't stop the login
+func (app *application) upgradePasswordHash(user *models.User, plainText string) {
+	if !app.Hasher.NeedsRehash(user.Password) {
+		return
+	}
+
+	err := app.DB.UpdatePassword(user.ID, plainText)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// startSession issues a new token pair for the user & sets the refresh cookie. Every login starts a
+// new refresh token family, which is recorded as a session so the user can see & revoke it
+func (app *application) startSession(w http.ResponseWriter, r *http.Request, user *models.User, amr []string) (TokenPairs, error) {
+	app.recordLoginSuccess(user.Email)
+
 	//create jwt user
 	u := jwtUser{
 		ID:        user.ID,
 		FirstName: user.FirstName,
 		LastName:  user.LastName,
+		Role:      user.Role,
+		AMR:       amr,
+	}
+
+	familyID, err := randomString(16)
+	if err != nil {
+		return TokenPairs{}, err
+	}
+
+	err = app.Tokens.InsertSession(models.Session{
+		ID:        familyID,
+		UserID:    user.ID,
+		UserAgent: sessionUserAgent(r),
+		IP:        clientIP(r),
+		ExpiresAt: time.Now().Add(app.auth.RefreshExpiry),
+	})
+	if err != nil {
+		return TokenPairs{}, err
 	}
 
 	// generate tokens
-	tokens, err := app.auth.GenerateTokenPair(&u)
+	tokens, err := app.issueTokens(&u, familyID)
 	if err != nil {
-		app.errorJSON(w, err)
-		return
+		return TokenPairs{}, err
 	}
 
 	// NOTES: get the cookie & send it back with our response
 	refreshCookie := app.auth.GetRefreshCookie(tokens.RefreshToken)
 	http.SetCookie(w, refreshCookie)
 
-	// NOTES: How to write to the browser
-	/////w.Write([]byte(tokens.Token))
+	// a fresh csrf token for the new session
+	_, err = app.setCSRFCookie(w)
+	if err != nil {
+		return TokenPairs{}, err
+	}
 
-	/////log.Println("TOKEN IS: ", tokens.Token)
-	/////log.Println("THE WHOLE TOKEN OBJECT IS: ", tokens)
-	app.writeJSON(w, http.StatusAccepted, tokens)
+	return tokens, nil
 }
 
 func (app *application) refreshToken(w http.ResponseWriter, r *http.Request) {
-	// to get the cookie we want, we must range thru all existing cookies
-	for _, cookie := range r.Cookies() {
-		if cookie.Name == app.auth.CookieName {
-			claims := &Claims{}
-			refreshToken := cookie.Value
-
-			// parse the token to get the claims.
-			// NOTES: The JWTSecret is the unique custom signature (string) for the JWT which we set when creating
-			//	the command line flag vars in main.go (we set its value to "verysecret")
-			_, err := jwt.ParseWithClaims(refreshToken, claims, func(token *jwt.Token) (interface{}, error) {
-				return []byte(app.JWTSecret), nil
-			})
-			if err != nil {
-				app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
-				return
-			}
+	cookie, err := r.Cookie(app.auth.CookieName)
+	if err != nil {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
 
-			// get the user id from the token claims
-			userID, err := strconv.Atoi(claims.Subject)
-			if err != nil {
-				app.errorJSON(w, errors.New("unknown user"), http.StatusUnauthorized)
-				return
-			}
+	refreshToken := cookie.Value
 
-			user, err := app.DB.GetUserById(userID)
-			if err != nil {
-				app.errorJSON(w, errors.New("unknown user"), http.StatusUnauthorized)
-				return
-			}
+	// validate the token to get the claims. Access tokens are refused here
+	claims, err := app.auth.ValidateToken(refreshToken, TokenTypeRefresh)
+	if err != nil {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
 
-			u := jwtUser{
-				ID:        userID,
-				FirstName: user.FirstName,
-				LastName:  user.LastName,
-			}
+	// a valid signature isn't enough, the token must also be one we issued, haven't revoked & that
+	// hasn't been exchanged before. From here on this token can't be used again
+	stored, err := app.useRefreshToken(refreshToken)
+	if err != nil {
+		http.SetCookie(w, app.auth.GetExpiredRefreshCookie())
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
 
-			tokenPairs, err := app.auth.GenerateTokenPair(&u)
-			if err != nil {
-				app.errorJSON(w, errors.New("error generating tokens"), http.StatusUnauthorized)
-				return
-			}
+	// get the user id from the token claims
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil || userID != stored.UserID {
+		app.errorJSON(w, errors.New("unknown user"), http.StatusUnauthorized)
+		return
+	}
 
-			http.SetCookie(w, app.auth.GetRefreshCookie(tokenPairs.RefreshToken))
-			// send back json data
-			app.writeJSON(w, http.StatusOK, tokenPairs)
-		}
+	user, err := app.DB.GetUserById(userID)
+	if err != nil {
+		app.errorJSON(w, errors.New("unknown user"), http.StatusUnauthorized)
+		return
+	}
+
+	if user.DisabledAt != nil {
+		http.SetCookie(w, app.auth.GetExpiredRefreshCookie())
+		app.errorJSON(w, errAccountDisabled, http.StatusUnauthorized)
+		return
+	}
+
+	u := jwtUser{
+		ID:        userID,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Role:      user.Role, // re-read on every refresh so role changes take effect
+		AMR:       claims.AMR,
+	}
+
+	// the new refresh token stays in the same family as the one it replaces
+	tokenPairs, err := app.issueTokens(&u, stored.FamilyID)
+	if err != nil {
+		app.errorJSON(w, errors.New("error generating tokens"), http.StatusUnauthorized)
+		return
 	}
+
+	err = app.Tokens.TouchSession(stored.FamilyID, clientIP(r), sessionUserAgent(r), time.Now().Add(app.auth.RefreshExpiry))
+	if err != nil {
+		log.Println(err)
+	}
+
+	http.SetCookie(w, app.auth.GetRefreshCookie(tokenPairs.RefreshToken))
+	// send back json data
+	app.writeJSON(w, http.StatusOK, tokenPairs)
 }
 
-// logout forces the browser to delete the existing jwt cookie
+// logout revokes the refresh token (and every token rotated from the same login) server side,
+// then forces the browser to delete the existing jwt cookie
 func (app *application) logout(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(app.auth.CookieName)
+	if err == nil {
+		stored, err := app.Tokens.GetRefreshTokenByHash(hashToken(cookie.Value))
+		if err == nil {
+			err = app.Tokens.RevokeRefreshTokenFamily(stored.FamilyID)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}
+
 	http.SetCookie(w, app.auth.GetExpiredRefreshCookie())
+	http.SetCookie(w, app.expiredCSRFCookie())
 	w.WriteHeader(http.StatusAccepted)
 }
 
 func (app *application) MovieCatalog(w http.ResponseWriter, r *http.Request) {
-	movies, err := app.DB.AllMovies()
+	query, err := movieQueryFromURL(r)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	_ = app.writeJSON(w, http.StatusOK, movies)
+	app.listMovies(w, r, query)
 }
 
-// GetMovie gets a movie only-no genres & hends it back as a json object
+// GetMovie gets a movie with its genres, cast & crew & hends it back as a json object
 func (app *application) GetMovie(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	movieID, err := strconv.Atoi(id)
@@ -175,6 +323,13 @@ func (app *application) GetMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	credits, err := app.DB.MovieCredits(movieID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	movie.Cast, movie.Crew = splitCredits(credits)
+
 	_ = app.writeJSON(w, http.StatusOK, movie)
 }
 
@@ -242,6 +397,13 @@ func (app *application) InsertMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	created, err := app.DB.OneMovie(newID)
+	if err == nil {
+		app.audit(r, AuditMovieCreate, "movie", newID, nil, created)
+	} else {
+		log.Println(err)
+	}
+
 	resp := JSONResponse{
 		Error:   false,
 		Message: "movie updated",
@@ -257,7 +419,7 @@ func (app *application) GetPoster(movie models.Movie) models.Movie {
 		Results []struct {
 			PosterPath string `json:"poster_path"`
 		} `json:"results"`
-		TotalPages int `int"total_pages"`
+		TotalPages int `json:"total_pages"`
 	}
 
 	client := &http.Client{}
@@ -309,6 +471,9 @@ func (app *application) UpdateMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// keep a copy of the movie as it was, for the audit log
+	before := *movie
+
 	movie.Title = payload.Title
 	movie.ReleaseDate = payload.ReleaseDate
 	movie.Description = payload.Description
@@ -328,6 +493,13 @@ func (app *application) UpdateMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	after, err := app.DB.OneMovie(movie.ID)
+	if err == nil {
+		app.audit(r, AuditMovieUpdate, "movie", movie.ID, before, after)
+	} else {
+		log.Println(err)
+	}
+
 	resp := JSONResponse{
 		Error:   false,
 		Message: "movie updated",
@@ -343,12 +515,20 @@ func (app *application) DeleteMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	movie, err := app.DB.OneMovie(id)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
 	err = app.DB.DeleteMovie(id)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
+	app.audit(r, AuditMovieDelete, "movie", id, movie, nil)
+
 	resp := JSONResponse{
 		Error:   false,
 		Message: "movie deleted",
@@ -364,33 +544,29 @@ func (app *application) AllMoviesByGenre(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	movies, err := app.DB.AllMovies(id)
-
+	query, err := movieQueryFromURL(r)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
+	query.Genres = []int{id}
 
-	app.writeJSON(w, http.StatusOK, movies)
-
+	app.listMovies(w, r, query)
 }
 
 func (app *application) MoviesGraphQL(w http.ResponseWriter, r *http.Request) {
-	// we need to populate our Graph type with the movies
-	movies, _ := app.DB.AllMovies()
-
 	// get the query from the request
 	q, _ := io.ReadAll(r.Body)
 	query := string(q)
 
-	// create a new variable of type *Graph.Graph & pass it the movies data
-	g := graph.New(movies)
+	// create a new variable of type *Graph.Graph & pass it the repository to load movies from
+	g := graph.New(app.DB)
 
 	// set the query string on the variable
 	g.QueryString = query
 
 	// perform the query
-	resp, err := g.Query()
+	resp, err := g.Query(r.Context())
 	if err != nil {
 		app.errorJSON(w, err)
 		return
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"backend/internal/models"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+var errAccountDisabled = errors.New("this account has been disabled")
+
+// AllUsers lists users for admins, eg. /admin/users?q=jo&role=editor&page=2&page_size=50
+func (app *application) AllUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	query := models.UserQuery{
+		Search: q.Get("q"),
+		Role:   q.Get("role"),
+	}
+
+	if query.Role != "" && !models.ValidRole(query.Role) {
+		app.errorJSON(w, errors.New("unknown role"), http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	query.Page, query.PageSize, err = pageParams(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	users, total, err := app.DB.AllUsers(query)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if users == nil {
+		users = []*models.User{}
+	}
+
+	var payload = struct {
+		Users    []*models.User `json:"users"`
+		Page     int            `json:"page"`
+		PageSize int            `json:"page_size"`
+		Total    int            `json:"total"`
+	}{
+		Users:    users,
+		Page:     query.Page,
+		PageSize: query.PageSize,
+		Total:    total,
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// GetUser returns one user for admins
+func (app *application) GetUser(w http.ResponseWriter, r *http.Request) {
+	user, err := app.userFromURL(r)
+	if err != nil {
+		app.userErrorJSON(w, err)
+		return
+	}
+
+	// users aren't told they are shadow hidden, so only this admin view shows it
+	var payload = struct {
+		*models.User
+		ShadowHiddenAt *time.Time `json:"shadow_hidden_at"`
+	}{
+		User:           user,
+		ShadowHiddenAt: user.ShadowHiddenAt,
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// InsertUser lets admins create an account with any role. Leave the password out to invite the user
+// instead: they get a password reset email to choose their own
+func (app *application) InsertUser(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+		Email     string `json:"email"`
+		Role      string `json:"role"`
+		Password  string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user := models.User{
+		FirstName: strings.TrimSpace(payload.FirstName),
+		LastName:  strings.TrimSpace(payload.LastName),
+		Email:     normalizeEmail(payload.Email),
+		Role:      payload.Role,
+		Password:  payload.Password,
+	}
+
+	if user.FirstName == "" || user.LastName == "" {
+		app.errorJSON(w, errors.New("first name and last name are required"), http.StatusBadRequest)
+		return
+	}
+
+	if err := validateEmail(user.Email); err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if user.Role == "" {
+		user.Role = models.RoleViewer
+	}
+	if !models.ValidRole(user.Role) {
+		app.errorJSON(w, errors.New("unknown role"), http.StatusBadRequest)
+		return
+	}
+
+	invite := user.Password == ""
+	if invite {
+		user.Password, err = randomString(32)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	} else if err := app.validatePassword(user.Password); err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	newID, err := app.DB.InsertUser(user)
+	if err != nil {
+		app.userErrorJSON(w, err)
+		return
+	}
+
+	created, err := app.DB.GetUserById(newID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, AuditUserCreate, "user", created.ID, nil, created)
+
+	message := "user created"
+	if invite {
+		message = "user created & invited to choose a password"
+		err = app.sendPasswordReset(created)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: message,
+		Data:    created,
+	}
+
+	app.writeJSON(w, http.StatusCreated, resp)
+}
+
+// UpdateUser lets admins change a user's names, email & role. Admins can't change their own role, so
+// they can't accidentally lock themselves out
+func (app *application) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	user, err := app.userFromURL(r)
+	if err != nil {
+		app.userErrorJSON(w, err)
+		return
+	}
+
+	before := *user
+
+	var payload struct {
+		FirstName *string `json:"first_name"`
+		LastName  *string `json:"last_name"`
+		Email     *string `json:"email"`
+		Role      *string `json:"role"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if payload.FirstName != nil {
+		user.FirstName = strings.TrimSpace(*payload.FirstName)
+	}
+	if payload.LastName != nil {
+		user.LastName = strings.TrimSpace(*payload.LastName)
+	}
+	emailChanged := false
+	if payload.Email != nil {
+		emailChanged = normalizeEmail(*payload.Email) != user.Email
+		user.Email = normalizeEmail(*payload.Email)
+	}
+	roleChanged := false
+	if payload.Role != nil && *payload.Role != user.Role {
+		if !models.ValidRole(*payload.Role) {
+			app.errorJSON(w, errors.New("unknown role"), http.StatusBadRequest)
+			return
+		}
+		if app.isCurrentUser(r, user.ID) {
+			app.errorJSON(w, errors.New("you can't change your own role"), http.StatusBadRequest)
+			return
+		}
+		roleChanged = true
+		user.Role = *payload.Role
+	}
+
+	if user.FirstName == "" || user.LastName == "" {
+		app.errorJSON(w, errors.New("first name and last name are required"), http.StatusBadRequest)
+		return
+	}
+
+	if err := validateEmail(user.Email); err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// a reset link sent to the old address mustn't be able to verify the new one
+	if emailChanged {
+		err = app.DB.DeleteUserTokens(user.ID, models.TokenPurposePasswordReset)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = app.DB.UpdateUser(*user)
+	if err != nil {
+		app.userErrorJSON(w, err)
+		return
+	}
+
+	if roleChanged {
+		err = app.DB.SetUserRole(user.ID, user.Role)
+		if err != nil {
+			app.userErrorJSON(w, err)
+			return
+		}
+	}
+
+	if emailChanged {
+		user.EmailVerifiedAt = nil
+		err = app.sendEmailVerification(user)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	app.audit(r, AuditUserUpdate, "user", user.ID, before, user)
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "user updated",
+		Data:    user,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// DisableUser stops a user logging in & signs them out everywhere. Their access tokens stop working
+// on the account endpoints straight away, and everywhere else within 15 minutes
+func (app *application) DisableUser(w http.ResponseWriter, r *http.Request) {
+	app.setUserDisabled(w, r, true)
+}
+
+// EnableUser lets a disabled user log in again
+func (app *application) EnableUser(w http.ResponseWriter, r *http.Request) {
+	app.setUserDisabled(w, r, false)
+}
+
+func (app *application) setUserDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	user, err := app.userFromURL(r)
+	if err != nil {
+		app.userErrorJSON(w, err)
+		return
+	}
+
+	if app.isCurrentUser(r, user.ID) {
+		app.errorJSON(w, errors.New("you can't disable or enable yourself"), http.StatusBadRequest)
+		return
+	}
+
+	err = app.DB.SetUserDisabled(user.ID, disabled)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	message, action := "user enabled", AuditUserEnable
+	if disabled {
+		message, action = "user disabled", AuditUserDisable
+		err = app.Tokens.RevokeUserRefreshTokens(user.ID)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	after, err := app.DB.GetUserById(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, action, "user", user.ID, user, after)
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: message,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// ForcePasswordReset throws the user's password away, signs them out everywhere & emails them a
+// password reset link, eg. when their password may have leaked
+func (app *application) ForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	user, err := app.userFromURL(r)
+	if err != nil {
+		app.userErrorJSON(w, err)
+		return
+	}
+
+	password, err := randomString(32)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.DB.UpdatePassword(user.ID, password)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.Tokens.RevokeUserRefreshTokens(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// the hash is never logged, so there's no before & after, just the fact it happened
+	app.audit(r, AuditUserPasswordReset, "user", user.ID, nil, nil)
+
+	err = app.sendPasswordReset(user)
+	if err != nil {
+		app.errorJSON(w, errors.New("password cleared, but the reset email could not be sent"), http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "password reset email sent",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// DeleteUser deletes a user, handing their API keys and public & unlisted lists over to the user in
+// ?reassign_to=, or to the admin doing the deleting. Keys only work for an enabled admin, so if the user
+// has active ones, reassign_to must be one. Their private lists, reviews, watchlist & watch history are
+// theirs alone, so go with them
+func (app *application) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	user, err := app.userFromURL(r)
+	if err != nil {
+		app.userErrorJSON(w, err)
+		return
+	}
+
+	if app.isCurrentUser(r, user.ID) {
+		app.errorJSON(w, errors.New("you can't delete yourself here, use DELETE /me"), http.StatusBadRequest)
+		return
+	}
+
+	reassignTo, err := strconv.Atoi(claimsFromContext(r).Subject)
+	if err != nil {
+		app.errorJSON(w, errors.New("unknown user"), http.StatusUnauthorized)
+		return
+	}
+
+	if v := r.URL.Query().Get("reassign_to"); v != "" {
+		reassignTo, err = strconv.Atoi(v)
+		if err != nil || reassignTo == user.ID {
+			app.errorJSON(w, errors.New("reassign_to must be another user's id"), http.StatusBadRequest)
+			return
+		}
+
+		newOwner, err := app.DB.GetUserById(reassignTo)
+		if err != nil {
+			app.errorJSON(w, errors.New("reassign_to user not found"), http.StatusBadRequest)
+			return
+		}
+
+		// keys handed to anyone else would stop working, and they couldn't see or revoke them
+		if newOwner.DisabledAt != nil || newOwner.Role != models.RoleAdmin {
+			hasKeys, err := app.hasActiveAPIKeys(user.ID)
+			if err != nil {
+				app.errorJSON(w, err, http.StatusInternalServerError)
+				return
+			}
+			if hasKeys {
+				app.errorJSON(w, errors.New("the user has active API keys, so reassign_to must be an enabled admin"), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	err = app.DB.DeleteUserReassign(user.ID, reassignTo)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, AuditUserDelete, "user", user.ID, user, nil)
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "user deleted",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// isCurrentUser reports whether id is the user making the request
+func (app *application) isCurrentUser(r *http.Request, id int) bool {
+	claims := claimsFromContext(r)
+	return claims != nil && claims.Subject == strconv.Itoa(id)
+}
+
+// pageParams reads ?page= & ?page_size=, defaulting to the first page of defaultPageSize
+func pageParams(r *http.Request) (int, int, error) {
+	page, pageSize := 1, defaultPageSize
+
+	if v := r.URL.Query().Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, 0, errors.New("page must be a positive number")
+		}
+		page = n
+	}
+
+	if v := r.URL.Query().Get("page_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			return 0, 0, errors.New("page_size must be between 1 and 100")
+		}
+		pageSize = n
+	}
+
+	return page, pageSize, nil
+}
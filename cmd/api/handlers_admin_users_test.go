@@ -0,0 +1,73 @@
+package main
+
+import (
+	"backend/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDeleteUserReassignAPIKeys(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name       string
+		reassignTo string
+		revoked    bool
+		want       int
+	}{
+		{"to an ordinary user", "3", false, http.StatusBadRequest},
+		{"to a disabled admin", "4", false, http.StatusBadRequest},
+		{"to an enabled admin", "5", false, http.StatusAccepted},
+		{"to the deleting admin", "", false, http.StatusAccepted},
+		{"only revoked keys, to an ordinary user", "3", true, http.StatusAccepted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := &models.APIKey{ID: 1, CreatedBy: 2}
+			if tt.revoked {
+				key.RevokedAt = &past
+			}
+			db := &stubDB{
+				users: map[int]*models.User{
+					1: {ID: 1, Role: models.RoleAdmin},
+					2: {ID: 2, Role: models.RoleAdmin},
+					3: {ID: 3, Role: models.RoleViewer},
+					4: {ID: 4, Role: models.RoleAdmin, DisabledAt: &past},
+					5: {ID: 5, Role: models.RoleAdmin},
+				},
+				apiKeys: []*models.APIKey{key},
+			}
+
+			app := newTestApp(t)
+			app.DB = db
+			tokens, err := app.auth.GenerateTokenPair(&jwtUser{ID: 1, Role: models.RoleAdmin})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			target := "/admin/users/2"
+			if tt.reassignTo != "" {
+				target += "?reassign_to=" + tt.reassignTo
+			}
+			r := httptest.NewRequest("DELETE", target, nil)
+			r.Header.Set("Authorization", "Bearer "+tokens.Token)
+			w := httptest.NewRecorder()
+			app.routes().ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+
+			_, stillThere := db.users[2]
+			if deleted := w.Code == http.StatusAccepted; deleted == stillThere {
+				t.Errorf("got %d, but the user is still there: %v", w.Code, stillThere)
+			}
+			if w.Code == http.StatusBadRequest && key.CreatedBy != 2 {
+				t.Errorf("refused, but the key was handed to user %d", key.CreatedBy)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"backend/internal/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// apiKeyScheme is the Authorization header scheme for API keys
+	apiKeyScheme = "ApiKey"
+	// apiKeyTag starts every key we mint, which makes leaked keys easy to find with secret scanners
+	apiKeyTag = "gmk"
+)
+
+// verifyAPIKey checks the API key in the Authorization header and turns it into claims, so handlers
+// can treat key & JWT authenticated requests the same way
+func (app *application) verifyAPIKey(r *http.Request) (*Claims, error) {
+	raw := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), apiKeyScheme+" "))
+	if raw == "" {
+		return nil, ErrInvalidAuthHeader
+	}
+
+	key, err := app.DB.GetAPIKeyByHash(hashToken(raw))
+	if err != nil || !key.Active(time.Now()) {
+		return nil, errors.New("invalid api key")
+	}
+
+	// a key acts with its creator's authority, so it stops working once they are disabled or no longer
+	// an admin (only admins can mint keys), and starts again if that is undone
+	creator, err := app.DB.GetUserById(key.CreatedBy)
+	if err != nil || creator.DisabledAt != nil || creator.Role != models.RoleAdmin {
+		return nil, errors.New("invalid api key")
+	}
+
+	err = app.DB.TouchAPIKey(key.ID)
+	if err != nil {
+		log.Println(err)
+	}
+
+	claims := &Claims{
+		APIKeyID: key.ID,
+		Scopes:   key.Scopes,
+	}
+	claims.Subject = strconv.Itoa(key.CreatedBy)
+
+	return claims, nil
+}
+
+// hasActiveAPIKeys reports whether the user created any API keys that are neither revoked nor expired
+func (app *application) hasActiveAPIKeys(userID int) (bool, error) {
+	keys, err := app.DB.AllAPIKeys()
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	for _, key := range keys {
+		if key.CreatedBy == userID && key.Active(now) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// AllAPIKeys lists every API key, including revoked & expired ones. The keys themselves are never shown again
+func (app *application) AllAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := app.DB.AllAPIKeys()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if keys == nil {
+		keys = []*models.APIKey{}
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, keys)
+}
+
+// InsertAPIKey mints a new API key. The response is the only time the key is ever shown
+func (app *application) InsertAPIKey(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload.Name = strings.TrimSpace(payload.Name)
+	if payload.Name == "" {
+		app.errorJSON(w, errors.New("name is required"))
+		return
+	}
+
+	if len(payload.Scopes) == 0 {
+		app.errorJSON(w, fmt.Errorf("at least one scope is required, choose from %s", strings.Join(models.AllScopes, ", ")))
+		return
+	}
+	for _, scope := range payload.Scopes {
+		if !models.ValidScope(scope) {
+			app.errorJSON(w, fmt.Errorf("unknown scope %q", scope))
+			return
+		}
+	}
+
+	if payload.ExpiresAt != nil && payload.ExpiresAt.Before(time.Now()) {
+		app.errorJSON(w, errors.New("expires_at must be in the future"))
+		return
+	}
+
+	claims := claimsFromContext(r)
+	createdBy, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		app.errorJSON(w, errors.New("unknown user"), http.StatusUnauthorized)
+		return
+	}
+
+	prefix, err := randomString(4)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	secret, err := randomString(32)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	raw := fmt.Sprintf("%s_%s_%s", apiKeyTag, prefix, secret)
+
+	key := models.APIKey{
+		Name:      payload.Name,
+		Prefix:    fmt.Sprintf("%s_%s", apiKeyTag, prefix),
+		KeyHash:   hashToken(raw),
+		Scopes:    payload.Scopes,
+		CreatedBy: createdBy,
+		ExpiresAt: payload.ExpiresAt,
+	}
+
+	newID, err := app.DB.InsertAPIKey(key)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	created, err := app.DB.GetAPIKey(newID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, AuditAPIKeyCreate, "api_key", created.ID, nil, created)
+
+	var data = struct {
+		APIKey string         `json:"api_key"`
+		Key    *models.APIKey `json:"key"`
+	}{
+		APIKey: raw,
+		Key:    created,
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "api key created. Copy it now, it won't be shown again",
+		Data:    data,
+	}
+
+	app.writeJSON(w, http.StatusCreated, resp)
+}
+
+// RevokeAPIKey stops a key working straight away. Revoked keys are kept so they still show up in the list
+func (app *application) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	key, err := app.DB.GetAPIKey(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("api key not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.DB.RevokeAPIKey(id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	revoked, err := app.DB.GetAPIKey(id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, AuditAPIKeyRevoke, "api_key", id, key, revoked)
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "api key revoked",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"backend/internal/models"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// continueWatchingSize is how many movies the continue watching feed has at most
+	continueWatchingSize = 20
+	// watchedAtSkew is how far in the future a watched_at can be, to allow for clocks that are a bit fast
+	watchedAtSkew = time.Minute * 5
+)
+
+// MarkWatched records that the logged in user watched a movie, eg. {"movie_id": 1} or
+// {"movie_id": 1, "watched_at": "2024-05-04T20:30:00Z"} for one watched earlier. Every rewatch is
+// recorded, and the movie leaves continue watching
+func (app *application) MarkWatched(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	var payload struct {
+		MovieID   int        `json:"movie_id"`
+		WatchedAt *time.Time `json:"watched_at"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	movie, err := app.DB.OneMovie(payload.MovieID)
+	if err != nil {
+		app.movieErrorJSON(w, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	event := models.WatchEvent{
+		UserID:    user.ID,
+		MovieID:   movie.ID,
+		WatchedAt: now,
+	}
+
+	if payload.WatchedAt != nil {
+		if payload.WatchedAt.After(now.Add(watchedAtSkew)) {
+			app.errorJSON(w, errors.New("watched_at can't be in the future"), http.StatusBadRequest)
+			return
+		}
+		event.WatchedAt = payload.WatchedAt.UTC()
+	}
+
+	// a position still waiting to be saved would otherwise put the movie back in continue watching
+	app.Progress.Forget(user.ID, movie.ID)
+
+	id, err := app.DB.InsertWatchEvent(event)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	saved, err := app.DB.GetWatchEvent(id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "marked watched",
+		Data:    saved,
+	}
+
+	app.writeJSON(w, http.StatusCreated, resp)
+}
+
+// WatchHistory pages through what the logged in user has watched, most recent first, eg. /me/history?page=2
+func (app *application) WatchHistory(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	query := models.WatchHistoryQuery{UserID: user.ID}
+
+	query.Page, query.PageSize, err = pageParams(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	events, total, err := app.DB.WatchHistory(query)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if events == nil {
+		events = []*models.WatchEvent{}
+	}
+
+	var payload = struct {
+		History  []*models.WatchEvent `json:"history"`
+		Page     int                  `json:"page"`
+		PageSize int                  `json:"page_size"`
+		Total    int                  `json:"total"`
+	}{
+		History:  events,
+		Page:     query.Page,
+		PageSize: query.PageSize,
+		Total:    total,
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// DeleteWatchEvent takes one of the logged in user's watches out of their history
+func (app *application) DeleteWatchEvent(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.watchEventErrorJSON(w, sql.ErrNoRows)
+		return
+	}
+
+	event, err := app.DB.GetWatchEvent(id)
+	if err == nil && event.UserID != user.ID {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		app.watchEventErrorJSON(w, err)
+		return
+	}
+
+	err = app.DB.DeleteWatchEvent(event.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "removed from history",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// ExportWatchHistory downloads the logged in user's whole watch history, oldest first, as JSON or CSV,
+// eg. /me/history/export?format=csv
+func (app *application) ExportWatchHistory(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		app.errorJSON(w, errors.New("format must be json or csv"), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="watch-history.`+format+`"`)
+
+	// the history is streamed, so once it has started an error can only be logged
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+
+		out := csv.NewWriter(w)
+		_ = out.Write([]string{"movie_id", "title", "watched_at"})
+		err = app.DB.EachWatchEvent(r.Context(), user.ID, func(event *models.WatchEvent) error {
+			return out.Write([]string{
+				strconv.Itoa(event.MovieID),
+				event.Title,
+				event.WatchedAt.Format(time.RFC3339),
+			})
+		})
+		out.Flush()
+		if err == nil {
+			err = out.Error()
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+
+		enc := json.NewEncoder(w)
+		first := true
+		_, _ = w.Write([]byte("["))
+		err = app.DB.EachWatchEvent(r.Context(), user.ID, func(event *models.WatchEvent) error {
+			if !first {
+				_, _ = w.Write([]byte(","))
+			}
+			first = false
+			return enc.Encode(event)
+		})
+		_, _ = w.Write([]byte("]\n"))
+	}
+
+	if err != nil {
+		log.Printf("exporting watch history of user %d: %v", user.ID, err)
+	}
+}
+
+// SaveProgress records how far the logged in user has got through a movie, eg. {"position_seconds": 1234}.
+// Players send it every few seconds, so it is only held in memory & written to the database in batches
+// every -progress-flush-interval
+func (app *application) SaveProgress(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	movie, err := app.movieFromURL(r)
+	if err != nil {
+		app.movieErrorJSON(w, err)
+		return
+	}
+
+	var payload struct {
+		PositionSeconds int `json:"position_seconds"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if payload.PositionSeconds < 0 {
+		app.errorJSON(w, errors.New("position_seconds can't be negative"), http.StatusBadRequest)
+		return
+	}
+	// a little past the end is let through, as runtimes are rounded to the minute
+	if movie.RunTime > 0 && payload.PositionSeconds > (movie.RunTime+1)*60 {
+		app.errorJSON(w, errors.New("position_seconds is past the end of the movie"), http.StatusBadRequest)
+		return
+	}
+
+	progress := models.WatchProgress{
+		UserID:          user.ID,
+		MovieID:         movie.ID,
+		PositionSeconds: payload.PositionSeconds,
+		UpdatedAt:       time.Now().UTC(),
+	}
+	progress.Progress = progress.Fraction(movie.RunTime)
+
+	app.Progress.Record(progress)
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "progress saved",
+		Data:    progress,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// GetProgress says where the logged in user got to in a movie, so the player can resume from there. It
+// is 0 for movies they haven't started, or have finished
+func (app *application) GetProgress(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	movie, err := app.movieFromURL(r)
+	if err != nil {
+		app.movieErrorJSON(w, err)
+		return
+	}
+
+	progress, ok := app.Progress.Get(user.ID, movie.ID)
+	if !ok {
+		saved, err := app.DB.WatchProgress(user.ID, movie.ID)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			progress = models.WatchProgress{UserID: user.ID, MovieID: movie.ID}
+		case err != nil:
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		default:
+			progress = *saved
+		}
+	}
+	progress.Progress = progress.Fraction(movie.RunTime)
+
+	_ = app.writeJSON(w, http.StatusOK, progress)
+}
+
+// ContinueWatching lists the movies the logged in user is part way through, most recently watched
+// first, with where they got to in each
+func (app *application) ContinueWatching(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	saved, err := app.DB.ContinueWatching(user.ID, continueWatchingSize)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// positions that haven't been saved yet are newer than the ones that have
+	byMovie := map[int]*models.WatchProgress{}
+	for _, p := range saved {
+		byMovie[p.MovieID] = p
+	}
+	for _, p := range app.Progress.Pending(user.ID) {
+		if current, ok := byMovie[p.MovieID]; ok {
+			current.PositionSeconds, current.UpdatedAt = p.PositionSeconds, p.UpdatedAt
+			continue
+		}
+		movie, err := app.DB.OneMovie(p.MovieID)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		pending := p
+		pending.Movie = movie
+		byMovie[p.MovieID] = &pending
+	}
+
+	feed := []*models.WatchProgress{}
+	for _, p := range byMovie {
+		p.Progress = p.Fraction(p.Movie.RunTime)
+		if p.PositionSeconds == 0 || p.Progress >= models.FinishedFraction {
+			continue
+		}
+		feed = append(feed, p)
+	}
+
+	sort.Slice(feed, func(i, j int) bool {
+		return feed[i].UpdatedAt.After(feed[j].UpdatedAt)
+	})
+	if len(feed) > continueWatchingSize {
+		feed = feed[:continueWatchingSize]
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, feed)
+}
+
+func (app *application) watchEventErrorJSON(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("watch not found"), http.StatusNotFound)
+		return
+	}
+	app.errorJSON(w, err, http.StatusInternalServerError)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"backend/internal/models"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// impersonationExpiry is how long support can view the app as another user before asking again
+	impersonationExpiry = time.Minute * 15
+	// maxImpersonationReason is as long as the reason recorded in the audit log can be
+	maxImpersonationReason = 500
+)
+
+// ImpersonateUser gives an admin a short lived, read only access token for another user, so support can
+// see exactly what that user sees. The token names the admin in its 'act' claim & every one issued is
+// recorded in the audit log, with the reason given for it
+func (app *application) ImpersonateUser(w http.ResponseWriter, r *http.Request) {
+	admin, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.userFromURL(r)
+	if err != nil {
+		app.userErrorJSON(w, err)
+		return
+	}
+
+	var payload struct {
+		Reason string `json:"reason"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	payload.Reason = strings.TrimSpace(payload.Reason)
+	if payload.Reason == "" || len(payload.Reason) > maxImpersonationReason {
+		app.errorJSON(w, errors.New("a reason of up to 500 characters is required"), http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case user.ID == admin.ID:
+		app.errorJSON(w, errors.New("you can't impersonate yourself"), http.StatusBadRequest)
+		return
+	case user.DisabledAt != nil:
+		app.errorJSON(w, errAccountDisabled, http.StatusBadRequest)
+		return
+	case user.Role == models.RoleAdmin:
+		// an admin token that skipped their own login (and two-factor) is too much to hand out
+		app.errorJSON(w, errors.New("admins can't be impersonated"), http.StatusForbidden)
+		return
+	}
+
+	u := jwtUser{
+		ID:        user.ID,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Role:      user.Role,
+	}
+
+	expiresAt := time.Now().Add(impersonationExpiry)
+
+	token, err := app.auth.GenerateImpersonationToken(&u, admin.ID, impersonationExpiry)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	var details = struct {
+		Reason    string    `json:"reason"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{
+		Reason:    payload.Reason,
+		ExpiresAt: expiresAt,
+	}
+
+	app.audit(r, AuditUserImpersonate, "user", user.ID, nil, details)
+
+	var data = struct {
+		AccessToken string       `json:"access_token"`
+		ExpiresAt   time.Time    `json:"expires_at"`
+		User        *models.User `json:"user"`
+	}{
+		AccessToken: token,
+		ExpiresAt:   expiresAt,
+		User:        user,
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "impersonation token issued. It is read only & can't be refreshed",
+		Data:    data,
+	}
+
+	app.writeJSON(w, http.StatusCreated, resp)
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/internal/suggest"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxListNameLength & maxListDescriptionLength are in characters. maxSlugLength keeps share links short
+const (
+	maxListNameLength        = 100
+	maxListDescriptionLength = 1000
+	maxSlugLength            = 50
+)
+
+// PublicLists pages through everyone's public lists, most recently changed first, eg. /lists?page=2
+func (app *application) PublicLists(w http.ResponseWriter, r *http.Request) {
+	app.writeLists(w, r, models.ListQuery{})
+}
+
+// MyLists pages through the logged in user's lists, whatever their visibility. Their watchlist isn't
+// one of them, it has its own routes under /me/watchlist
+func (app *application) MyLists(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	app.writeLists(w, r, models.ListQuery{UserID: user.ID})
+}
+
+func (app *application) writeLists(w http.ResponseWriter, r *http.Request, query models.ListQuery) {
+	var err error
+	query.Page, query.PageSize, err = pageParams(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	lists, total, err := app.DB.AllLists(query)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if lists == nil {
+		lists = []*models.List{}
+	}
+
+	var payload = struct {
+		Lists    []*models.List `json:"lists"`
+		Page     int            `json:"page"`
+		PageSize int            `json:"page_size"`
+		Total    int            `json:"total"`
+	}{
+		Lists:    lists,
+		Page:     query.Page,
+		PageSize: query.PageSize,
+		Total:    total,
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// GetSharedList shows the list with the slug in the URL, with its movies. Public & unlisted lists can be
+// seen by anyone with the link, private ones only by their owner
+func (app *application) GetSharedList(w http.ResponseWriter, r *http.Request) {
+	list, err := app.DB.GetListBySlug(chi.URLParam(r, "slug"))
+	if err != nil {
+		app.listErrorJSON(w, err)
+		return
+	}
+
+	if list.Visibility == models.ListPrivate {
+		viewerID := 0
+		if claims := claimsFromContext(r); claims != nil && claims.APIKeyID == 0 {
+			viewerID, _ = strconv.Atoi(claims.Subject)
+		}
+		// we don't let on that there is a private list at this address
+		if viewerID != list.UserID {
+			app.listErrorJSON(w, sql.ErrNoRows)
+			return
+		}
+	}
+
+	app.writeList(w, list)
+}
+
+// GetMyList shows one of the logged in user's lists, or their watchlist, with its movies
+func (app *application) GetMyList(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	list, err := app.myListFromURL(r, user.ID)
+	if err != nil {
+		app.listErrorJSON(w, err)
+		return
+	}
+
+	app.writeList(w, list)
+}
+
+func (app *application) writeList(w http.ResponseWriter, list *models.List) {
+	var err error
+	list.Items, err = app.DB.ListItems(list.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if list.Items == nil {
+		list.Items = []*models.ListItem{}
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, list)
+}
+
+// InsertList makes a new list for the logged in user, eg.
+// {"name": "Heist movies", "description": "...", "visibility": "unlisted"}. Lists are private unless
+// told otherwise. The response has the slug the list can be shared by
+func (app *application) InsertList(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	var payload struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Visibility  string `json:"visibility"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	list := models.List{
+		UserID:      user.ID,
+		Name:        strings.TrimSpace(payload.Name),
+		Description: strings.TrimSpace(payload.Description),
+		Visibility:  payload.Visibility,
+	}
+
+	if list.Visibility == "" {
+		list.Visibility = models.ListPrivate
+	}
+
+	err = validateList(list)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// the random part of the slug makes a clash unlikely, but not impossible, so try a few
+	var id int
+	for attempt := 0; attempt < 3; attempt++ {
+		list.Slug, err = listSlug(list.Name)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		id, err = app.DB.InsertList(list)
+		if !errors.Is(err, repository.ErrDuplicateSlug) {
+			break
+		}
+	}
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	saved, err := app.DB.GetList(id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "list created",
+		Data:    saved,
+	}
+
+	app.writeJSON(w, http.StatusCreated, resp)
+}
+
+// UpdateList changes the name, description or visibility of one of the logged in user's lists. Only the
+// fields sent are changed. The slug stays the same, so links already shared keep working
+func (app *application) UpdateList(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	list, err := app.myListFromURL(r, user.ID)
+	if err != nil {
+		app.listErrorJSON(w, err)
+		return
+	}
+
+	var payload struct {
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+		Visibility  *string `json:"visibility"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if payload.Name != nil {
+		list.Name = strings.TrimSpace(*payload.Name)
+	}
+	if payload.Description != nil {
+		list.Description = strings.TrimSpace(*payload.Description)
+	}
+	if payload.Visibility != nil {
+		list.Visibility = *payload.Visibility
+	}
+
+	err = validateList(*list)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.DB.UpdateList(*list)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	saved, err := app.DB.GetList(list.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "list updated",
+		Data:    saved,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// DeleteList deletes one of the logged in user's lists. The movies on it aren't touched
+func (app *application) DeleteList(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	list, err := app.myListFromURL(r, user.ID)
+	if err != nil {
+		app.listErrorJSON(w, err)
+		return
+	}
+
+	err = app.DB.DeleteList(list.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "list deleted",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// AddListMovie puts the movie in the URL at the end of the list (or the watchlist). Adding a movie that
+// is already on it leaves it where it is
+func (app *application) AddListMovie(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	list, err := app.myListFromURL(r, user.ID)
+	if err != nil {
+		app.listErrorJSON(w, err)
+		return
+	}
+
+	movieID, err := strconv.Atoi(chi.URLParam(r, "movieID"))
+	if err != nil {
+		app.movieErrorJSON(w, sql.ErrNoRows)
+		return
+	}
+
+	movie, err := app.DB.OneMovie(movieID)
+	if err != nil {
+		app.movieErrorJSON(w, err)
+		return
+	}
+
+	added, err := app.DB.AddListMovie(list.ID, movie.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "movie added",
+	}
+	if !added {
+		resp.Message = "movie already on the list"
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// RemoveListMovie takes the movie in the URL off the list (or the watchlist)
+func (app *application) RemoveListMovie(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	list, err := app.myListFromURL(r, user.ID)
+	if err != nil {
+		app.listErrorJSON(w, err)
+		return
+	}
+
+	movieID, err := strconv.Atoi(chi.URLParam(r, "movieID"))
+	if err != nil {
+		app.movieErrorJSON(w, sql.ErrNoRows)
+		return
+	}
+
+	removed, err := app.DB.RemoveListMovie(list.ID, movieID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		app.errorJSON(w, errors.New("movie isn't on the list"), http.StatusNotFound)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "movie removed",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// ReorderList puts the movies on the list (or the watchlist) in a new order, eg. {"movie_ids": [3, 1, 2]}.
+// Every movie on the list must be named exactly once, so a stale order from another tab can't drop any
+func (app *application) ReorderList(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	list, err := app.myListFromURL(r, user.ID)
+	if err != nil {
+		app.listErrorJSON(w, err)
+		return
+	}
+
+	var payload struct {
+		MovieIDs []int `json:"movie_ids"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.DB.ReorderList(list.ID, payload.MovieIDs)
+	if errors.Is(err, repository.ErrListOrder) {
+		app.errorJSON(w, err, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "list reordered",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// myListFromURL loads the logged in user's list named by the {id} URL parameter or, under /me/watchlist
+// where there is no {id}, their watchlist. Other people's lists aren't found, and neither is the
+// watchlist by its id, so it can only be changed through its own routes
+func (app *application) myListFromURL(r *http.Request, userID int) (*models.List, error) {
+	param := chi.URLParam(r, "id")
+	if param == "" {
+		slug, err := listSlug("watchlist")
+		if err != nil {
+			return nil, err
+		}
+		return app.DB.Watchlist(userID, slug)
+	}
+
+	id, err := strconv.Atoi(param)
+	if err != nil {
+		return nil, sql.ErrNoRows
+	}
+
+	list, err := app.DB.GetList(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if list.UserID != userID || list.Watchlist {
+		return nil, sql.ErrNoRows
+	}
+
+	return list, nil
+}
+
+func (app *application) listErrorJSON(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("list not found"), http.StatusNotFound)
+		return
+	}
+	app.errorJSON(w, err, http.StatusInternalServerError)
+}
+
+// validateList checks what a user can set on a list
+func validateList(list models.List) error {
+	if list.Name == "" {
+		return errors.New("name is required")
+	}
+	if utf8.RuneCountInString(list.Name) > maxListNameLength {
+		return fmt.Errorf("name can't be longer than %d characters", maxListNameLength)
+	}
+	if utf8.RuneCountInString(list.Description) > maxListDescriptionLength {
+		return fmt.Errorf("description can't be longer than %d characters", maxListDescriptionLength)
+	}
+	if !models.ValidListVisibility(list.Visibility) {
+		return errors.New("visibility must be public, unlisted or private")
+	}
+	return nil
+}
+
+// listSlug makes a slug for sharing a list from its name, eg. "Heist Movies!" becomes heist-movies-3fa9c1d2.
+// The random end keeps two lists with the same name apart, and unlisted lists from being guessed
+func listSlug(name string) (string, error) {
+	var b strings.Builder
+	dash := false
+	for _, r := range suggest.Fold(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+
+	slug := b.String()
+	if len(slug) > maxSlugLength {
+		slug = strings.TrimRight(slug[:maxSlugLength], "-")
+	}
+	if slug == "" {
+		slug = "list"
+	}
+
+	suffix, err := randomString(4)
+	if err != nil {
+		return "", err
+	}
+
+	return slug + "-" + suffix, nil
+}
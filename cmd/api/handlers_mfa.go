@@ -0,0 +1,321 @@
+package main
+
+import (
+	"backend/internal/totp"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// mfaTokenExpiry is how long a user has to enter their one-time code after entering their password
+	mfaTokenExpiry = time.Minute * 5
+	// totpIssuer is the name authenticator apps show next to the account
+	totpIssuer = "Go Movies"
+	// totpSkew is how many 30 second steps either side of now we accept codes from
+	totpSkew          = 1
+	recoveryCodeCount = 10
+)
+
+// authenticateMFA is the second step of logging in for users with two-factor authentication. It takes
+// the mfa token from authenticate (or the single sign-on callback) plus either a code from their
+// authenticator app or a recovery code
+func (app *application) authenticateMFA(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	claims, err := app.auth.ValidateToken(requestPayload.MFAToken, TokenTypeMFA)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid or expired mfa token, please log in again"), http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		app.errorJSON(w, errors.New("unknown user"), http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.DB.GetUserById(userID)
+	if err != nil || user.TOTPEnabledAt == nil {
+		app.errorJSON(w, errors.New("unknown user"), http.StatusUnauthorized)
+		return
+	}
+
+	if user.DisabledAt != nil {
+		app.errorJSON(w, errAccountDisabled, http.StatusForbidden)
+		return
+	}
+
+	// codes are only 6 digits, so guesses count against the same limits as passwords
+	err = app.checkLoginAllowed(r, user.Email)
+	if err != nil {
+		app.throttledJSON(w, err)
+		return
+	}
+
+	valid, err := app.checkSecondFactor(user.ID, user.TOTPSecret, requestPayload.Code, requestPayload.RecoveryCode)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		app.recordLoginFailure(r, user.Email)
+		app.errorJSON(w, errors.New("invalid code"), http.StatusBadRequest)
+		return
+	}
+
+	// the mfa token says how they passed the first step
+	app.logUserIn(w, r, user, append(claims.AMR, AMROTP))
+}
+
+// EnrollTOTP starts setting up two-factor authentication. The secret & otpauth URI it returns go into
+// the user's authenticator app (the frontend usually shows the URI as a QR code). Nothing changes for
+// the user until they confirm a code with ConfirmTOTP
+func (app *application) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	if user.TOTPEnabledAt != nil {
+		app.errorJSON(w, errors.New("two-factor authentication is already on"), http.StatusConflict)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.DB.SetTOTPSecret(user.ID, secret)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	var payload = struct {
+		Secret string `json:"secret"`
+		URI    string `json:"otpauth_uri"`
+	}{
+		Secret: secret,
+		URI:    totp.URI(totpIssuer, user.Email, secret),
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// ConfirmTOTP turns two-factor authentication on once the user sends a valid code from their app, and
+// returns their recovery codes. This is the only time the recovery codes are shown
+func (app *application) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	var payload struct {
+		Code string `json:"code"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if user.TOTPEnabledAt != nil {
+		app.errorJSON(w, errors.New("two-factor authentication is already on"), http.StatusConflict)
+		return
+	}
+
+	if user.TOTPSecret == "" {
+		app.errorJSON(w, errors.New("start enrolment first"), http.StatusBadRequest)
+		return
+	}
+
+	step, ok := totp.Validate(user.TOTPSecret, payload.Code, time.Now(), totpSkew)
+	if !ok {
+		app.errorJSON(w, errors.New("invalid code"), http.StatusBadRequest)
+		return
+	}
+
+	codes, err := app.newRecoveryCodes(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.DB.EnableTOTP(user.ID, step)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "two-factor authentication turned on. Keep these recovery codes somewhere safe",
+		Data:    codes,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// DisableTOTP turns two-factor authentication off. It needs both the password & a current code (or a
+// recovery code), so a stolen access token alone can't be used to weaken the account
+func (app *application) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	var payload struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	valid, err := user.PasswordMatches(payload.Password)
+	if err != nil || !valid {
+		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
+		return
+	}
+
+	if user.TOTPEnabledAt != nil {
+		valid, err = app.checkSecondFactor(user.ID, user.TOTPSecret, payload.Code, payload.RecoveryCode)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		if !valid {
+			app.errorJSON(w, errors.New("invalid code"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = app.DB.DisableTOTP(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "two-factor authentication turned off",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes, eg. when they have used most of them up
+func (app *application) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	var payload struct {
+		Code string `json:"code"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if user.TOTPEnabledAt == nil {
+		app.errorJSON(w, errors.New("two-factor authentication is off"), http.StatusBadRequest)
+		return
+	}
+
+	valid, err := app.checkSecondFactor(user.ID, user.TOTPSecret, payload.Code, "")
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		app.errorJSON(w, errors.New("invalid code"), http.StatusBadRequest)
+		return
+	}
+
+	codes, err := app.newRecoveryCodes(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "recovery codes replaced",
+		Data:    codes,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// checkSecondFactor verifies either a TOTP code or a recovery code. Each can only be used once
+func (app *application) checkSecondFactor(userID int, secret, code, recoveryCode string) (bool, error) {
+	switch {
+	case code != "":
+		step, ok := totp.Validate(secret, code, time.Now(), totpSkew)
+		if !ok {
+			return false, nil
+		}
+		// stops someone who saw the code being typed in from using it again
+		return app.DB.UseTOTPStep(userID, step)
+	case recoveryCode != "":
+		return app.DB.UseRecoveryCode(userID, hashToken(normalizeRecoveryCode(recoveryCode)))
+	}
+	return false, nil
+}
+
+// newRecoveryCodes creates a fresh set of recovery codes for the user, replacing any old ones
+func (app *application) newRecoveryCodes(userID int) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		raw, err := randomString(5)
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = hashToken(normalizeRecoveryCode(codes[i]))
+	}
+
+	err := app.DB.ReplaceRecoveryCodes(userID, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// normalizeRecoveryCode lets users type recovery codes without the dash & in any case
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxModerationBatch is how many reviews can be approved or rejected at once
+const maxModerationBatch = 100
+
+// ModerationQueue lists the reviews waiting for a moderator, most reported first, eg.
+// /admin/moderation?status=flagged&page=2. It shows pending & flagged reviews unless told otherwise
+func (app *application) ModerationQueue(w http.ResponseWriter, r *http.Request) {
+	query := models.ModerationQuery{
+		Statuses: listParam(r.URL.Query(), "status"),
+	}
+
+	for _, status := range query.Statuses {
+		if !models.ValidReviewStatus(status) {
+			app.errorJSON(w, fmt.Errorf("unknown status %q", status), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var err error
+	query.Page, query.PageSize, err = pageParams(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	reviews, total, err := app.DB.ModerationQueue(query)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if reviews == nil {
+		reviews = []*models.Review{}
+	}
+
+	var payload = struct {
+		Reviews  []*models.Review `json:"reviews"`
+		Page     int              `json:"page"`
+		PageSize int              `json:"page_size"`
+		Total    int              `json:"total"`
+	}{
+		Reviews:  reviews,
+		Page:     query.Page,
+		PageSize: query.PageSize,
+		Total:    total,
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// ModerationHistory shows a review with every moderation decision made about it
+func (app *application) ModerationHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.reviewErrorJSON(w, sql.ErrNoRows)
+		return
+	}
+
+	review, err := app.DB.GetReview(id)
+	if err != nil {
+		app.reviewErrorJSON(w, err)
+		return
+	}
+
+	decisions, err := app.DB.ReviewDecisions(review.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if decisions == nil {
+		decisions = []*models.ModerationDecision{}
+	}
+
+	var payload = struct {
+		Review       *models.Review               `json:"review"`
+		ShadowHidden bool                         `json:"shadow_hidden"`
+		Decisions    []*models.ModerationDecision `json:"decisions"`
+	}{
+		Review:       review,
+		ShadowHidden: review.ShadowHidden,
+		Decisions:    decisions,
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// moderationResult is what happened to one review of a batch
+type moderationResult struct {
+	ReviewID int    `json:"review_id"`
+	Status   string `json:"status,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ModerateReviews approves (publishes) or rejects reviews in bulk, eg.
+// {"action": "reject", "review_ids": [4, 8, 15], "reason": "spam"}. Each review is decided on its own, so
+// one that can't be moved doesn't stop the others; the response says what happened to each.
+// Decisions are recorded with the moderator from the token, so API keys can't make them
+func (app *application) ModerateReviews(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromContext(r)
+	if claims == nil || claims.APIKeyID != 0 {
+		app.errorJSON(w, errors.New("forbidden: moderation decisions must be made by a person"), http.StatusForbidden)
+		return
+	}
+	moderatorID := actorID(claims)
+
+	var payload struct {
+		Action    string `json:"action"`
+		ReviewIDs []int  `json:"review_ids"`
+		Reason    string `json:"reason"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var to string
+	switch payload.Action {
+	case "approve":
+		to = models.ReviewPublished
+	case "reject":
+		to = models.ReviewRejected
+	default:
+		app.errorJSON(w, errors.New("action must be approve or reject"), http.StatusBadRequest)
+		return
+	}
+
+	if len(payload.ReviewIDs) == 0 || len(payload.ReviewIDs) > maxModerationBatch {
+		app.errorJSON(w, fmt.Errorf("review_ids must have between 1 and %d ids", maxModerationBatch), http.StatusBadRequest)
+		return
+	}
+
+	reason := strings.TrimSpace(payload.Reason)
+
+	results := make([]moderationResult, 0, len(payload.ReviewIDs))
+	for _, id := range payload.ReviewIDs {
+		result := moderationResult{ReviewID: id}
+
+		review, err := app.DB.GetReview(id)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			result.Error = "review not found"
+		case err != nil:
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		case !models.ReviewTransitionAllowed(review.Status, to):
+			result.Error = fmt.Sprintf("a %s review can't be %s", review.Status, to)
+		}
+
+		if result.Error != "" {
+			results = append(results, result)
+			continue
+		}
+
+		err = app.DB.ModerateReview(models.ModerationDecision{
+			ReviewID:    review.ID,
+			ModeratorID: &moderatorID,
+			FromStatus:  review.Status,
+			ToStatus:    to,
+			Reason:      reason,
+		})
+		if errors.Is(err, repository.ErrReviewStatusChanged) {
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		after := *review
+		after.Status = to
+		app.audit(r, AuditReviewModerate, "review", review.ID, review, after)
+
+		result.Status = to
+		results = append(results, result)
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "reviews moderated",
+		Data:    results,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// ShadowHideUser hides everything the user writes from everyone else, without telling them: they keep
+// seeing their reviews as if nothing happened. Their ratings stop counting towards movies' averages
+func (app *application) ShadowHideUser(w http.ResponseWriter, r *http.Request) {
+	app.setUserShadowHidden(w, r, true)
+}
+
+// ShadowUnhideUser shows the user's reviews to everyone again
+func (app *application) ShadowUnhideUser(w http.ResponseWriter, r *http.Request) {
+	app.setUserShadowHidden(w, r, false)
+}
+
+func (app *application) setUserShadowHidden(w http.ResponseWriter, r *http.Request, hidden bool) {
+	user, err := app.userFromURL(r)
+	if err != nil {
+		app.userErrorJSON(w, err)
+		return
+	}
+
+	if app.isCurrentUser(r, user.ID) {
+		app.errorJSON(w, errors.New("you can't shadow hide yourself"), http.StatusBadRequest)
+		return
+	}
+
+	err = app.DB.SetUserShadowHidden(user.ID, hidden)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	message, action := "user shown", AuditUserShadowUnhide
+	if hidden {
+		message, action = "user shadow hidden", AuditUserShadowHide
+	}
+
+	// shadow_hidden_at isn't part of a user's JSON, so say what changed in so many words
+	app.audit(r, action, "user", user.ID,
+		map[string]bool{"shadow_hidden": user.ShadowHiddenAt != nil},
+		map[string]bool{"shadow_hidden": hidden},
+	)
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: message,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/oidc"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// oidcCookieName holds the state, nonce & PKCE verifier between sending the user to the identity
+	// provider & them coming back to the callback
+	oidcCookieName = "gus_oidc"
+	oidcCookiePath = "/auth/oidc"
+	// oidcLoginExpiry is how long the user has to log in at the identity provider
+	oidcLoginExpiry = time.Minute * 10
+)
+
+// oidcLogin starts single sign-on: it remembers a random state, nonce & PKCE verifier in a short lived
+// cookie and sends the browser to the identity provider
+func (app *application) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	if app.OIDC == nil {
+		app.errorJSON(w, errors.New("single sign-on is not configured"), http.StatusNotFound)
+		return
+	}
+
+	var values [3]string
+	for i := range values {
+		v, err := oidc.RandomString()
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		values[i] = v
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	authURL, err := app.OIDC.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Println(err)
+		app.errorJSON(w, errors.New("could not reach the identity provider"), http.StatusBadGateway)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookieName,
+		Path:     oidcCookiePath,
+		Value:    strings.Join(values[:], "."),
+		MaxAge:   int(oidcLoginExpiry.Seconds()),
+		Expires:  time.Now().Add(oidcLoginExpiry),
+		HttpOnly: true,
+		Secure:   true,
+		// Lax, so the cookie comes back with the identity provider's redirect to the callback
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// oidcCallback finishes single sign-on. It checks the state, swaps the code for an ID token, finds or
+// creates the matching user, then starts a session exactly like a password login: the refresh cookie is
+// set & the browser is sent back to the frontend, which calls /refresh for its access token. Users with
+// two-factor authentication on get the same challenge as after their password instead: the browser is
+// sent to the frontend's /login/mfa with an mfa_token in the fragment, to exchange at /authenticate/mfa
+func (app *application) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	if app.OIDC == nil {
+		app.errorJSON(w, errors.New("single sign-on is not configured"), http.StatusNotFound)
+		return
+	}
+
+	// the cookie is single use
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookieName,
+		Path:     oidcCookiePath,
+		Value:    "",
+		MaxAge:   -1,
+		Expires:  time.Unix(0, 0),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	q := r.URL.Query()
+	if idpErr := q.Get("error"); idpErr != "" {
+		log.Printf("oidc: identity provider returned %s: %s", idpErr, q.Get("error_description"))
+		app.oidcFailed(w, r, "access_denied")
+		return
+	}
+
+	cookie, err := r.Cookie(oidcCookieName)
+	if err != nil {
+		app.oidcFailed(w, r, "login_expired")
+		return
+	}
+
+	values := strings.Split(cookie.Value, ".")
+	if len(values) != 3 || subtle.ConstantTimeCompare([]byte(values[0]), []byte(q.Get("state"))) != 1 {
+		app.oidcFailed(w, r, "invalid_state")
+		return
+	}
+	nonce, verifier := values[1], values[2]
+
+	tokens, err := app.OIDC.Exchange(r.Context(), q.Get("code"), verifier)
+	if err != nil {
+		log.Println(err)
+		app.oidcFailed(w, r, "exchange_failed")
+		return
+	}
+
+	idToken, err := app.OIDC.Verify(r.Context(), tokens.IDToken, nonce)
+	if err != nil {
+		log.Println(err)
+		app.oidcFailed(w, r, "invalid_id_token")
+		return
+	}
+
+	user, err := app.oidcUser(idToken)
+	if err != nil {
+		log.Println(err)
+		app.oidcFailed(w, r, "no_account")
+		return
+	}
+
+	if user.DisabledAt != nil {
+		app.oidcFailed(w, r, "account_disabled")
+		return
+	}
+
+	// the provider's own second factor only counts if we have been told to trust it
+	amr := []string{AMRFederated}
+	if app.OIDC.TrustAMR {
+		amr = append(amr, idToken.AMR...)
+	}
+
+	if user.TOTPEnabledAt != nil {
+		mfaToken, err := app.auth.GenerateMFAToken(user.ID, amr, mfaTokenExpiry)
+		if err != nil {
+			log.Println(err)
+			app.oidcFailed(w, r, "server_error")
+			return
+		}
+
+		// in the fragment, so it never reaches a server's logs or another site's Referer
+		http.Redirect(w, r, app.FrontendURL+"/login/mfa#"+url.Values{"mfa_token": {mfaToken}}.Encode(), http.StatusFound)
+		return
+	}
+
+	_, err = app.startSession(w, r, user, amr)
+	if err != nil {
+		log.Println(err)
+		app.oidcFailed(w, r, "server_error")
+		return
+	}
+
+	http.Redirect(w, r, app.FrontendURL+"/login/sso", http.StatusFound)
+}
+
+// oidcFailed sends the browser back to the frontend's login page with a short error code it can show
+func (app *application) oidcFailed(w http.ResponseWriter, r *http.Request, code string) {
+	http.Redirect(w, r, app.FrontendURL+"/login?"+url.Values{"sso_error": {code}}.Encode(), http.StatusFound)
+}
+
+// oidcUser finds the user an ID token belongs to. The first time we see an identity it is linked to the
+// user with the same (verified) email address, or a new viewer account is created for it. New accounts
+// get a random password nobody knows; they can set a real one with the forgotten password flow
+func (app *application) oidcUser(idToken *oidc.IDToken) (*models.User, error) {
+	user, err := app.DB.GetUserByIdentity(app.OIDC.Issuer, idToken.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	// matching on an email the provider hasn't checked would let anyone take over an account
+	email := normalizeEmail(idToken.Email)
+	if email == "" || !idToken.EmailVerified {
+		return nil, errors.New("oidc: identity provider did not give a verified email address")
+	}
+
+	user, err = app.DB.GetUserByEmail(email)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		user, err = app.createOIDCUser(email, idToken)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if user.EmailVerifiedAt == nil {
+		err = app.DB.SetEmailVerified(user.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = app.DB.LinkIdentity(user.ID, app.OIDC.Issuer, idToken.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	return app.DB.GetUserById(user.ID)
+}
+
+func (app *application) createOIDCUser(email string, idToken *oidc.IDToken) (*models.User, error) {
+	firstName, lastName := idToken.GivenName, idToken.FamilyName
+	if firstName == "" && lastName == "" {
+		firstName, lastName, _ = strings.Cut(strings.TrimSpace(idToken.Name), " ")
+	}
+	if firstName == "" {
+		firstName, _, _ = strings.Cut(email, "@")
+	}
+
+	password, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+
+	newID, err := app.DB.InsertUser(models.User{
+		FirstName: firstName,
+		LastName:  lastName,
+		Email:     email,
+		Password:  password,
+		Role:      models.RoleViewer,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return app.DB.GetUserById(newID)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/oidc"
+	"backend/internal/oidc/oidctest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ssoLogin goes through single sign-on with the fake provider & returns our callback's response
+func ssoLogin(t *testing.T, app *application) *httptest.ResponseRecorder {
+	t.Helper()
+	routes := app.routes()
+
+	w := httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest("GET", "/auth/oidc/login", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login: got %d: %s", w.Code, w.Body)
+	}
+	loginCookies := w.Result().Cookies()
+
+	// the fake provider logs the user straight in & sends them back with a code
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err := client.Get(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", callback.RequestURI(), nil)
+	for _, cookie := range loginCookies {
+		r.AddCookie(cookie)
+	}
+	w = httptest.NewRecorder()
+	routes.ServeHTTP(w, r)
+
+	return w
+}
+
+func cookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == name && c.Value != "" {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestOIDCCallback(t *testing.T) {
+	enabled := time.Now()
+
+	tests := []struct {
+		name     string
+		trustAMR bool
+		totp     bool
+		wantAMR  []string
+	}{
+		{"provider's amr ignored", false, false, []string{AMRFederated}},
+		{"provider's amr trusted", true, false, []string{AMRFederated, "pwd", "mfa"}},
+		{"totp enabled", false, true, []string{AMRFederated}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := oidctest.NewProvider("go-movies", "secret")
+			defer idp.Close()
+			idp.SetUser(oidctest.User{Subject: "alice", Email: "alice@example.com", EmailVerified: true, AMR: []string{"pwd", "mfa"}})
+
+			alice := &models.User{ID: 1, Email: "alice@example.com", Role: models.RoleAdmin, EmailVerifiedAt: &enabled}
+			if tt.totp {
+				alice.TOTPEnabledAt = &enabled
+			}
+
+			app := newTestApp(t)
+			app.FrontendURL = "http://localhost:3000"
+			app.DB = &stubDB{users: map[int]*models.User{1: alice}}
+			app.OIDC = &oidc.Provider{
+				Issuer:       idp.Issuer,
+				ClientID:     "go-movies",
+				ClientSecret: "secret",
+				RedirectURL:  "http://localhost:8080/auth/oidc/callback",
+				TrustAMR:     tt.trustAMR,
+			}
+
+			w := ssoLogin(t, app)
+			if w.Code != http.StatusFound {
+				t.Fatalf("callback: got %d: %s", w.Code, w.Body)
+			}
+			location, err := url.Parse(w.Header().Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var claims *Claims
+			if tt.totp {
+				// no session yet, only the challenge
+				if location.Path != "/login/mfa" {
+					t.Fatalf("sent to %s, want the mfa challenge", location)
+				}
+				if cookie(w, app.auth.CookieName) != nil {
+					t.Error("a session was started before the one-time code")
+				}
+
+				fragment, err := url.ParseQuery(location.Fragment)
+				if err != nil {
+					t.Fatal(err)
+				}
+				claims, err = app.auth.ValidateToken(fragment.Get("mfa_token"), TokenTypeMFA)
+				if err != nil {
+					t.Fatalf("mfa token: %v", err)
+				}
+			} else {
+				if location.Path != "/login/sso" {
+					t.Fatalf("sent to %s, want /login/sso", location)
+				}
+				refresh := cookie(w, app.auth.CookieName)
+				if refresh == nil {
+					t.Fatal("no refresh cookie")
+				}
+				claims, err = app.auth.ValidateToken(refresh.Value, TokenTypeRefresh)
+				if err != nil {
+					t.Fatalf("refresh token: %v", err)
+				}
+			}
+
+			if claims.Subject != "1" || strings.Join(claims.AMR, " ") != strings.Join(tt.wantAMR, " ") {
+				t.Errorf("got subject %s & amr %v, want 1 & %v", claims.Subject, claims.AMR, tt.wantAMR)
+			}
+
+			// an admin only counts as having used two-factor authentication if the provider is trusted
+			claims.Role = models.RoleAdmin
+			app.AdminRequireMFA = true
+			if missing := app.adminMFAMissing(claims); missing == tt.trustAMR {
+				t.Errorf("adminMFAMissing is %v", missing)
+			}
+		})
+	}
+}
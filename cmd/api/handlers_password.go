@@ -0,0 +1,194 @@
+package main
+
+import (
+	"backend/internal/mailer"
+	"backend/internal/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// how long the links we email out stay valid
+const (
+	passwordResetExpiry     = time.Hour
+	emailVerificationExpiry = time.Hour * 48
+)
+
+// forgotPassword emails a password reset link to the address given. It answers the same way whether or not
+// the address belongs to a user, so it can't be used to find out who has an account
+func (app *application) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "if that email address belongs to an account, a password reset link has been sent to it",
+	}
+
+	user, err := app.DB.GetUserByEmail(normalizeEmail(requestPayload.Email))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Println(err)
+		}
+		app.writeJSON(w, http.StatusAccepted, resp)
+		return
+	}
+
+	err = app.sendPasswordReset(user)
+	if err != nil {
+		log.Println(err)
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// resetPassword sets a new password for the user a reset token was sent to. The token can only be used once
+func (app *application) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// check the new password first, so a typo doesn't use up the token
+	if err := app.validatePassword(requestPayload.Password); err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	token, err := app.DB.ConsumeUserToken(hashToken(requestPayload.Token), models.TokenPurposePasswordReset)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid or expired reset token"), http.StatusBadRequest)
+		return
+	}
+
+	err = app.DB.UpdatePassword(token.UserID, requestPayload.Password)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// whoever knew the old password shouldn't stay logged in
+	err = app.Tokens.RevokeUserRefreshTokens(token.UserID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// they got the reset email, so they own the address. Changing the email drops outstanding reset tokens,
+	// so the address they got it at is still the one on the account
+	err = app.DB.SetEmailVerified(token.UserID)
+	if err != nil {
+		log.Println(err)
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "password reset",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// verifyEmail is where the link in the verification email points to
+func (app *application) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	tokenString := r.URL.Query().Get("token")
+	if tokenString == "" {
+		app.errorJSON(w, errors.New("missing token"), http.StatusBadRequest)
+		return
+	}
+
+	token, err := app.DB.ConsumeUserToken(hashToken(tokenString), models.TokenPurposeEmailVerification)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid or expired verification token"), http.StatusBadRequest)
+		return
+	}
+
+	err = app.DB.SetEmailVerified(token.UserID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "email address verified",
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func (app *application) sendPasswordReset(user *models.User) error {
+	token, err := app.newUserToken(user.ID, models.TokenPurposePasswordReset, passwordResetExpiry)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/reset-password?token=%s", app.FrontendURL, url.QueryEscape(token))
+
+	return app.Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Go Movies password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password for your account. "+
+			"If it was you, follow this link within the next hour to choose a new one:\n\n%s\n\n"+
+			"If it wasn't you, you can ignore this email.\n", user.FirstName, link),
+	})
+}
+
+func (app *application) sendEmailVerification(user *models.User) error {
+	token, err := app.newUserToken(user.ID, models.TokenPurposeEmailVerification, emailVerificationExpiry)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", app.FrontendURL, url.QueryEscape(token))
+
+	return app.Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address for Go Movies by following this link:\n\n%s\n",
+			user.FirstName, link),
+	})
+}
+
+// newUserToken replaces any outstanding tokens the user has for the purpose with a new one, and returns
+// the new token. Only its hash is stored, so this is the only time the token itself is available
+func (app *application) newUserToken(userID int, purpose string, expiry time.Duration) (string, error) {
+	token, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	err = app.DB.DeleteUserTokens(userID, purpose)
+	if err != nil {
+		return "", err
+	}
+
+	err = app.DB.InsertUserToken(models.UserToken{
+		UserID:    userID,
+		TokenHash: hashToken(token),
+		Purpose:   purpose,
+		ExpiresAt: time.Now().Add(expiry),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
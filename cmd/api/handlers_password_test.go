@@ -0,0 +1,90 @@
+package main
+
+import (
+	"backend/internal/mailer"
+	"backend/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var resetLink = regexp.MustCompile(`/reset-password\?token=(\S+)`)
+
+// resetToken is the token in the last password reset email sent
+func resetToken(t *testing.T, m *mailer.MemoryMailer) string {
+	t.Helper()
+
+	messages := m.Messages()
+	for i := len(messages) - 1; i >= 0; i-- {
+		if match := resetLink.FindStringSubmatch(messages[i].Body); match != nil {
+			token, err := url.QueryUnescape(match[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return token
+		}
+	}
+	t.Fatal("no password reset email was sent")
+	return ""
+}
+
+func TestResetPasswordAfterEmailChange(t *testing.T) {
+	tests := []struct {
+		name         string
+		changeEmail  bool
+		want         int
+		wantVerified bool
+	}{
+		{"same address", false, http.StatusAccepted, true},
+		{"address changed after the reset email", true, http.StatusBadRequest, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &stubDB{users: map[int]*models.User{
+				1: {ID: 1, FirstName: "Alice", LastName: "Smith", Email: "alice@example.com", Role: models.RoleViewer},
+			}}
+			mail := &mailer.MemoryMailer{}
+
+			app := newTestApp(t)
+			app.DB = db
+			app.Mailer = mail
+			routes := app.routes()
+
+			w := httptest.NewRecorder()
+			routes.ServeHTTP(w, httptest.NewRequest("POST", "/password/forgot", strings.NewReader(`{"email": "alice@example.com"}`)))
+			if w.Code != http.StatusAccepted {
+				t.Fatalf("forgot: got %d: %s", w.Code, w.Body)
+			}
+			token := resetToken(t, mail)
+
+			if tt.changeEmail {
+				tokens, err := app.auth.GenerateTokenPair(&jwtUser{ID: 1, Role: models.RoleViewer})
+				if err != nil {
+					t.Fatal(err)
+				}
+				r := httptest.NewRequest("PATCH", "/me", strings.NewReader(`{"email": "mallory@example.com"}`))
+				r.Header.Set("Authorization", "Bearer "+tokens.Token)
+				w = httptest.NewRecorder()
+				routes.ServeHTTP(w, r)
+				if w.Code != http.StatusAccepted {
+					t.Fatalf("update: got %d: %s", w.Code, w.Body)
+				}
+			}
+
+			w = httptest.NewRecorder()
+			body := `{"token": "` + token + `", "password": "a new password"}`
+			routes.ServeHTTP(w, httptest.NewRequest("POST", "/password/reset", strings.NewReader(body)))
+			if w.Code != tt.want {
+				t.Fatalf("reset: got %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+
+			if verified := db.users[1].EmailVerifiedAt != nil; verified != tt.wantVerified {
+				t.Errorf("%s verified: %v, want %v", db.users[1].Email, verified, tt.wantVerified)
+			}
+		})
+	}
+}
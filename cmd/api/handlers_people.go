@@ -0,0 +1,339 @@
+package main
+
+import (
+	"backend/internal/models"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// GetPerson returns someone from the cast or crew, with every movie they are credited in
+func (app *application) GetPerson(w http.ResponseWriter, r *http.Request) {
+	person, err := app.personFromURL(r)
+	if err != nil {
+		app.personErrorJSON(w, err)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, person)
+}
+
+// AllPeople lists people for the admin screens, eg. /admin/people?q=nolan&page=2
+func (app *application) AllPeople(w http.ResponseWriter, r *http.Request) {
+	query := models.PersonQuery{
+		Search: r.URL.Query().Get("q"),
+	}
+
+	var err error
+	query.Page, query.PageSize, err = pageParams(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	people, total, err := app.DB.AllPeople(query)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if people == nil {
+		people = []*models.Person{}
+	}
+
+	var payload = struct {
+		People   []*models.Person `json:"people"`
+		Page     int              `json:"page"`
+		PageSize int              `json:"page_size"`
+		Total    int              `json:"total"`
+	}{
+		People:   people,
+		Page:     query.Page,
+		PageSize: query.PageSize,
+		Total:    total,
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// personPayload is what admins send to create or change a person. Only the fields sent are changed
+type personPayload struct {
+	Name      *string `json:"name"`
+	Biography *string `json:"biography"`
+	// BirthDate is a date like 1970-07-30, or "" to clear it
+	BirthDate *string `json:"birth_date"`
+	Image     *string `json:"image"`
+}
+
+// apply copies the fields that were sent onto person, and checks the result
+func (p *personPayload) apply(person *models.Person) error {
+	if p.Name != nil {
+		person.Name = strings.TrimSpace(*p.Name)
+	}
+	if p.Biography != nil {
+		person.Biography = strings.TrimSpace(*p.Biography)
+	}
+	if p.Image != nil {
+		person.Image = strings.TrimSpace(*p.Image)
+	}
+	if p.BirthDate != nil {
+		person.BirthDate = nil
+		if *p.BirthDate != "" {
+			birthDate, err := time.Parse(time.DateOnly, *p.BirthDate)
+			if err != nil {
+				return errors.New("birth_date must be a date, eg. 1970-07-30")
+			}
+			person.BirthDate = &birthDate
+		}
+	}
+
+	if person.Name == "" {
+		return errors.New("name is required")
+	}
+
+	return nil
+}
+
+// InsertPerson adds someone to the people who can be credited in movies
+func (app *application) InsertPerson(w http.ResponseWriter, r *http.Request) {
+	var payload personPayload
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var person models.Person
+	err = payload.apply(&person)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	newID, err := app.DB.InsertPerson(person)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	created, err := app.DB.GetPerson(newID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, AuditPersonCreate, "person", newID, nil, created)
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "person created",
+		Data:    created,
+	}
+
+	app.writeJSON(w, http.StatusCreated, resp)
+}
+
+// UpdatePerson changes a person's details
+func (app *application) UpdatePerson(w http.ResponseWriter, r *http.Request) {
+	person, err := app.personFromURL(r)
+	if err != nil {
+		app.personErrorJSON(w, err)
+		return
+	}
+
+	var payload personPayload
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	before := *person
+	before.Filmography = nil
+
+	err = payload.apply(person)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.DB.UpdatePerson(*person)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	after := *person
+	after.Filmography = nil
+	app.audit(r, AuditPersonUpdate, "person", person.ID, before, after)
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "person updated",
+		Data:    person,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// DeletePerson deletes a person along with all their credits
+func (app *application) DeletePerson(w http.ResponseWriter, r *http.Request) {
+	person, err := app.personFromURL(r)
+	if err != nil {
+		app.personErrorJSON(w, err)
+		return
+	}
+
+	err = app.DB.DeletePerson(person.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, AuditPersonDelete, "person", person.ID, person, nil)
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "person deleted",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// InsertCredit credits a person in a movie, eg. {"movie_id": 1, "role": "actor", "character": "Indiana Jones", "billing_order": 1}
+func (app *application) InsertCredit(w http.ResponseWriter, r *http.Request) {
+	person, err := app.personFromURL(r)
+	if err != nil {
+		app.personErrorJSON(w, err)
+		return
+	}
+
+	var payload struct {
+		MovieID      int    `json:"movie_id"`
+		Role         string `json:"role"`
+		Character    string `json:"character"`
+		BillingOrder int    `json:"billing_order"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	credit := models.Credit{
+		MovieID:      payload.MovieID,
+		PersonID:     person.ID,
+		Role:         payload.Role,
+		Character:    strings.TrimSpace(payload.Character),
+		BillingOrder: payload.BillingOrder,
+	}
+
+	if !models.ValidCreditRole(credit.Role) {
+		app.errorJSON(w, errors.New("role must be one of actor, director, writer, producer or composer"), http.StatusBadRequest)
+		return
+	}
+	if credit.Role != models.CreditActor && credit.Character != "" {
+		app.errorJSON(w, errors.New("only actors play a character"), http.StatusBadRequest)
+		return
+	}
+	if credit.BillingOrder < 0 {
+		app.errorJSON(w, errors.New("billing_order can't be negative"), http.StatusBadRequest)
+		return
+	}
+
+	_, err = app.DB.OneMovie(credit.MovieID)
+	if err != nil {
+		app.errorJSON(w, errors.New("movie not found"), http.StatusBadRequest)
+		return
+	}
+
+	credit.ID, err = app.DB.InsertCredit(credit)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, AuditCreditCreate, "credit", credit.ID, nil, credit)
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "credit added",
+		Data:    credit,
+	}
+
+	app.writeJSON(w, http.StatusCreated, resp)
+}
+
+// DeleteCredit removes one of a person's credits
+func (app *application) DeleteCredit(w http.ResponseWriter, r *http.Request) {
+	person, err := app.personFromURL(r)
+	if err != nil {
+		app.personErrorJSON(w, err)
+		return
+	}
+
+	creditID, err := strconv.Atoi(chi.URLParam(r, "creditID"))
+	if err != nil {
+		app.errorJSON(w, errors.New("credit not found"), http.StatusNotFound)
+		return
+	}
+
+	credit, err := app.DB.GetCredit(creditID)
+	if err != nil || credit.PersonID != person.ID {
+		app.errorJSON(w, errors.New("credit not found"), http.StatusNotFound)
+		return
+	}
+
+	err = app.DB.DeleteCredit(credit.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, AuditCreditDelete, "credit", credit.ID, credit, nil)
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "credit removed",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// personFromURL loads the person named by the {id} URL parameter
+func (app *application) personFromURL(r *http.Request) (*models.Person, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		return nil, sql.ErrNoRows
+	}
+
+	return app.DB.GetPerson(id)
+}
+
+func (app *application) personErrorJSON(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("person not found"), http.StatusNotFound)
+		return
+	}
+	app.errorJSON(w, err, http.StatusInternalServerError)
+}
+
+// splitCredits sorts a movie's credits into its cast (actors) & crew (everyone else)
+func splitCredits(credits []*models.Credit) (cast, crew []*models.Credit) {
+	for _, credit := range credits {
+		if credit.Role == models.CreditActor {
+			cast = append(cast, credit)
+		} else {
+			crew = append(crew, credit)
+		}
+	}
+	return cast, crew
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxReviewLength is the most a review can say, in characters, and maxReportLength the most a report can
+const (
+	maxReviewLength = 5000
+	maxReportLength = 500
+)
+
+// MovieReviews lists a movie's published reviews with its average rating, eg. /movies/1/reviews?sort=recent&page=2.
+// Logged in users also see their own review, whatever moderation has made of it
+func (app *application) MovieReviews(w http.ResponseWriter, r *http.Request) {
+	movie, err := app.movieFromURL(r)
+	if err != nil {
+		app.movieErrorJSON(w, err)
+		return
+	}
+
+	query := models.ReviewQuery{
+		MovieID: movie.ID,
+		Sort:    r.URL.Query().Get("sort"),
+	}
+
+	if claims := claimsFromContext(r); claims != nil && claims.APIKeyID == 0 {
+		query.ViewerID, _ = strconv.Atoi(claims.Subject)
+	}
+
+	if query.Sort == "" {
+		query.Sort = models.ReviewSortHelpful
+	}
+	if query.Sort != models.ReviewSortHelpful && query.Sort != models.ReviewSortRecent {
+		app.errorJSON(w, errors.New("sort must be helpful or recent"), http.StatusBadRequest)
+		return
+	}
+
+	query.Page, query.PageSize, err = pageParams(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	reviews, total, err := app.DB.MovieReviews(query)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if reviews == nil {
+		reviews = []*models.Review{}
+	}
+
+	var payload = struct {
+		Reviews       []*models.Review `json:"reviews"`
+		RatingAverage float64          `json:"rating_average"`
+		RatingCount   int              `json:"rating_count"`
+		Sort          string           `json:"sort"`
+		Page          int              `json:"page"`
+		PageSize      int              `json:"page_size"`
+		Total         int              `json:"total"`
+	}{
+		Reviews:       reviews,
+		RatingAverage: movie.RatingAverage,
+		RatingCount:   movie.RatingCount,
+		Sort:          query.Sort,
+		Page:          query.Page,
+		PageSize:      query.PageSize,
+		Total:         total,
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// SaveReview rates a movie for the logged in user, with an optional review, eg. {"rating": 8, "review": "..."}.
+// Sending it again replaces their rating & review. Reviews with banned words are flagged for a moderator,
+// and with -premoderate-reviews every review waits for one (see models.SubmittedReviewStatus)
+func (app *application) SaveReview(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	movie, err := app.movieFromURL(r)
+	if err != nil {
+		app.movieErrorJSON(w, err)
+		return
+	}
+
+	var payload struct {
+		Rating int    `json:"rating"`
+		Review string `json:"review"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	review := models.Review{
+		MovieID: movie.ID,
+		UserID:  user.ID,
+		Rating:  payload.Rating,
+		Body:    strings.TrimSpace(payload.Review),
+	}
+
+	if review.Rating < models.MinRating || review.Rating > models.MaxRating {
+		app.errorJSON(w, fmt.Errorf("rating must be between %d and %d", models.MinRating, models.MaxRating), http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(review.Body) > maxReviewLength {
+		app.errorJSON(w, fmt.Errorf("review can't be longer than %d characters", maxReviewLength), http.StatusBadRequest)
+		return
+	}
+
+	previous, err := app.DB.UserReview(movie.ID, user.ID)
+	created := errors.Is(err, sql.ErrNoRows)
+	if err != nil && !created {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	previousStatus := ""
+	if !created {
+		previousStatus = previous.Status
+	}
+
+	banned := app.BannedWords.Match(review.Body)
+	review.Status = models.SubmittedReviewStatus(previousStatus, len(banned) > 0, app.PremoderateReviews)
+
+	id, err := app.DB.SaveReview(review)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// the filter is a moderation decision too, made by nobody in particular
+	if review.Status == models.ReviewFlagged {
+		from := previousStatus
+		if from == "" {
+			from = models.ReviewPending
+		}
+		err = app.DB.InsertModerationDecision(models.ModerationDecision{
+			ReviewID:   id,
+			FromStatus: from,
+			ToStatus:   models.ReviewFlagged,
+			Reason:     "banned words: " + strings.Join(banned, ", "),
+		})
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	saved, err := app.DB.GetReview(id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "review updated",
+		Data:    saved,
+	}
+	status := http.StatusAccepted
+
+	if created {
+		resp.Message = "review added"
+		status = http.StatusCreated
+	}
+	// we don't say why, so the filter can't be probed word by word
+	if saved.Status != models.ReviewPublished {
+		resp.Message = "review submitted for moderation"
+	}
+
+	app.writeJSON(w, status, resp)
+}
+
+// DeleteReview takes back the logged in user's rating & review of a movie
+func (app *application) DeleteReview(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	movie, err := app.movieFromURL(r)
+	if err != nil {
+		app.movieErrorJSON(w, err)
+		return
+	}
+
+	review, err := app.DB.UserReview(movie.ID, user.ID)
+	if err != nil {
+		app.reviewErrorJSON(w, err)
+		return
+	}
+
+	err = app.DB.DeleteReview(review.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "review deleted",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// VoteReviewHelpful marks someone else's review as helpful. Voting twice counts once
+func (app *application) VoteReviewHelpful(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	review, err := app.reviewFromURL(r, user.ID)
+	if err != nil {
+		app.reviewErrorJSON(w, err)
+		return
+	}
+
+	if review.UserID == user.ID {
+		app.errorJSON(w, errors.New("you can't vote for your own review"), http.StatusBadRequest)
+		return
+	}
+
+	_, err = app.DB.VoteReviewHelpful(review.ID, user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.helpfulVoteResponse(w, review.ID, "vote recorded")
+}
+
+// UnvoteReviewHelpful takes back a helpful vote
+func (app *application) UnvoteReviewHelpful(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	review, err := app.reviewFromURL(r, user.ID)
+	if err != nil {
+		app.reviewErrorJSON(w, err)
+		return
+	}
+
+	_, err = app.DB.UnvoteReviewHelpful(review.ID, user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.helpfulVoteResponse(w, review.ID, "vote removed")
+}
+
+// ReportReview tells the moderators a review breaks the rules, eg. {"reason": "spoilers"}. Once
+// -review-report-threshold users have reported a published review it is flagged, which hides it until
+// a moderator decides
+func (app *application) ReportReview(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	review, err := app.reviewFromURL(r, user.ID)
+	if err != nil {
+		app.reviewErrorJSON(w, err)
+		return
+	}
+
+	if review.UserID == user.ID {
+		app.errorJSON(w, errors.New("you can't report your own review"), http.StatusBadRequest)
+		return
+	}
+
+	var payload struct {
+		Reason string `json:"reason"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	payload.Reason = strings.TrimSpace(payload.Reason)
+	if utf8.RuneCountInString(payload.Reason) > maxReportLength {
+		app.errorJSON(w, fmt.Errorf("reason can't be longer than %d characters", maxReportLength), http.StatusBadRequest)
+		return
+	}
+
+	reports, err := app.DB.ReportReview(models.ReviewReport{
+		ReviewID: review.ID,
+		UserID:   user.ID,
+		Reason:   payload.Reason,
+	})
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if reports >= app.ReportThreshold && review.Status == models.ReviewPublished {
+		err = app.DB.ModerateReview(models.ModerationDecision{
+			ReviewID:   review.ID,
+			FromStatus: models.ReviewPublished,
+			ToStatus:   models.ReviewFlagged,
+			Reason:     fmt.Sprintf("reported by %d users", reports),
+		})
+		// someone else's report (or a moderator) may have got there first, which is just as good
+		if err != nil && !errors.Is(err, repository.ErrReviewStatusChanged) {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "thanks, a moderator will take a look",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// reviewFromURL loads the review named by the {id} URL parameter. Reviews the user can't see (because
+// they aren't published, or their author is shadow hidden) aren't found, unless they are the user's own
+func (app *application) reviewFromURL(r *http.Request, userID int) (*models.Review, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		return nil, sql.ErrNoRows
+	}
+
+	review, err := app.DB.GetReview(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if review.UserID != userID && (review.Status != models.ReviewPublished || review.ShadowHidden) {
+		return nil, sql.ErrNoRows
+	}
+
+	return review, nil
+}
+
+// helpfulVoteResponse answers a vote with the review's helpful count as it now stands
+func (app *application) helpfulVoteResponse(w http.ResponseWriter, reviewID int, message string) {
+	review, err := app.DB.GetReview(reviewID)
+	if err != nil {
+		app.reviewErrorJSON(w, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: message,
+		Data: map[string]int{
+			"review_id":     review.ID,
+			"helpful_count": review.HelpfulCount,
+		},
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+func (app *application) reviewErrorJSON(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("review not found"), http.StatusNotFound)
+		return
+	}
+	app.errorJSON(w, err, http.StatusInternalServerError)
+}
+
+// movieFromURL loads the movie named by the {id} URL parameter
+func (app *application) movieFromURL(r *http.Request) (*models.Movie, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		return nil, sql.ErrNoRows
+	}
+
+	return app.DB.OneMovie(id)
+}
+
+func (app *application) movieErrorJSON(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("movie not found"), http.StatusNotFound)
+		return
+	}
+	app.errorJSON(w, err, http.StatusInternalServerError)
+}
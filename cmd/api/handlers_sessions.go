@@ -0,0 +1,173 @@
+package main
+
+import (
+	"backend/internal/models"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxUserAgentLength is as much of the User-Agent header as we keep with a session
+const maxUserAgentLength = 512
+
+// sessionUserAgent is the User-Agent we record for a session, so users can recognise their devices.
+// Postgres refuses invalid UTF-8, so bad bytes are replaced and it is cut short between characters
+func sessionUserAgent(r *http.Request) string {
+	ua := strings.ToValidUTF8(r.UserAgent(), "\uFFFD")
+	if len(ua) > maxUserAgentLength {
+		n := maxUserAgentLength
+		for n > 0 && !utf8.RuneStart(ua[n]) {
+			n--
+		}
+		ua = ua[:n]
+	}
+	return ua
+}
+
+// MySessions lists where the user is logged in. The session the request was made from is marked current
+func (app *application) MySessions(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	sessions, err := app.Tokens.UserSessions(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	claims := claimsFromContext(r)
+	for _, session := range sessions {
+		session.Current = session.ID == claims.SessionID
+	}
+
+	if sessions == nil {
+		sessions = []*models.Session{}
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, sessions)
+}
+
+// RevokeMySession signs one of the user's sessions out. Access tokens already issued to it keep
+// working until they expire (15 minutes at most), but its next /refresh fails
+func (app *application) RevokeMySession(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+
+	session, err := app.Tokens.GetSession(id)
+	if err != nil || session.UserID != user.ID {
+		// someone else's session is as good as one that doesn't exist
+		app.errorJSON(w, errors.New("session not found"), http.StatusNotFound)
+		return
+	}
+
+	err = app.Tokens.RevokeRefreshTokenFamily(session.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if session.ID == claimsFromContext(r).SessionID {
+		http.SetCookie(w, app.auth.GetExpiredRefreshCookie())
+		http.SetCookie(w, app.expiredCSRFCookie())
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "session signed out",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// RevokeMySessions signs the user out everywhere, including this session
+func (app *application) RevokeMySessions(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	err = app.Tokens.RevokeUserRefreshTokens(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, app.auth.GetExpiredRefreshCookie())
+	http.SetCookie(w, app.expiredCSRFCookie())
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "signed out everywhere",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// UserSessions lets admins see where a user is logged in
+func (app *application) UserSessions(w http.ResponseWriter, r *http.Request) {
+	user, err := app.userFromURL(r)
+	if err != nil {
+		app.userErrorJSON(w, err)
+		return
+	}
+
+	sessions, err := app.Tokens.UserSessions(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if sessions == nil {
+		sessions = []*models.Session{}
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, sessions)
+}
+
+// RevokeUserSessions lets admins sign a user out everywhere, eg. when their device is lost
+func (app *application) RevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	user, err := app.userFromURL(r)
+	if err != nil {
+		app.userErrorJSON(w, err)
+		return
+	}
+
+	err = app.Tokens.RevokeUserRefreshTokens(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, AuditUserSessionsKill, "user", user.ID, nil, nil)
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "user signed out everywhere",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// userFromURL loads the user named by the {id} URL parameter
+func (app *application) userFromURL(r *http.Request) (*models.User, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		return nil, sql.ErrNoRows
+	}
+
+	return app.DB.GetUserById(id)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSessionUserAgent(t *testing.T) {
+	tests := []struct {
+		name string
+		ua   string
+		want string
+	}{
+		{"short", "Mozilla/5.0", "Mozilla/5.0"},
+		{"invalid utf-8", "Mozilla\xff/5.0", "Mozilla�/5.0"},
+		{"cut between characters", strings.Repeat("a", maxUserAgentLength-1) + "é", strings.Repeat("a", maxUserAgentLength-1)},
+		{"cut at the limit", strings.Repeat("a", maxUserAgentLength+10), strings.Repeat("a", maxUserAgentLength)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/authenticate", nil)
+			r.Header.Set("User-Agent", tt.ua)
+
+			got := sessionUserAgent(r)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if !utf8.ValidString(got) || len(got) > maxUserAgentLength {
+				t.Errorf("%q isn't valid UTF-8 of at most %d bytes", got, maxUserAgentLength)
+			}
+		})
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+)
+
+// register lets a visitor create their own account
+func (app *application) register(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+		Email     string `json:"email"`
+		Password  string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user := models.User{
+		FirstName: strings.TrimSpace(requestPayload.FirstName),
+		LastName:  strings.TrimSpace(requestPayload.LastName),
+		Email:     normalizeEmail(requestPayload.Email),
+		Password:  requestPayload.Password,
+		Role:      models.RoleViewer, // self-registered users can never choose their own role
+	}
+
+	if user.FirstName == "" || user.LastName == "" {
+		app.errorJSON(w, errors.New("first name and last name are required"), http.StatusBadRequest)
+		return
+	}
+
+	if err := validateEmail(user.Email); err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := app.validatePassword(user.Password); err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	newID, err := app.DB.InsertUser(user)
+	if err != nil {
+		app.userErrorJSON(w, err)
+		return
+	}
+
+	created, err := app.DB.GetUserById(newID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// the account works without it (unless -require-verified-email is set), so a failure here
+	// shouldn't fail the registration. The user can ask for another email later
+	err = app.sendEmailVerification(created)
+	if err != nil {
+		log.Println(err)
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "user created",
+		Data:    created,
+	}
+
+	app.writeJSON(w, http.StatusCreated, resp)
+}
+
+// GetMe returns the account of the currently authenticated user
+func (app *application) GetMe(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, user)
+}
+
+// UpdateMe lets the authenticated user change their names and email. Only the fields sent are updated
+func (app *application) UpdateMe(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	// NOTES: pointers let us tell a field that was left out of the payload apart from one sent as an empty string
+	var payload struct {
+		FirstName *string `json:"first_name"`
+		LastName  *string `json:"last_name"`
+		Email     *string `json:"email"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if payload.FirstName != nil {
+		user.FirstName = strings.TrimSpace(*payload.FirstName)
+	}
+	if payload.LastName != nil {
+		user.LastName = strings.TrimSpace(*payload.LastName)
+	}
+	emailChanged := false
+	if payload.Email != nil {
+		emailChanged = normalizeEmail(*payload.Email) != user.Email
+		user.Email = normalizeEmail(*payload.Email)
+	}
+
+	if user.FirstName == "" || user.LastName == "" {
+		app.errorJSON(w, errors.New("first name and last name are required"), http.StatusBadRequest)
+		return
+	}
+
+	if err := validateEmail(user.Email); err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// a reset link sent to the old address mustn't be able to verify the new one
+	if emailChanged {
+		err = app.DB.DeleteUserTokens(user.ID, models.TokenPurposePasswordReset)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = app.DB.UpdateUser(*user)
+	if err != nil {
+		app.userErrorJSON(w, err)
+		return
+	}
+
+	// a new email address has to be verified all over again
+	if emailChanged {
+		user.EmailVerifiedAt = nil
+		err = app.sendEmailVerification(user)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "account updated",
+		Data:    user,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// ResendVerification emails the authenticated user a new verification link
+func (app *application) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	if user.EmailVerifiedAt != nil {
+		app.errorJSON(w, errors.New("email address already verified"), http.StatusConflict)
+		return
+	}
+
+	err = app.sendEmailVerification(user)
+	if err != nil {
+		app.errorJSON(w, errors.New("could not send verification email"), http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "verification email sent",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// ChangePassword requires the current password before a new one is set, then signs out every other session
+func (app *application) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	var payload struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	valid, err := user.PasswordMatches(payload.CurrentPassword)
+	if err != nil || !valid {
+		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
+		return
+	}
+
+	if err := app.validatePassword(payload.NewPassword); err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.DB.UpdatePassword(user.ID, payload.NewPassword)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// log out every other device that was using the old password. This one stays logged in
+	err = app.Tokens.RevokeOtherRefreshTokens(user.ID, claimsFromContext(r).SessionID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "password changed",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// DeleteMe deletes the authenticated user's account once they have confirmed their password,
+// and logs them out by expiring the refresh cookie. Admins who still have active API keys are refused,
+// as the keys would go with them (an admin deleting them through /admin/users hands them over instead)
+func (app *application) DeleteMe(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	var payload struct {
+		Password string `json:"password"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	valid, err := user.PasswordMatches(payload.Password)
+	if err != nil || !valid {
+		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
+		return
+	}
+
+	// deleting the user would delete the API keys they created with them, and break whatever uses them
+	hasKeys, err := app.hasActiveAPIKeys(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	if hasKeys {
+		app.errorJSON(w, errors.New("you have active API keys: revoke them, or ask another admin to delete your account & take them over"), http.StatusConflict)
+		return
+	}
+
+	err = app.DB.DeleteUser(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, app.auth.GetExpiredRefreshCookie())
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "account deleted",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// currentUser loads the user whose id is the subject of the claims authRequired put on the request
+func (app *application) currentUser(r *http.Request) (*models.User, error) {
+	claims := claimsFromContext(r)
+	if claims == nil {
+		return nil, errors.New("unauthorized")
+	}
+
+	// API keys act for a service, not for the admin who created them
+	if claims.APIKeyID != 0 {
+		return nil, errors.New("api keys can't manage accounts")
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, errors.New("unknown user")
+	}
+
+	user, err := app.DB.GetUserById(userID)
+	if err != nil {
+		return nil, errors.New("unknown user")
+	}
+
+	if user.DisabledAt != nil {
+		return nil, errAccountDisabled
+	}
+
+	return user, nil
+}
+
+// userErrorJSON turns errors from the user repository methods into responses that don't leak DB details
+func (app *application) userErrorJSON(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repository.ErrDuplicateEmail):
+		app.errorJSON(w, err, http.StatusConflict)
+	case errors.Is(err, sql.ErrNoRows):
+		app.errorJSON(w, errors.New("user not found"), http.StatusNotFound)
+	default:
+		app.errorJSON(w, errors.New("could not save user"), http.StatusInternalServerError)
+	}
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func validateEmail(email string) error {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return errors.New("a valid email address is required")
+	}
+	return nil
+}
+
+// validatePassword checks a new password against the password policy (length & the breached list)
+func (app *application) validatePassword(password string) error {
+	return app.PasswordPolicy.Check(password)
+}
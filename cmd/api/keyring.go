@@ -0,0 +1,410 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// rsaKeyBits is the size of the RSA keys we generate when no signing key file is configured
+const rsaKeyBits = 2048
+
+// jwtKey is one key in the KeyRing. signKey is only set for the key we sign with; keys that are
+// being rotated out only have a verifyKey, so tokens they signed are accepted until they expire
+type jwtKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// KeyRing holds the keys used to sign & verify our JWTs. Every token we sign carries the id of its key
+// in the 'kid' header, which is how we find the right key to verify it with while keys are rotated
+type KeyRing struct {
+	mu      sync.RWMutex
+	signing *jwtKey
+	keys    map[string]*jwtKey
+}
+
+// NewKeyRing creates a key ring which signs with the given key
+func NewKeyRing(signing *jwtKey) *KeyRing {
+	k := &KeyRing{keys: map[string]*jwtKey{}}
+	k.SetSigningKey(signing)
+	return k
+}
+
+// SetSigningKey makes key the one new tokens are signed with. The previous signing key is kept as a
+// verification key only, so tokens it signed are accepted until they expire but it signs no new ones
+func (k *KeyRing) SetSigningKey(key *jwtKey) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.signing != nil && k.signing.ID != key.ID {
+		k.keys[k.signing.ID] = verificationOnly(k.signing)
+	}
+
+	k.signing = key
+	k.keys[key.ID] = key
+}
+
+// AddVerificationKey adds a key that tokens are still accepted from, but that we no longer sign with.
+// The signing key already verifies its own tokens, so adding it again is ignored rather than leaving
+// the ring without a key to sign with
+func (k *KeyRing) AddVerificationKey(key *jwtKey) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.signing != nil && k.signing.ID == key.ID {
+		return
+	}
+
+	k.keys[key.ID] = verificationOnly(key)
+}
+
+func verificationOnly(key *jwtKey) *jwtKey {
+	return &jwtKey{ID: key.ID, Method: key.Method, verifyKey: key.verifyKey}
+}
+
+// NewToken creates an empty token for the current signing key, with its 'kid' header already set
+func (k *KeyRing) NewToken() *jwt.Token {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	token := jwt.New(k.signing.Method)
+	token.Header["kid"] = k.signing.ID
+	return token
+}
+
+// Sign signs a token created by NewToken
+func (k *KeyRing) Sign(token *jwt.Token) (string, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	kid, _ := token.Header["kid"].(string)
+	key, ok := k.keys[kid]
+	if !ok || key.signKey == nil {
+		return "", errors.New("token was not created with the current signing key")
+	}
+
+	return token.SignedString(key.signKey)
+}
+
+// Keyfunc is a jwt.Keyfunc that picks the verification key by the token's 'kid' header. The token must
+// be signed with the algorithm that belongs to that key, otherwise eg. an RSA public key could be
+// passed off as an HMAC secret. Tokens without a 'kid' (issued before we had a key ring) are checked
+// against the current signing key
+func (k *KeyRing) Keyfunc(token *jwt.Token) (interface{}, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	key := k.signing
+	if kid, ok := token.Header["kid"]; ok {
+		id, _ := kid.(string)
+		key, ok = k.keys[id]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %v", kid)
+		}
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+
+	return key.verifyKey, nil
+}
+
+// Algorithms lists the algorithms of every key in the ring, for use with jwt.WithValidMethods
+func (k *KeyRing) Algorithms() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	seen := map[string]bool{}
+	var algs []string
+	for _, key := range k.keys {
+		if !seen[key.Method.Alg()] {
+			seen[key.Method.Alg()] = true
+			algs = append(algs, key.Method.Alg())
+		}
+	}
+	return algs
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519 keys
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKS returns the public half of every asymmetric key in the ring. HMAC secrets are never published
+func (k *KeyRing) JWKS() []JWK {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	keys := []JWK{}
+	for _, key := range k.keys {
+		jwk, ok := publicJWK(key.ID, key.Method, key.verifyKey)
+		if ok {
+			keys = append(keys, jwk)
+		}
+	}
+	return keys
+}
+
+func publicJWK(kid string, method jwt.SigningMethod, public interface{}) (JWK, bool) {
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			KeyType:   "RSA",
+			KeyID:     kid,
+			Use:       "sig",
+			Algorithm: method.Alg(),
+			N:         base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}, true
+	case ed25519.PublicKey:
+		return JWK{
+			KeyType:   "OKP",
+			KeyID:     kid,
+			Use:       "sig",
+			Algorithm: method.Alg(),
+			Curve:     "Ed25519",
+			X:         base64.RawURLEncoding.EncodeToString(pub),
+		}, true
+	}
+	return JWK{}, false
+}
+
+// thumbprint is the RFC 7638 JWK thumbprint of a public key, which we use as its key id. It only
+// depends on the key material, so every instance of the API derives the same id for the same key
+func thumbprint(public interface{}) (string, error) {
+	var members string
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		jwk, _ := publicJWK("", jwt.SigningMethodRS256, pub)
+		members = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, jwk.E, jwk.N)
+	case ed25519.PublicKey:
+		jwk, _ := publicJWK("", jwt.SigningMethodEdDSA, pub)
+		members = fmt.Sprintf(`{"crv":"Ed25519","kty":"OKP","x":"%s"}`, jwk.X)
+	default:
+		return "", fmt.Errorf("unsupported key type %T", public)
+	}
+
+	sum := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// newHMACKey wraps a shared secret for HS256
+func newHMACKey(secret string) *jwtKey {
+	sum := sha256.Sum256([]byte(secret))
+	return &jwtKey{
+		ID:        "hs256-" + hex.EncodeToString(sum[:8]),
+		Method:    jwt.SigningMethodHS256,
+		signKey:   []byte(secret),
+		verifyKey: []byte(secret),
+	}
+}
+
+// newAsymmetricKey wraps an RSA or Ed25519 private key. The signing method comes from the key type
+func newAsymmetricKey(private crypto.Signer) (*jwtKey, error) {
+	var method jwt.SigningMethod
+	switch private.(type) {
+	case *rsa.PrivateKey:
+		method = jwt.SigningMethodRS256
+	case ed25519.PrivateKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", private)
+	}
+
+	kid, err := thumbprint(private.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	return &jwtKey{ID: kid, Method: method, signKey: private, verifyKey: private.Public()}, nil
+}
+
+// generateKey creates a throw away key for the given algorithm. Tokens signed with it stop verifying
+// when the process restarts, so it is only meant for local development
+func generateKey(alg string) (*jwtKey, error) {
+	switch alg {
+	case jwt.SigningMethodRS256.Alg():
+		private, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return nil, err
+		}
+		return newAsymmetricKey(private)
+	case jwt.SigningMethodEdDSA.Alg():
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return newAsymmetricKey(private)
+	}
+	return nil, fmt.Errorf("unsupported signing algorithm %s", alg)
+}
+
+// buildKeyRing sets up the key ring from the command line flags. HS256 signs with the shared secret.
+// RS256 & EdDSA sign with the private key in signingKeyPath, or a generated key if that is empty.
+// verifyKeyPaths is a comma separated list of PEM files for keys that are being rotated out
+func buildKeyRing(alg, secret, signingKeyPath, verifyKeyPaths string) (*KeyRing, error) {
+	var signing *jwtKey
+	var err error
+
+	switch {
+	case alg == jwt.SigningMethodHS256.Alg():
+		signing = newHMACKey(secret)
+	case signingKeyPath != "":
+		signing, err = loadPrivateKey(signingKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		if signing.Method.Alg() != alg {
+			return nil, fmt.Errorf("%s holds a %s key, not %s", signingKeyPath, signing.Method.Alg(), alg)
+		}
+	default:
+		log.Printf("no -jwt-signing-key given, generating a temporary %s key. Tokens will not survive a restart", alg)
+		signing, err = generateKey(alg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	keys := NewKeyRing(signing)
+
+	for _, path := range strings.Split(verifyKeyPaths, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		key, err := loadVerificationKey(path)
+		if err != nil {
+			return nil, err
+		}
+		keys.AddVerificationKey(key)
+	}
+
+	return keys, nil
+}
+
+// loadPrivateKey reads a PEM encoded RSA (PKCS #1 or #8) or Ed25519 (PKCS #8) private key from disk
+func loadPrivateKey(path string) (*jwtKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var private interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported key type %T", path, private)
+	}
+
+	return newAsymmetricKey(signer)
+}
+
+// loadVerificationKey reads a PEM encoded public key (or a private key, whose public half is used).
+// These are the keys being rotated out, which we still accept tokens from
+func loadVerificationKey(path string) (*jwtKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if block.Type != "PUBLIC KEY" {
+		key, err := loadPrivateKey(path)
+		if err != nil {
+			return nil, err
+		}
+		key.signKey = nil
+		return key, nil
+	}
+
+	public, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var method jwt.SigningMethod
+	switch public.(type) {
+	case *rsa.PublicKey:
+		method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("%s: unsupported key type %T", path, public)
+	}
+
+	kid, err := thumbprint(public)
+	if err != nil {
+		return nil, err
+	}
+
+	return &jwtKey{ID: kid, Method: method, verifyKey: public}, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+
+	return block, nil
+}
+
+// jwks publishes our public verification keys so other services can verify our access tokens
+// without having to share a secret with us
+func (app *application) jwks(w http.ResponseWriter, r *http.Request) {
+	var payload = struct {
+		Keys []JWK `json:"keys"`
+	}{
+		Keys: app.auth.Keys.JWKS(),
+	}
+
+	headers := http.Header{}
+	headers.Set("Cache-Control", "public, max-age=300")
+
+	_ = app.writeJSON(w, http.StatusOK, payload, headers)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func signWith(t *testing.T, keys *KeyRing) string {
+	t.Helper()
+
+	token := keys.NewToken()
+	token.Claims = jwt.MapClaims{"sub": "1"}
+	signed, err := keys.Sign(token)
+	if err != nil {
+		t.Fatalf("signing: %v", err)
+	}
+	return signed
+}
+
+func TestKeyRingRotation(t *testing.T) {
+	old, err := generateKey("EdDSA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := generateKey("EdDSA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := NewKeyRing(old)
+	signedByOld := signWith(t, keys)
+	stale := keys.NewToken()
+
+	keys.SetSigningKey(current)
+
+	if _, err := jwt.Parse(signedByOld, keys.Keyfunc); err != nil {
+		t.Errorf("token signed by the old key no longer verifies: %v", err)
+	}
+	if _, err := keys.Sign(stale); err == nil {
+		t.Error("the old key can still sign")
+	}
+	if _, err := jwt.Parse(signWith(t, keys), keys.Keyfunc); err != nil {
+		t.Errorf("token signed by the new key doesn't verify: %v", err)
+	}
+}
+
+func TestKeyRingAddSigningKeyAsVerificationKey(t *testing.T) {
+	signing, err := generateKey("EdDSA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := NewKeyRing(signing)
+	keys.AddVerificationKey(&jwtKey{ID: signing.ID, Method: signing.Method, verifyKey: signing.verifyKey})
+
+	if _, err := jwt.Parse(signWith(t, keys), keys.Keyfunc); err != nil {
+		t.Errorf("token doesn't verify: %v", err)
+	}
+}
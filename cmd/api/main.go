@@ -1,12 +1,25 @@
 package main
 
 import (
+	"backend/internal/mailer"
+	"backend/internal/moderation"
+	"backend/internal/oidc"
+	"backend/internal/password"
+	"backend/internal/playback"
+	"backend/internal/recommend"
 	"backend/internal/repository"
 	"backend/internal/repository/dbrepo"
+	"backend/internal/repository/memrepo"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -20,12 +33,61 @@ type application struct {
 	// of the repository.DatabaseRepo interface. This is how interfaces work in Go.
 	// To use a different type of DB, just change the value u assign to DB below to the required STRUCT that must
 	// meet the requirements of the repository.DatabaseRepo interface.
-	auth         Auth
-	JWTSecret    string
-	JWTIssuer    string
-	JWTAudience  string
-	CookieDomain string
-	APIKey       string
+
+	// Tokens is where refresh tokens are stored, so they can be rotated & revoked
+	Tokens repository.RefreshTokenRepo
+	// Attempts counts failed logins, to lock out password guessing
+	Attempts      repository.LoginAttemptRepo
+	auth          Auth
+	JWTSecret     string
+	JWTAlg        string
+	JWTKeyFile    string
+	JWTVerifyKeys string
+	JWTIssuer     string
+	JWTAudience   string
+	CookieDomain  string
+	APIKey        string
+
+	// Mailer sends password reset & email verification emails. Links in them point at FrontendURL
+	Mailer      mailer.Mailer
+	FrontendURL string
+	// RequireVerifiedEmail stops users logging in until they have verified their email address
+	RequireVerifiedEmail bool
+	// AdminRequireMFA refuses admin access to anyone who didn't log in with a one-time code
+	AdminRequireMFA bool
+
+	// Hasher hashes new passwords & says when stored hashes need upgrading. PasswordPolicy is what
+	// new passwords must satisfy
+	Hasher         password.Hasher
+	PasswordPolicy password.Policy
+
+	// AllowedOrigins are the frontends allowed to call us from a browser (CORS & CSRF checks)
+	AllowedOrigins []string
+
+	// OIDC is the identity provider users can log in with instead of a password. nil when not configured
+	OIDC *oidc.Provider
+
+	// BannedWords flags reviews that use them for a moderator. PremoderateReviews holds every new review
+	// back until a moderator publishes it, and ReportThreshold is how many users must report a published
+	// review before it is flagged
+	BannedWords        moderation.Filter
+	PremoderateReviews bool
+	ReportThreshold    int
+
+	// Progress holds playback positions until they are written to the database in a batch, every
+	// ProgressFlushInterval
+	Progress              *playback.Buffer
+	ProgressFlushInterval time.Duration
+
+	// SimilarWeights say how much genres, cast & crew, release dates & ratings count when recommending
+	// similar movies. The recommendations are cached for SimilarCacheTTL
+	SimilarWeights  recommend.Weights
+	SimilarCacheTTL time.Duration
+
+	// titles answers title suggestions when DB can't, see suggestTitles
+	titles titleIndex
+	// similar caches similar movie recommendations, see similarMovies
+	similar similarIndex
 }
 
 func main() {
@@ -34,14 +96,126 @@ func main() {
 
 	// create command line flags (to read from CLI)
 	flag.StringVar(&app.DSN, "dsn", "host=localhost port=5432 user=postgres password=postgres dbname=movies sslmode=disable timezone=UTC connect_timeout=5", "Postgres connection string")
-	flag.StringVar(&app.JWTSecret, "jwt-secret", "verysecret", "signing secret")
+	flag.StringVar(&app.JWTSecret, "jwt-secret", "verysecret", "signing secret (HS256 only)")
+	flag.StringVar(&app.JWTAlg, "jwt-alg", "HS256", "signing algorithm: HS256, RS256 or EdDSA")
+	flag.StringVar(&app.JWTKeyFile, "jwt-signing-key", "", "PEM file with the RS256/EdDSA private key to sign with")
+	flag.StringVar(&app.JWTVerifyKeys, "jwt-verify-keys", "", "comma separated PEM files of old keys still accepted while rotating")
 	flag.StringVar(&app.JWTIssuer, "jwt-issuer", "example.com", "signing issuer")
 	flag.StringVar(&app.JWTAudience, "jwt-audience", "example.com", "signing audience")
 	flag.StringVar(&app.CookieDomain, "cookie-domain", "localhost", "cookie domain")
 	flag.StringVar(&app.Domain, "domain", "example.com", "domain")
 	flag.StringVar(&app.APIKey, "api-key", "12bf99076cbd076fc0c74b70b2b1000a", "api key")
+	flag.StringVar(&app.FrontendURL, "frontend-url", "http://localhost:3000", "base URL of the frontend, used in emailed links")
+	flag.BoolVar(&app.AdminRequireMFA, "admin-require-mfa", false, "only let admins use their role after logging in with two-factor authentication")
+	flag.BoolVar(&app.RequireVerifiedEmail, "require-verified-email", false, "refuse logins from users who haven't verified their email")
+
+	var mailerKind, mailDir, mailFrom string
+	var smtpMailer mailer.SMTPMailer
+	flag.StringVar(&mailerKind, "mailer", "file", "how to send email: smtp, file or memory")
+	flag.StringVar(&mailDir, "mail-dir", "mail", "folder the file mailer writes emails to")
+	flag.StringVar(&mailFrom, "mail-from", "Go Movies <no-reply@example.com>", "sender address of our emails")
+	flag.StringVar(&smtpMailer.Host, "smtp-host", "localhost", "SMTP server host")
+	flag.IntVar(&smtpMailer.Port, "smtp-port", 587, "SMTP server port")
+	flag.StringVar(&smtpMailer.Username, "smtp-username", "", "SMTP username (leave empty for no auth)")
+	flag.StringVar(&smtpMailer.Password, "smtp-password", "", "SMTP password")
+	var breachedPasswords string
+	var argon2Memory, argon2Time, argon2Threads uint
+	flag.StringVar(&app.Hasher.Algorithm, "password-hash", password.Argon2id, "algorithm for new password hashes: argon2id or bcrypt")
+	flag.UintVar(&argon2Memory, "argon2-memory", uint(password.DefaultArgon2.Memory), "argon2id memory in KiB")
+	flag.UintVar(&argon2Time, "argon2-time", uint(password.DefaultArgon2.Time), "argon2id iterations")
+	flag.UintVar(&argon2Threads, "argon2-threads", uint(password.DefaultArgon2.Threads), "argon2id parallelism")
+	flag.IntVar(&app.Hasher.BcryptCost, "bcrypt-cost", password.DefaultBcryptCost, "bcrypt cost")
+	flag.IntVar(&app.PasswordPolicy.MinLength, "password-min-length", 8, "minimum password length")
+	flag.StringVar(&breachedPasswords, "breached-passwords", "", "file of breached passwords (plain text or SHA-1 hashes, one per line) that can't be used")
+	var allowedOrigins string
+	flag.StringVar(&allowedOrigins, "allowed-origins", "http://localhost:3000", "comma separated origins of the frontends allowed to call the API from a browser")
+	var oidcProvider oidc.Provider
+	flag.StringVar(&oidcProvider.Issuer, "oidc-issuer", "", "OpenID Connect issuer URL for single sign-on (leave empty to turn it off)")
+	flag.StringVar(&oidcProvider.ClientID, "oidc-client-id", "", "OpenID Connect client id")
+	flag.StringVar(&oidcProvider.ClientSecret, "oidc-client-secret", "", "OpenID Connect client secret (leave empty for a public client)")
+	flag.StringVar(&oidcProvider.RedirectURL, "oidc-redirect-url", "http://localhost:8080/auth/oidc/callback", "OpenID Connect redirect URL, as registered with the provider")
+	flag.BoolVar(&oidcProvider.TrustAMR, "oidc-trust-amr", false, "count the identity provider's two-factor authentication (its amr claim) as our own")
+	var attemptsStore string
+	flag.StringVar(&attemptsStore, "login-attempts-store", "postgres", "where failed login counters are kept: postgres or memory (single instance only)")
+	var bannedWords, bannedWordsFile string
+	flag.StringVar(&bannedWords, "banned-words", "", "comma separated words & phrases that flag a review for moderation")
+	flag.StringVar(&bannedWordsFile, "banned-words-file", "", "file of words & phrases, one per line, that flag a review for moderation")
+	flag.BoolVar(&app.PremoderateReviews, "premoderate-reviews", false, "hold every new review back until a moderator publishes it")
+	flag.IntVar(&app.ReportThreshold, "review-report-threshold", 3, "how many users must report a review before it is flagged for moderation")
+	flag.Float64Var(&app.SimilarWeights.Genres, "similar-genre-weight", recommend.DefaultWeights.Genres, "how much shared genres count towards similar movies")
+	flag.Float64Var(&app.SimilarWeights.People, "similar-people-weight", recommend.DefaultWeights.People, "how much shared cast & crew count towards similar movies")
+	flag.Float64Var(&app.SimilarWeights.Era, "similar-era-weight", recommend.DefaultWeights.Era, "how much close release dates count towards similar movies")
+	flag.Float64Var(&app.SimilarWeights.Rating, "similar-rating-weight", recommend.DefaultWeights.Rating, "how much the same MPAA rating counts towards similar movies")
+	flag.Float64Var(&app.SimilarWeights.EraYears, "similar-era-years", recommend.DefaultWeights.EraYears, "how many years apart movies can come out & still count as the same era")
+	flag.DurationVar(&app.SimilarCacheTTL, "similar-cache-ttl", time.Minute*10, "how long similar movie recommendations are cached")
+	flag.DurationVar(&app.ProgressFlushInterval, "progress-flush-interval", time.Second*10, "how often buffered playback positions are written to the database")
 	flag.Parse()
 
+	app.Hasher.Argon2 = password.Argon2Params{
+		Memory:  uint32(argon2Memory),
+		Time:    uint32(argon2Time),
+		Threads: uint8(argon2Threads),
+		SaltLen: password.DefaultArgon2.SaltLen,
+		KeyLen:  password.DefaultArgon2.KeyLen,
+	}
+	switch app.Hasher.Algorithm {
+	case password.Argon2id:
+		app.PasswordPolicy.MaxLength = 256
+	case password.Bcrypt:
+		// bcrypt ignores everything after the 72nd byte of a password, so we refuse anything longer
+		app.PasswordPolicy.MaxLength = 72
+	default:
+		log.Fatalf("unknown password hash %q", app.Hasher.Algorithm)
+	}
+
+	if breachedPasswords != "" {
+		n, err := app.PasswordPolicy.LoadBreached(breachedPasswords)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Loaded %d breached passwords", n)
+	}
+
+	app.BannedWords.Add(strings.Split(bannedWords, ",")...)
+	if bannedWordsFile != "" {
+		n, err := app.BannedWords.LoadFile(bannedWordsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Loaded %d banned words", n)
+	}
+	if app.ReportThreshold < 1 {
+		log.Fatal("-review-report-threshold must be at least 1")
+	}
+	if err := app.SimilarWeights.Validate(); err != nil {
+		log.Fatal("-similar-*: ", err)
+	}
+	if app.ProgressFlushInterval <= 0 {
+		log.Fatal("-progress-flush-interval must be positive")
+	}
+
+	for _, origin := range strings.Split(allowedOrigins, ",") {
+		if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+			app.AllowedOrigins = append(app.AllowedOrigins, origin)
+		}
+	}
+
+	if oidcProvider.Issuer != "" {
+		app.OIDC = &oidcProvider
+	}
+
+	switch mailerKind {
+	case "smtp":
+		smtpMailer.From = mailFrom
+		app.Mailer = &smtpMailer
+	case "file":
+		app.Mailer = &mailer.FileMailer{Dir: mailDir, From: mailFrom}
+	case "memory":
+		app.Mailer = &mailer.MemoryMailer{}
+	default:
+		log.Fatalf("unknown mailer %q", mailerKind)
+	}
+
 	// connect to DB
 	conn, err := app.connectToDB()
 	if err != nil {
@@ -49,19 +223,36 @@ func main() {
 	}
 	// NOTES: this is a handy way to assign a struct to a var (or in this case another struct property)
 	//	while updating its value at the same time
-	app.DB = &dbrepo.PostgresDBRepo{DB: conn}
+	repo := &dbrepo.PostgresDBRepo{DB: conn, Hasher: app.Hasher}
+	app.DB = repo
+	app.Tokens = repo
+
+	switch attemptsStore {
+	case "postgres":
+		app.Attempts = repo
+	case "memory":
+		app.Attempts = &memrepo.LoginAttemptRepo{}
+	default:
+		log.Fatalf("unknown login attempts store %q", attemptsStore)
+	}
 	// NOTES: defer means: 'run this defer line only when the function in which defer is (in this case main()) ends'
 	// this is coz we dont wanna close the DB session when we are still using it.
 	defer app.DB.Connection().Close() // This will also work: defer conn.Close()
 
+	keys, err := buildKeyRing(app.JWTAlg, app.JWTSecret, app.JWTKeyFile, app.JWTVerifyKeys)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// populated the app.auth key with the right JWT auth stuff
 	app.auth = Auth{
 		Issuer:   app.JWTIssuer,
 		Audience: app.JWTAudience,
-		Secret:   app.JWTSecret,
+		Keys:     keys,
 		// we want the token to initially expire every 15 minutes, so the user will be logged out,
 		// unless we refresh the token-which we will.
 		TokenExpiry:   time.Minute * 15,
+		Leeway:        time.Second * 30,
 		RefreshExpiry: time.Hour * 24,
 		CookiePath:    "/",
 		/////CookieName:    "__Host-refresh_token",
@@ -69,12 +260,43 @@ func main() {
 		CookieDomain: app.CookieDomain,
 	}
 
+	// stopping on a signal lets the last playback positions be written before we exit
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	app.Progress = &playback.Buffer{Save: app.DB.SaveWatchProgress}
+	flushed := make(chan struct{})
+	go func() {
+		app.Progress.Run(ctx, app.ProgressFlushInterval)
+		close(flushed)
+	}()
+	go app.pruneLoginAttempts(ctx, time.Hour)
+
 	log.Println("Running application on port", port)
 
 	// start a web server
-	err = http.ListenAndServe(fmt.Sprintf(":%d", port), app.routes())
-	if err != nil {
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: app.routes(),
+	}
+	stopped := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+		close(stopped)
+	}()
+
+	err = srv.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatal(err)
 	}
 
+	// requests that were still running when we were told to stop may have recorded positions since
+	<-stopped
+	<-flushed
+	if err := app.Progress.Flush(); err != nil {
+		log.Println("saving playback positions:", err)
+	}
 }
@@ -1,6 +1,18 @@
 package main
 
-import "net/http"
+import (
+	"backend/internal/models"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// contextKey is used for values we put on the request context, so they can't collide with keys set by other packages
+type contextKey string
+
+const claimsContextKey contextKey = "claims"
 
 func (app *application) enableCORS(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -15,9 +27,13 @@ func (app *application) enableCORS(h http.Handler) http.Handler {
 		//		do this: w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
 
 		// We will make it secure (with SSL) when we get to production
-		// Set CORS headers for all requests
-		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		// Set CORS headers for requests from the origins in -allowed-origins. Other origins get no
+		// CORS headers, so browsers won't let them read our responses
+		w.Header().Add("Vary", "Origin")
+		if origin := r.Header.Get("Origin"); app.allowedOrigin(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
 
 		if r.Method == "OPTIONS" {
 			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
@@ -33,11 +49,123 @@ func (app *application) enableCORS(h http.Handler) http.Handler {
 
 func (app *application) authRequired(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, _, err := app.auth.GetTokenFromHeaderAndVerify(w, r)
+		// scripts & services authenticate with "Authorization: ApiKey <key>" instead of a JWT
+		if strings.HasPrefix(r.Header.Get("Authorization"), apiKeyScheme+" ") {
+			claims, err := app.verifyAPIKey(r)
+			if err != nil {
+				app.errorJSON(w, err, http.StatusUnauthorized)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		_, claims, err := app.auth.GetTokenFromHeaderAndVerify(w, r)
 		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
+			w.Header().Set("WWW-Authenticate", app.auth.WWWAuthenticate(err))
+			app.errorJSON(w, err, http.StatusUnauthorized)
+			return
+		}
+
+		// keep the verified claims on the request so handlers can tell who is making the request
+		ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authOptional authenticates requests that carry credentials, like authRequired, and lets anonymous
+// ones through, for routes that show logged in users a little more
+func (app *application) authOptional(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
 			return
 		}
+
+		app.authRequired(next).ServeHTTP(w, r)
+	})
+}
+
+// requireRole only lets the request through if the authenticated user has one of the given roles.
+// It must be used after authRequired, which puts the claims on the request
+func (app *application) requireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := claimsFromContext(r)
+			if claims == nil {
+				app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+				return
+			}
+
+			if !claims.HasRole(roles...) {
+				app.errorJSON(w, errors.New("forbidden: you do not have permission to do this"), http.StatusForbidden)
+				return
+			}
+
+			if app.adminMFAMissing(claims) {
+				app.errorJSON(w, errors.New("forbidden: admins must log in with two-factor authentication"), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireScope only lets the request through if it is allowed the given scope, either because the API
+// key it was made with has the scope, or because the user's role implies it. Use it after authRequired
+func (app *application) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := claimsFromContext(r)
+			if claims == nil {
+				app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+				return
+			}
+
+			if !claims.HasScope(scope) {
+				app.errorJSON(w, fmt.Errorf("forbidden: %s permission required", scope), http.StatusForbidden)
+				return
+			}
+
+			if app.adminMFAMissing(claims) {
+				app.errorJSON(w, errors.New("forbidden: admins must log in with two-factor authentication"), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// readOnlyImpersonation stops impersonation tokens changing anything: support can look at what the user
+// sees, but not act for them. Use it after authRequired
+func (app *application) readOnlyImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := claimsFromContext(r)
+		if claims != nil && claims.Impersonated() {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+			default:
+				app.errorJSON(w, errors.New("forbidden: impersonation tokens are read only"), http.StatusForbidden)
+				return
+			}
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
+
+// adminMFAMissing reports whether an admin is about to use their role without having logged in with
+// two-factor authentication, when -admin-require-mfa says they must
+func (app *application) adminMFAMissing(claims *Claims) bool {
+	return app.AdminRequireMFA && claims.APIKeyID == 0 && claims.Role == models.RoleAdmin && !claims.UsedMFA()
+}
+
+// claimsFromContext returns the claims authRequired stored on the request, or nil if there are none
+func claimsFromContext(r *http.Request) *Claims {
+	claims, _ := r.Context().Value(claimsContextKey).(*Claims)
+	return claims
+}
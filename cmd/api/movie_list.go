@@ -0,0 +1,154 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// movieQueryFromURL reads the paging, sorting & filtering parameters of the movie lists, eg.
+// /movies?sort=-release_date&genre=1,4&rating=PG&rating=PG-13&year_from=1990&year_to=1999&runtime_max=120&person=7
+func movieQueryFromURL(r *http.Request) (models.MovieQuery, error) {
+	v := r.URL.Query()
+
+	query := models.MovieQuery{
+		Search: strings.TrimSpace(v.Get("q")),
+		Sort:   v.Get("sort"),
+		Cursor: v.Get("cursor"),
+	}
+
+	if query.Sort != "" && !models.ValidMovieSort(query.Sort) {
+		return query, errors.New("sort must be one of title, release_date, runtime or relevance, with a leading - for the reverse order")
+	}
+	if strings.TrimPrefix(query.Sort, "-") == models.MovieSortRelevance && query.Search == "" {
+		return query, errors.New("sorting by relevance needs a search, eg. ?q=")
+	}
+
+	var err error
+	query.Page, query.PageSize, err = pageParams(r)
+	if err != nil {
+		return query, err
+	}
+
+	// genre, person & rating can be repeated, or hold a comma separated list
+	for _, genre := range listParam(v, "genre") {
+		id, err := strconv.Atoi(genre)
+		if err != nil {
+			return query, errors.New("genre must be a genre id")
+		}
+		query.Genres = append(query.Genres, id)
+	}
+	for _, person := range listParam(v, "person") {
+		id, err := strconv.Atoi(person)
+		if err != nil {
+			return query, errors.New("person must be a person id")
+		}
+		query.People = append(query.People, id)
+	}
+	query.MPAARatings = listParam(v, "rating")
+
+	for name, dst := range map[string]*int{
+		"year_from":   &query.YearFrom,
+		"year_to":     &query.YearTo,
+		"runtime_min": &query.RuntimeMin,
+		"runtime_max": &query.RuntimeMax,
+	} {
+		if s := v.Get(name); s != "" {
+			*dst, err = strconv.Atoi(s)
+			if err != nil || *dst < 1 {
+				return query, fmt.Errorf("%s must be a positive number", name)
+			}
+		}
+	}
+
+	if query.YearFrom > 0 && query.YearTo > 0 && query.YearFrom > query.YearTo {
+		return query, errors.New("year_from can't be after year_to")
+	}
+	if query.RuntimeMin > 0 && query.RuntimeMax > 0 && query.RuntimeMin > query.RuntimeMax {
+		return query, errors.New("runtime_min can't be more than runtime_max")
+	}
+
+	return query, nil
+}
+
+// listParam collects every value of a repeatable parameter, splitting comma separated lists
+func listParam(v url.Values, name string) []string {
+	var list []string
+	for _, value := range v[name] {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+// listMovies writes one page of movies, with links to the pages either side. Links use page numbers,
+// unless the request was made with a cursor, in which case they carry on with cursors
+func (app *application) listMovies(w http.ResponseWriter, r *http.Request, query models.MovieQuery) {
+	page, err := app.DB.ListMovies(r.Context(), query)
+	if err != nil {
+		if errors.Is(err, repository.ErrInvalidCursor) {
+			app.errorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if page.Movies == nil {
+		page.Movies = []*models.Movie{}
+	}
+
+	var payload = struct {
+		Movies     []*models.Movie `json:"movies"`
+		Total      int             `json:"total"`
+		Page       int             `json:"page,omitempty"`
+		PageSize   int             `json:"page_size"`
+		Next       string          `json:"next,omitempty"`
+		Prev       string          `json:"prev,omitempty"`
+		NextCursor string          `json:"next_cursor,omitempty"`
+		PrevCursor string          `json:"prev_cursor,omitempty"`
+	}{
+		Movies:     page.Movies,
+		Total:      page.Total,
+		PageSize:   query.PageSize,
+		NextCursor: page.NextCursor,
+		PrevCursor: page.PrevCursor,
+	}
+
+	if query.Cursor == "" {
+		payload.Page = query.Page
+		if query.Page*query.PageSize < page.Total {
+			payload.Next = pageLink(r, "page", strconv.Itoa(query.Page+1))
+		}
+		if query.Page > 1 {
+			payload.Prev = pageLink(r, "page", strconv.Itoa(query.Page-1))
+		}
+	} else {
+		if page.NextCursor != "" {
+			payload.Next = pageLink(r, "cursor", page.NextCursor)
+		}
+		if page.PrevCursor != "" {
+			payload.Prev = pageLink(r, "cursor", page.PrevCursor)
+		}
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// pageLink is the request's own path & query, with one paging parameter set & the other dropped
+func pageLink(r *http.Request, name, value string) string {
+	v := r.URL.Query()
+	v.Del("page")
+	v.Del("cursor")
+	v.Set(name, value)
+
+	return r.URL.Path + "?" + v.Encode()
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"backend/internal/models"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -13,6 +14,9 @@ func (app *application) routes() http.Handler {
 
 	// here is where you define your routes & any middleware
 
+	// every request gets an id (or keeps the X-Request-Id it came with), so the audit log can point
+	// back at the request that made a change
+	mux.Use(middleware.RequestID)
 	// the Recoverer logs issues with a debug trace, if the server runs into errors,
 	// then return the appropriate 500 error & keeps the server running so your app
 	// doesn't grind to a halt
@@ -22,12 +26,37 @@ func (app *application) routes() http.Handler {
 	// NOTES: we say app.Home coz Home() is a receiver func of the application struct, witten in 'cmd/api/handlers.go'
 	mux.Get("/", app.Home)
 
+	// public keys other services can verify our access tokens with
+	mux.Get("/.well-known/jwks.json", app.jwks)
+
+	mux.Post("/register", app.register)
 	mux.Post("/authenticate", app.authenticate)
-	mux.Get("/refresh", app.refreshToken)
-	mux.Get("/logout", app.logout)
+	mux.Post("/authenticate/mfa", app.authenticateMFA)
+	mux.Post("/password/forgot", app.forgotPassword)
+	mux.Post("/password/reset", app.resetPassword)
+	mux.Get("/verify-email", app.verifyEmail)
+
+	// single sign-on with the OpenID Connect provider
+	mux.Get("/auth/oidc/login", app.oidcLogin)
+	mux.Get("/auth/oidc/callback", app.oidcCallback)
+
+	// the routes authenticated by the refresh cookie need a csrf token, which the frontend gets here
+	mux.Get("/csrf-token", app.csrfToken)
+	mux.With(app.csrfProtect).Post("/refresh", app.refreshToken)
+	mux.With(app.csrfProtect).Post("/logout", app.logout)
 
 	mux.Get("/movies", app.AllMovies)
+	mux.Get("/movies/search", app.SearchMovies)
+	mux.Get("/movies/suggest", app.SuggestMovies)
 	mux.Get("/movies/{id}", app.GetMovie)
+	mux.With(app.authOptional).Get("/movies/{id}/reviews", app.MovieReviews)
+	mux.Get("/movies/{id}/similar", app.SimilarMovies)
+
+	mux.Get("/people/{id}", app.GetPerson)
+
+	// lists users have shared. Private lists can only be seen by their owner
+	mux.Get("/lists", app.PublicLists)
+	mux.With(app.authOptional).Get("/lists/{slug}", app.GetSharedList)
 
 	mux.Get("/genres", app.AllGenres)
 	mux.Get("/movies/genres/{id}", app.AllMoviesByGenre)
@@ -35,15 +64,132 @@ func (app *application) routes() http.Handler {
 	// Note that we will have only one route for GraphQL queries
 	mux.Post("/graph", app.MoviesGraphQL)
 
+	// account management for the logged in user
+	mux.Route("/me", func(mux chi.Router) {
+		mux.Use(app.authRequired)
+		mux.Use(app.readOnlyImpersonation)
+
+		mux.Get("/", app.GetMe)
+		mux.Patch("/", app.UpdateMe)
+		mux.Delete("/", app.DeleteMe)
+		mux.Put("/password", app.ChangePassword)
+		mux.Post("/verify-email", app.ResendVerification)
+
+		mux.Get("/sessions", app.MySessions)
+		mux.Delete("/sessions", app.RevokeMySessions)
+		mux.Delete("/sessions/{id}", app.RevokeMySession)
+
+		mux.Post("/mfa/totp", app.EnrollTOTP)
+		mux.Post("/mfa/totp/confirm", app.ConfirmTOTP)
+		mux.Delete("/mfa/totp", app.DisableTOTP)
+		mux.Post("/mfa/recovery-codes", app.RegenerateRecoveryCodes)
+
+		// the watchlist is a list like any other, but without an {id}: everyone has exactly one
+		mux.Get("/watchlist", app.GetMyList)
+		mux.Put("/watchlist/order", app.ReorderList)
+		mux.Put("/watchlist/{movieID}", app.AddListMovie)
+		mux.Delete("/watchlist/{movieID}", app.RemoveListMovie)
+
+		mux.Get("/lists", app.MyLists)
+		mux.Post("/lists", app.InsertList)
+		mux.Get("/lists/{id}", app.GetMyList)
+		mux.Patch("/lists/{id}", app.UpdateList)
+		mux.Delete("/lists/{id}", app.DeleteList)
+		mux.Put("/lists/{id}/order", app.ReorderList)
+		mux.Put("/lists/{id}/movies/{movieID}", app.AddListMovie)
+		mux.Delete("/lists/{id}/movies/{movieID}", app.RemoveListMovie)
+
+		// what the user has watched, and where they got to in what they haven't finished
+		mux.Get("/history", app.WatchHistory)
+		mux.Post("/history", app.MarkWatched)
+		mux.Get("/history/export", app.ExportWatchHistory)
+		mux.Delete("/history/{id}", app.DeleteWatchEvent)
+		mux.Get("/continue-watching", app.ContinueWatching)
+		mux.Get("/progress/{id}", app.GetProgress)
+		mux.Put("/progress/{id}", app.SaveProgress)
+	})
+
+	// rating & reviewing movies, and voting for helpful reviews, for logged in users
+	mux.Group(func(mux chi.Router) {
+		mux.Use(app.authRequired)
+		mux.Use(app.readOnlyImpersonation)
+
+		mux.Put("/movies/{id}/review", app.SaveReview)
+		mux.Delete("/movies/{id}/review", app.DeleteReview)
+		mux.Put("/reviews/{id}/helpful", app.VoteReviewHelpful)
+		mux.Delete("/reviews/{id}/helpful", app.UnvoteReviewHelpful)
+		mux.Post("/reviews/{id}/report", app.ReportReview)
+	})
+
 	// restrict the app.authRequired token access validation to "/admin" routes
 	mux.Route("/admin", func(mux chi.Router) {
 		mux.Use(app.authRequired)
+		mux.Use(app.readOnlyImpersonation)
+
+		// the catalogue is guarded by scopes, so API keys can use it as well as editors & admins.
+		// Editors get read & write, only admins can delete (see models.RoleScopes)
+		mux.With(app.requireScope(models.ScopeMoviesRead)).Get("/movies", app.MovieCatalog)
+		mux.With(app.requireScope(models.ScopeMoviesRead)).Get("/movies/{id}", app.MovieForEdit)
+		mux.With(app.requireScope(models.ScopeMoviesWrite)).Put("/movies/0", app.InsertMovie)
+		mux.With(app.requireScope(models.ScopeMoviesWrite)).Patch("/movies/{id}", app.UpdateMovie)
+		mux.With(app.requireScope(models.ScopeMoviesDelete)).Delete("/movies/{id}", app.DeleteMovie)
+
+		// cast & crew are part of the catalogue, so they share its scopes
+		mux.With(app.requireScope(models.ScopeMoviesRead)).Get("/people", app.AllPeople)
+		mux.With(app.requireScope(models.ScopeMoviesWrite)).Post("/people", app.InsertPerson)
+		mux.With(app.requireScope(models.ScopeMoviesRead)).Get("/people/{id}", app.GetPerson)
+		mux.With(app.requireScope(models.ScopeMoviesWrite)).Patch("/people/{id}", app.UpdatePerson)
+		mux.With(app.requireScope(models.ScopeMoviesDelete)).Delete("/people/{id}", app.DeletePerson)
+		mux.With(app.requireScope(models.ScopeMoviesWrite)).Post("/people/{id}/credits", app.InsertCredit)
+		mux.With(app.requireScope(models.ScopeMoviesWrite)).Delete("/people/{id}/credits/{creditID}", app.DeleteCredit)
+
+		// failed login counters & lockouts
+		mux.Group(func(mux chi.Router) {
+			mux.Use(app.requireRole(models.RoleAdmin))
+
+			mux.Get("/lockouts", app.AllLockouts)
+			mux.Delete("/lockouts", app.ClearLockout)
+		})
+
+		// API keys for scripts & services
+		mux.Group(func(mux chi.Router) {
+			mux.Use(app.requireRole(models.RoleAdmin))
+
+			mux.Get("/api-keys", app.AllAPIKeys)
+			mux.Post("/api-keys", app.InsertAPIKey)
+			mux.Delete("/api-keys/{id}", app.RevokeAPIKey)
+		})
+
+		// user management
+		mux.Group(func(mux chi.Router) {
+			mux.Use(app.requireRole(models.RoleAdmin))
+
+			mux.Get("/users", app.AllUsers)
+			mux.Post("/users", app.InsertUser)
+			mux.Get("/users/{id}", app.GetUser)
+			mux.Patch("/users/{id}", app.UpdateUser)
+			mux.Delete("/users/{id}", app.DeleteUser)
+			mux.Post("/users/{id}/disable", app.DisableUser)
+			mux.Post("/users/{id}/enable", app.EnableUser)
+			mux.Post("/users/{id}/password-reset", app.ForcePasswordReset)
+			mux.Get("/users/{id}/sessions", app.UserSessions)
+			mux.Delete("/users/{id}/sessions", app.RevokeUserSessions)
+			mux.Post("/users/{id}/impersonate", app.ImpersonateUser)
+		})
+
+		// review moderation
+		mux.Group(func(mux chi.Router) {
+			mux.Use(app.requireRole(models.RoleAdmin))
+
+			mux.Get("/moderation", app.ModerationQueue)
+			mux.Post("/moderation", app.ModerateReviews)
+			mux.Get("/moderation/reviews/{id}", app.ModerationHistory)
+			mux.Post("/users/{id}/shadow-hide", app.ShadowHideUser)
+			mux.Delete("/users/{id}/shadow-hide", app.ShadowUnhideUser)
+		})
 
-		mux.Get("/movies", app.MovieCatalog)
-		mux.Get("/movies/{id}", app.MovieForEdit)
-		mux.Put("/movies/0", app.InsertMovie)
-		mux.Patch("/movies/{id}", app.UpdateMovie)
-		mux.Delete("/movies/{id}", app.DeleteMovie)
+		// who changed what, and when
+		mux.With(app.requireRole(models.RoleAdmin)).Get("/audit", app.AllAuditEvents)
 	})
 
 	return mux
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/internal/repository/memrepo"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+// newTestAuth signs with a throw away EdDSA key
+func newTestAuth(t *testing.T) Auth {
+	t.Helper()
+
+	key, err := generateKey("EdDSA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return Auth{
+		Issuer:        "example.com",
+		Audience:      "example.com",
+		Keys:          NewKeyRing(key),
+		TokenExpiry:   time.Minute * 15,
+		Leeway:        time.Second * 30,
+		RefreshExpiry: time.Hour * 24,
+		CookiePath:    "/",
+		CookieName:    "refresh_token",
+	}
+}
+
+// newTestApp has everything that doesn't need a database: auth & in-memory token & login attempt stores
+func newTestApp(t *testing.T) *application {
+	t.Helper()
+
+	return &application{
+		Domain:         "example.com",
+		auth:           newTestAuth(t),
+		Tokens:         &memrepo.TokenRepo{},
+		Attempts:       &memrepo.LoginAttemptRepo{},
+		AllowedOrigins: []string{"http://localhost:3000"},
+	}
+}
+
+// stubDB is a DatabaseRepo that only knows its users, the identities linked to them, their API keys &
+// the one-time tokens emailed to them.
+// Anything else it is asked panics, so a test touching more of the database than it means to fails loudly
+type stubDB struct {
+	repository.DatabaseRepo
+	users map[int]*models.User
+	// identities maps an OIDC issuer & subject, separated by a space, to a user id
+	identities map[string]int
+	apiKeys    []*models.APIKey
+	userTokens []*models.UserToken
+}
+
+func (db *stubDB) GetUserById(id int) (*models.User, error) {
+	user, ok := db.users[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	u := *user
+	return &u, nil
+}
+
+func (db *stubDB) GetUserByEmail(email string) (*models.User, error) {
+	for _, user := range db.users {
+		if user.Email == email {
+			return db.GetUserById(user.ID)
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// UpdateUser clears the verification when the email changes, like the real one
+func (db *stubDB) UpdateUser(user models.User) error {
+	stored := db.users[user.ID]
+	if stored.Email != user.Email {
+		stored.EmailVerifiedAt = nil
+	}
+	stored.FirstName = user.FirstName
+	stored.LastName = user.LastName
+	stored.Email = user.Email
+	return nil
+}
+
+func (db *stubDB) UpdatePassword(id int, password string) error {
+	db.users[id].Password = password
+	return nil
+}
+
+func (db *stubDB) SetEmailVerified(id int) error {
+	now := time.Now()
+	db.users[id].EmailVerifiedAt = &now
+	return nil
+}
+
+func (db *stubDB) GetUserByIdentity(issuer, subject string) (*models.User, error) {
+	id, ok := db.identities[issuer+" "+subject]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return db.GetUserById(id)
+}
+
+func (db *stubDB) LinkIdentity(userID int, issuer, subject string) error {
+	if db.identities == nil {
+		db.identities = map[string]int{}
+	}
+	db.identities[issuer+" "+subject] = userID
+	return nil
+}
+
+func (db *stubDB) InsertUserToken(token models.UserToken) error {
+	db.userTokens = append(db.userTokens, &token)
+	return nil
+}
+
+func (db *stubDB) ConsumeUserToken(hash, purpose string) (*models.UserToken, error) {
+	for _, token := range db.userTokens {
+		if token.TokenHash == hash && token.Purpose == purpose && token.UsedAt == nil && time.Now().Before(token.ExpiresAt) {
+			now := time.Now()
+			token.UsedAt = &now
+			return token, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (db *stubDB) DeleteUserTokens(userID int, purpose string) error {
+	kept := db.userTokens[:0]
+	for _, token := range db.userTokens {
+		if token.UserID != userID || token.Purpose != purpose {
+			kept = append(kept, token)
+		}
+	}
+	db.userTokens = kept
+	return nil
+}
+
+func (db *stubDB) AllAPIKeys() ([]*models.APIKey, error) {
+	return db.apiKeys, nil
+}
+
+// DeleteUserReassign hands the user's API keys over, then forgets the user
+func (db *stubDB) DeleteUserReassign(id, reassignTo int) error {
+	for _, key := range db.apiKeys {
+		if key.CreatedBy == id {
+			key.CreatedBy = reassignTo
+		}
+	}
+	delete(db.users, id)
+	return nil
+}
+
+func (db *stubDB) InsertAuditEvent(event models.AuditEvent) error {
+	return nil
+}
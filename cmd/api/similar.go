@@ -0,0 +1,160 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/recommend"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSimilar = 10
+	maxSimilar     = 50
+)
+
+// similarIndex holds the similar movies index, and the answers it has given so far by movie id. Both
+// are thrown away together when the index is rebuilt
+type similarIndex struct {
+	mu       sync.Mutex
+	index    *recommend.Index
+	results  map[int][]*models.SimilarMovie
+	builtAt  time.Time
+	building bool
+}
+
+// SimilarMovies recommends movies like this one, most alike first, eg. /movies/1/similar?limit=5. See
+// the recommend package for how they are scored, and -similar-*-weight for how much each part counts
+func (app *application) SimilarMovies(w http.ResponseWriter, r *http.Request) {
+	movie, err := app.movieFromURL(r)
+	if err != nil {
+		app.movieErrorJSON(w, err)
+		return
+	}
+
+	limit := defaultSimilar
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSimilar {
+			app.errorJSON(w, errors.New("limit must be between 1 and 50"))
+			return
+		}
+		limit = n
+	}
+
+	similar, err := app.similarMovies(r.Context(), movie.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if len(similar) > limit {
+		similar = similar[:limit]
+	}
+	if similar == nil {
+		similar = []*models.SimilarMovie{}
+	}
+
+	var payload = struct {
+		MovieID int                    `json:"movie_id"`
+		Similar []*models.SimilarMovie `json:"similar"`
+	}{
+		MovieID: movie.ID,
+		Similar: similar,
+	}
+
+	headers := http.Header{}
+	headers.Set("Cache-Control", "public, max-age=60")
+
+	_ = app.writeJSON(w, http.StatusOK, payload, headers)
+}
+
+// similarMovies returns up to maxSimilar movies like movieID, from the cache if it has been asked before.
+// Movies added since the index was built have nothing similar until it is rebuilt
+func (app *application) similarMovies(ctx context.Context, movieID int) ([]*models.SimilarMovie, error) {
+	s := &app.similar
+
+	s.mu.Lock()
+	similar, ok := s.results[movieID]
+	s.mu.Unlock()
+	if ok {
+		return similar, nil
+	}
+
+	index, err := app.similarIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	similar = index.Similar(movieID, maxSimilar)
+
+	s.mu.Lock()
+	// the index may have been rebuilt while we were scoring, in which case this answer is already stale
+	if s.index == index {
+		s.results[movieID] = similar
+	}
+	s.mu.Unlock()
+
+	return similar, nil
+}
+
+// similarIndex returns the similar movies index, building it on first use. Once it is older than
+// -similar-cache-ttl it is rebuilt in the background, and the old one keeps answering in the meantime
+func (app *application) similarIndex(ctx context.Context) (*recommend.Index, error) {
+	s := &app.similar
+
+	s.mu.Lock()
+	index := s.index
+	if index != nil && time.Since(s.builtAt) > app.SimilarCacheTTL && !s.building {
+		s.building = true
+		go app.rebuildSimilarIndex()
+	}
+	s.mu.Unlock()
+
+	if index != nil {
+		return index, nil
+	}
+
+	index, err := app.buildSimilarIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// another request may have built one first
+	if s.index == nil {
+		s.index, s.results, s.builtAt = index, map[int][]*models.SimilarMovie{}, time.Now()
+	}
+
+	return s.index, nil
+}
+
+func (app *application) rebuildSimilarIndex() {
+	index, err := app.buildSimilarIndex(context.Background())
+
+	s := &app.similar
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.building = false
+	if err != nil {
+		log.Println("similar: rebuilding the index:", err)
+		return
+	}
+	s.index, s.results, s.builtAt = index, map[int][]*models.SimilarMovie{}, time.Now()
+}
+
+func (app *application) buildSimilarIndex(ctx context.Context) (*recommend.Index, error) {
+	features, err := app.DB.MovieFeatures(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return recommend.New(features, app.SimilarWeights), nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/internal/suggest"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultSuggestions = 10
+	maxSuggestions     = 20
+	// minSuggestPrefix is how much has to be typed before we suggest anything
+	minSuggestPrefix = 2
+	// titleIndexTTL is how stale the in-memory title index may get before it is rebuilt
+	titleIndexTTL = time.Minute
+)
+
+// titleIndex holds the in-memory autocomplete index, for repositories that can't suggest titles themselves
+type titleIndex struct {
+	mu       sync.Mutex
+	index    *suggest.Index
+	builtAt  time.Time
+	building bool
+}
+
+// SuggestMovies offers movie titles while the user types, eg. /movies/suggest?prefix=intersteller&limit=5.
+// It is called on every keystroke, so it does as little as it can & lets browsers cache the answers
+func (app *application) SuggestMovies(w http.ResponseWriter, r *http.Request) {
+	prefix := strings.TrimSpace(r.URL.Query().Get("prefix"))
+
+	limit := defaultSuggestions
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSuggestions {
+			app.errorJSON(w, errors.New("limit must be between 1 and 20"))
+			return
+		}
+		limit = n
+	}
+
+	suggestions := []*models.Suggestion{}
+
+	if utf8.RuneCountInString(prefix) >= minSuggestPrefix {
+		found, err := app.suggestTitles(r.Context(), prefix, limit)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		if found != nil {
+			suggestions = found
+		}
+	}
+
+	headers := http.Header{}
+	headers.Set("Cache-Control", "public, max-age=60")
+
+	_ = app.writeJSON(w, http.StatusOK, suggestions, headers)
+}
+
+// suggestTitles asks the repository for suggestions if it can give them, and the in-memory index if not
+func (app *application) suggestTitles(ctx context.Context, prefix string, limit int) ([]*models.Suggestion, error) {
+	if suggester, ok := app.DB.(repository.TitleSuggester); ok {
+		return suggester.SuggestTitles(ctx, prefix, limit)
+	}
+
+	index, err := app.titleIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return index.Suggest(prefix, limit), nil
+}
+
+// titleIndex returns the in-memory title index, building it on first use. Once it is older than
+// titleIndexTTL it is rebuilt in the background, and the old one keeps answering in the meantime
+func (app *application) titleIndex(ctx context.Context) (*suggest.Index, error) {
+	t := &app.titles
+
+	t.mu.Lock()
+	index := t.index
+	if index != nil && time.Since(t.builtAt) > titleIndexTTL && !t.building {
+		t.building = true
+		go app.rebuildTitleIndex()
+	}
+	t.mu.Unlock()
+
+	if index != nil {
+		return index, nil
+	}
+
+	index, err := app.buildTitleIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	t.index, t.builtAt = index, time.Now()
+	t.mu.Unlock()
+
+	return index, nil
+}
+
+func (app *application) rebuildTitleIndex() {
+	index, err := app.buildTitleIndex(context.Background())
+
+	t := &app.titles
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.building = false
+	if err != nil {
+		log.Println("suggest: rebuilding the title index:", err)
+		return
+	}
+	t.index, t.builtAt = index, time.Now()
+}
+
+// buildTitleIndex reads every movie, a page at a time, into a new index
+func (app *application) buildTitleIndex(ctx context.Context) (*suggest.Index, error) {
+	var movies []*models.Movie
+
+	query := models.MovieQuery{PageSize: maxPageSize}
+	for {
+		page, err := app.DB.ListMovies(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, page.Movies...)
+
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+
+	return suggest.New(movies), nil
+}
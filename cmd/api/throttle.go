@@ -0,0 +1,228 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/password"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// throttlePolicy decides how long logins for a key are locked after a number of failures. Up to
+// SoftLimit failures nothing happens. After that each failure locks the key for BaseDelay, doubling
+// every time (1s, 2s, 4s...), until HardLimit failures lock it out for Lockout. Failures older than
+// Window are forgotten
+type throttlePolicy struct {
+	SoftLimit int
+	HardLimit int
+	BaseDelay time.Duration
+	Lockout   time.Duration
+	Window    time.Duration
+}
+
+// lockFor returns how long to lock a key that has failed the given number of times
+func (p throttlePolicy) lockFor(failures int) time.Duration {
+	switch {
+	case failures >= p.HardLimit:
+		return p.Lockout
+	case failures >= p.SoftLimit:
+		delay := p.BaseDelay * time.Duration(math.Pow(2, float64(failures-p.SoftLimit)))
+		if delay > p.Lockout {
+			delay = p.Lockout
+		}
+		return delay
+	}
+	return 0
+}
+
+var (
+	// accountThrottle protects a single account from password guessing
+	accountThrottle = throttlePolicy{SoftLimit: 3, HardLimit: 10, BaseDelay: time.Second, Lockout: time.Minute * 15, Window: time.Hour * 24}
+	// ipThrottle stops one client guessing across many accounts. Its limits are higher, as many
+	// legitimate users can share an address behind a NAT
+	ipThrottle = throttlePolicy{SoftLimit: 20, HardLimit: 100, BaseDelay: time.Second, Lockout: time.Minute * 15, Window: time.Hour}
+)
+
+// loginAttemptsTTL is how long failures can count for, the longest window of the policies
+var loginAttemptsTTL = max(accountThrottle.Window, ipThrottle.Window)
+
+// errLoginLocked is returned by checkLoginAllowed, and carries how long the client should wait
+type errLoginLocked struct {
+	RetryAfter time.Duration
+}
+
+func (e *errLoginLocked) Error() string {
+	return fmt.Sprintf("too many failed login attempts, try again in %d seconds", int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
+func accountThrottleKey(email string) string {
+	return "account:" + normalizeEmail(email)
+}
+
+func ipThrottleKey(r *http.Request) string {
+	return "ip:" + clientIP(r)
+}
+
+// checkLoginAllowed returns an *errLoginLocked if either the account or the client is locked out
+func (app *application) checkLoginAllowed(r *http.Request, email string) error {
+	now := time.Now()
+
+	for _, key := range []string{accountThrottleKey(email), ipThrottleKey(r)} {
+		attempts, err := app.Attempts.GetLoginAttempts(key)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+			return err
+		}
+
+		if attempts.Locked(now) {
+			return &errLoginLocked{RetryAfter: attempts.LockedUntil.Sub(now)}
+		}
+	}
+
+	return nil
+}
+
+// recordLoginFailure counts a failed login against both the account & the client, locking either
+// one out if they have failed too often. Errors are only logged, they mustn't change the response
+func (app *application) recordLoginFailure(r *http.Request, email string) {
+	keys := map[string]throttlePolicy{
+		accountThrottleKey(email): accountThrottle,
+		ipThrottleKey(r):          ipThrottle,
+	}
+
+	for key, policy := range keys {
+		failures, err := app.Attempts.RecordLoginFailure(key, policy.Window)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		if lock := policy.lockFor(failures); lock > 0 {
+			err = app.Attempts.LockLogin(key, time.Now().Add(lock))
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+// recordLoginSuccess resets the account's counter. The client's counter is left alone, otherwise an
+// attacker could reset it by logging into an account of their own between guesses
+func (app *application) recordLoginSuccess(email string) {
+	err := app.Attempts.ClearLoginAttempts(accountThrottleKey(email))
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// pruneLoginAttempts forgets the keys whose failures no longer count, every interval until ctx is done.
+// Failures for emails without an account are recorded too, so otherwise the store would grow without bound
+func (app *application) pruneLoginAttempts(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := app.Attempts.PruneLoginAttempts(time.Now().Add(-loginAttemptsTTL)); err != nil {
+				log.Println("pruning login attempts:", err)
+			}
+		}
+	}
+}
+
+// throttledJSON sends the 429 response for a locked out login, or a 500 if checking the lock failed
+func (app *application) throttledJSON(w http.ResponseWriter, err error) {
+	var locked *errLoginLocked
+	if errors.As(err, &locked) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+		app.errorJSON(w, err, http.StatusTooManyRequests)
+		return
+	}
+
+	app.errorJSON(w, err, http.StatusInternalServerError)
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// burnPasswordCheck does the same hashing work as checking a real password. We call it when the email
+// isn't found, so an unknown email takes as long to refuse as a wrong password & response times don't
+// give away which emails have accounts
+func (app *application) burnPasswordCheck(plainText string) {
+	dummyHashOnce.Do(func() {
+		var err error
+		dummyHash, err = app.Hasher.Hash("not a real password")
+		if err != nil {
+			log.Println(err)
+		}
+	})
+
+	_, _ = password.Verify(plainText, dummyHash)
+}
+
+// clientIP is the address the request came from. We deliberately ignore X-Forwarded-For & friends,
+// anyone can set those. Put the API behind a proxy that rewrites RemoteAddr if you need the real address
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// AllLockouts lists every account & client with recent failed logins, and whether they are locked out
+func (app *application) AllLockouts(w http.ResponseWriter, r *http.Request) {
+	attempts, err := app.Attempts.AllLoginAttempts()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if attempts == nil {
+		attempts = []*models.LoginAttempts{}
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, attempts)
+}
+
+// ClearLockout resets the failed login counter (and any lock) for a key, eg. ?key=account:someone@example.com
+func (app *application) ClearLockout(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		app.errorJSON(w, errors.New("key is required"), http.StatusBadRequest)
+		return
+	}
+
+	// no recent failures leaves attempts nil, which is fine: there's nothing to clear
+	attempts, _ := app.Attempts.GetLoginAttempts(key)
+
+	err := app.Attempts.ClearLoginAttempts(key)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, AuditLockoutClear, "lockout", key, attempts, nil)
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "lockout cleared",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
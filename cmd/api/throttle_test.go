@@ -0,0 +1,173 @@
+package main
+
+import (
+	"backend/internal/repository/memrepo"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLockFor(t *testing.T) {
+	capped := throttlePolicy{SoftLimit: 1, HardLimit: 100, BaseDelay: time.Minute, Lockout: time.Minute * 5}
+
+	tests := []struct {
+		name     string
+		policy   throttlePolicy
+		failures int
+		want     time.Duration
+	}{
+		{"no failures", accountThrottle, 0, 0},
+		{"below the soft limit", accountThrottle, 2, 0},
+		{"soft limit", accountThrottle, 3, time.Second},
+		{"doubles", accountThrottle, 5, time.Second * 4},
+		{"just below the hard limit", accountThrottle, 9, time.Second * 64},
+		{"hard limit", accountThrottle, 10, time.Minute * 15},
+		{"past the hard limit", accountThrottle, 50, time.Minute * 15},
+		{"delay capped at the lockout", capped, 10, time.Minute * 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.lockFor(tt.failures); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// failing is the same email n times
+func failing(email string, n int) []string {
+	emails := make([]string, n)
+	for i := range emails {
+		emails[i] = email
+	}
+	return emails
+}
+
+// strangers are n different emails, none of which has failed before
+func strangers(n int) []string {
+	emails := make([]string, n)
+	for i := range emails {
+		emails[i] = fmt.Sprintf("user%d@example.com", i)
+	}
+	return emails
+}
+
+func TestCheckLoginAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		before  []string
+		success string
+		after   []string
+		check   string
+		// want is the lock we expect, 0 for none
+		want time.Duration
+	}{
+		{"below the soft limit", failing("alice@example.com", 2), "", nil, "alice@example.com", 0},
+		{"account soft limit", failing("alice@example.com", 3), "", nil, "alice@example.com", time.Second},
+		{"account delay doubles", failing("alice@example.com", 5), "", nil, "alice@example.com", time.Second * 4},
+		{"account hard limit", failing("alice@example.com", 10), "", nil, "alice@example.com", time.Minute * 15},
+		{"email is normalized", failing("Alice@Example.com ", 3), "", nil, "alice@example.com", time.Second},
+		{"other accounts aren't locked", failing("alice@example.com", 10), "", nil, "bob@example.com", 0},
+		{"ip soft limit", strangers(20), "", nil, "bob@example.com", time.Second},
+		{"ip hard limit", strangers(100), "", nil, "bob@example.com", time.Minute * 15},
+		{"success resets the account", failing("alice@example.com", 2), "alice@example.com", failing("alice@example.com", 1), "alice@example.com", 0},
+		{"success doesn't reset the ip", strangers(19), "alice@example.com", []string{"mallory@example.com"}, "bob@example.com", time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t)
+			r := httptest.NewRequest("POST", "/authenticate", nil)
+
+			for _, email := range tt.before {
+				app.recordLoginFailure(r, email)
+			}
+			if tt.success != "" {
+				app.recordLoginSuccess(tt.success)
+			}
+			for _, email := range tt.after {
+				app.recordLoginFailure(r, email)
+			}
+
+			err := app.checkLoginAllowed(r, tt.check)
+			if tt.want == 0 {
+				if err != nil {
+					t.Errorf("got %v, want the login allowed", err)
+				}
+				return
+			}
+
+			var locked *errLoginLocked
+			if !errors.As(err, &locked) {
+				t.Fatalf("got %v, want a lock of %s", err, tt.want)
+			}
+			if locked.RetryAfter <= tt.want-time.Second || locked.RetryAfter > tt.want {
+				t.Errorf("locked for %s, want %s", locked.RetryAfter, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginLockExpires(t *testing.T) {
+	saved := accountThrottle
+	t.Cleanup(func() { accountThrottle = saved })
+	accountThrottle.BaseDelay = time.Millisecond * 50
+
+	app := newTestApp(t)
+	r := httptest.NewRequest("POST", "/authenticate", nil)
+
+	for range 3 {
+		app.recordLoginFailure(r, "alice@example.com")
+	}
+	if err := app.checkLoginAllowed(r, "alice@example.com"); err == nil {
+		t.Fatal("not locked after reaching the soft limit")
+	}
+
+	time.Sleep(time.Millisecond * 60)
+	if err := app.checkLoginAllowed(r, "alice@example.com"); err != nil {
+		t.Fatalf("still locked after the delay: %v", err)
+	}
+
+	// the failures are still counted, so the next lock is twice as long
+	app.recordLoginFailure(r, "alice@example.com")
+	var locked *errLoginLocked
+	if err := app.checkLoginAllowed(r, "alice@example.com"); !errors.As(err, &locked) || locked.RetryAfter <= time.Millisecond*50 {
+		t.Errorf("got %v, want a lock of 100ms", err)
+	}
+}
+
+func TestPruneLoginAttempts(t *testing.T) {
+	repo := &memrepo.LoginAttemptRepo{}
+
+	for _, key := range []string{"account:alice@example.com", "account:bob@example.com", "ip:192.0.2.1"} {
+		if _, err := repo.RecordLoginFailure(key, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.LockLogin("account:bob@example.com", time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing has failed before an hour ago
+	if err := repo.PruneLoginAttempts(time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if all, _ := repo.AllLoginAttempts(); len(all) != 3 {
+		t.Fatalf("pruned recent failures, %d keys left", len(all))
+	}
+
+	// everything has, but bob is still locked
+	if err := repo.PruneLoginAttempts(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]bool{"account:alice@example.com": false, "account:bob@example.com": true, "ip:192.0.2.1": false} {
+		_, err := repo.GetLoginAttempts(key)
+		if kept := !errors.Is(err, sql.ErrNoRows); kept != want {
+			t.Errorf("%s kept: %v, want %v", key, kept, want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"backend/internal/models"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+)
+
+var (
+	errInvalidRefreshToken = errors.New("invalid refresh token")
+	errRefreshTokenReuse   = errors.New("refresh token reuse detected")
+)
+
+// issueTokens generates a token pair for the user & records the refresh token server side as part of
+// the given token family. Pass a new family id on login, and the old token's family id when rotating
+func (app *application) issueTokens(u *jwtUser, familyID string) (TokenPairs, error) {
+	u.SessionID = familyID
+
+	tokens, err := app.auth.GenerateTokenPair(u)
+	if err != nil {
+		return TokenPairs{}, err
+	}
+
+	err = app.Tokens.InsertRefreshToken(models.RefreshToken{
+		UserID:    u.ID,
+		TokenHash: hashToken(tokens.RefreshToken),
+		FamilyID:  familyID,
+		ExpiresAt: time.Now().Add(app.auth.RefreshExpiry),
+	})
+	if err != nil {
+		return TokenPairs{}, err
+	}
+
+	return tokens, nil
+}
+
+// useRefreshToken checks a presented refresh token against the store & marks it as used, so it can only
+// ever be exchanged once. If a token that was already used comes back, somebody else has a copy of it,
+// so we revoke the whole family - that logs out both the thief & the real user, who then has to log in again
+func (app *application) useRefreshToken(refreshToken string) (*models.RefreshToken, error) {
+	stored, err := app.Tokens.GetRefreshTokenByHash(hashToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	if stored.RevokedAt != nil || time.Now().After(stored.ExpiresAt) {
+		return nil, errInvalidRefreshToken
+	}
+
+	if stored.UsedAt != nil {
+		return nil, app.revokeReusedFamily(stored)
+	}
+
+	ok, err := app.Tokens.MarkRefreshTokenUsed(stored.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		// another request got to it first
+		return nil, app.revokeReusedFamily(stored)
+	}
+
+	return stored, nil
+}
+
+func (app *application) revokeReusedFamily(stored *models.RefreshToken) error {
+	log.Printf("refresh token reuse detected for user %d, revoking token family %s", stored.UserID, stored.FamilyID)
+
+	err := app.Tokens.RevokeRefreshTokenFamily(stored.FamilyID)
+	if err != nil {
+		return err
+	}
+
+	return errRefreshTokenReuse
+}
+
+// hashToken is what we store in place of a token. Tokens are long & random, so a plain SHA-256 is enough
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomString returns n random bytes, hex encoded
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func issueTestTokens(t *testing.T, app *application, familyID string) TokenPairs {
+	t.Helper()
+
+	tokens, err := app.issueTokens(&jwtUser{ID: 1, Role: "viewer"}, familyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	app := newTestApp(t)
+	first := issueTestTokens(t, app, "family")
+
+	stored, err := app.useRefreshToken(first.RefreshToken)
+	if err != nil {
+		t.Fatalf("using a fresh token: %v", err)
+	}
+	if stored.FamilyID != "family" {
+		t.Errorf("got family %q, want family", stored.FamilyID)
+	}
+
+	second := issueTestTokens(t, app, stored.FamilyID)
+	if _, err := app.useRefreshToken(second.RefreshToken); err != nil {
+		t.Errorf("using the rotated token: %v", err)
+	}
+}
+
+func TestRefreshTokenReplayRevokesFamily(t *testing.T) {
+	app := newTestApp(t)
+	first := issueTestTokens(t, app, "family")
+	other := issueTestTokens(t, app, "other family")
+
+	if _, err := app.useRefreshToken(first.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	second := issueTestTokens(t, app, "family")
+
+	_, err := app.useRefreshToken(first.RefreshToken)
+	if !errors.Is(err, errRefreshTokenReuse) {
+		t.Fatalf("replaying a used token: got %v, want %v", err, errRefreshTokenReuse)
+	}
+
+	// the thief's copy & the real user's newer token are both dead
+	stored, err := app.Tokens.GetRefreshTokenByHash(hashToken(second.RefreshToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.RevokedAt == nil {
+		t.Error("the rest of the family wasn't revoked")
+	}
+	if _, err := app.useRefreshToken(second.RefreshToken); !errors.Is(err, errInvalidRefreshToken) {
+		t.Errorf("using a revoked token: got %v, want %v", err, errInvalidRefreshToken)
+	}
+
+	// other logins aren't touched
+	if _, err := app.useRefreshToken(other.RefreshToken); err != nil {
+		t.Errorf("another family was revoked too: %v", err)
+	}
+}
+
+func TestMarkRefreshTokenUsedRace(t *testing.T) {
+	app := newTestApp(t)
+	tokens := issueTestTokens(t, app, "family")
+
+	stored, err := app.Tokens.GetRefreshTokenByHash(hashToken(tokens.RefreshToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const racers = 2
+	won := make(chan bool, racers)
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+
+	for i := 0; i < racers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			ok, err := app.Tokens.MarkRefreshTokenUsed(stored.ID)
+			if err != nil {
+				t.Error(err)
+			}
+			won <- ok
+		}()
+	}
+
+	close(start)
+	wg.Wait()
+	close(won)
+
+	winners := 0
+	for ok := range won {
+		if ok {
+			winners++
+		}
+	}
+	if winners != 1 {
+		t.Errorf("%d calls marked the token used, want exactly 1", winners)
+	}
+}
@@ -2,23 +2,39 @@ package graph
 
 import (
 	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
 	"errors"
-	"strings"
+	"sync"
 
 	"github.com/graphql-go/graphql"
 )
 
+// unpagedBatchSize is how many movies listMovies asks for at a time when returning every match. It is
+// the most the repository returns in one page
+const unpagedBatchSize = 100
+
 // Graph is the type for our Graphql operations
 type Graph struct {
-	Movies      []*models.Movie
+	DB          repository.DatabaseRepo
 	QueryString string
 	Config      graphql.ScalarConfig
 	fields      graphql.Fields
 	movieType   *graphql.Object
+
+	// credits caches each movie's credits for the query, so cast & crew share one lookup
+	mu      sync.Mutex
+	credits map[int][]*models.Credit
 }
 
-// New is the factory method to create a new instance of the Graph type.
-func New(movies []*models.Movie) *Graph {
+// New is the factory method to create a new instance of the Graph type. Movies are loaded from db as
+// queries ask for them, with the same paging, sorting & filtering as the REST endpoints
+func New(db repository.DatabaseRepo) *Graph {
+	g := &Graph{
+		DB:      db,
+		credits: make(map[int][]*models.Credit),
+	}
+
 	// first, we describe the kinds of things we want to expose from our DB
 	// we have to declare fields in here, which must match DB field names
 	var movieType = graphql.NewObject(
@@ -53,6 +69,121 @@ func New(movies []*models.Movie) *Graph {
 				"image": &graphql.Field{
 					Type: graphql.String,
 				},
+				"rating_average": &graphql.Field{
+					Type: graphql.Float,
+				},
+				"rating_count": &graphql.Field{
+					Type: graphql.Int,
+				},
+			},
+		},
+	)
+
+	// people & their credits link movies to each other, so their movie & filmography fields are added
+	// once all three types exist
+	var personType = graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "Person",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"name": &graphql.Field{
+					Type: graphql.String,
+				},
+				"biography": &graphql.Field{
+					Type: graphql.String,
+				},
+				"birth_date": &graphql.Field{
+					Type: graphql.DateTime,
+				},
+				"image": &graphql.Field{
+					Type: graphql.String,
+				},
+			},
+		},
+	)
+
+	var creditType = graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "Credit",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"role": &graphql.Field{
+					Type: graphql.String,
+				},
+				"character": &graphql.Field{
+					Type: graphql.String,
+				},
+				"billing_order": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"person": &graphql.Field{
+					Type: personType,
+				},
+			},
+		},
+	)
+
+	creditType.AddFieldConfig("movie", &graphql.Field{
+		Type: movieType,
+	})
+
+	personType.AddFieldConfig("filmography", &graphql.Field{
+		Type:        graphql.NewList(creditType),
+		Description: "Every movie the person is credited in, newest first",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			person, ok := p.Source.(*models.Person)
+			if !ok {
+				return nil, nil
+			}
+			// people reached through a movie's credits don't have their filmography loaded
+			if person.Filmography == nil {
+				full, err := db.GetPerson(person.ID)
+				if err != nil {
+					return nil, err
+				}
+				person.Filmography = full.Filmography
+			}
+			return person.Filmography, nil
+		},
+	})
+
+	movieType.AddFieldConfig("cast", &graphql.Field{
+		Type:        graphql.NewList(creditType),
+		Description: "The actors, in billing order",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return g.movieCredits(p.Source, true)
+		},
+	})
+
+	movieType.AddFieldConfig("crew", &graphql.Field{
+		Type:        graphql.NewList(creditType),
+		Description: "Everyone else credited, in billing order",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return g.movieCredits(p.Source, false)
+		},
+	})
+
+	// a page of movies, for clients that want the total & cursors as well as the movies
+	var moviePageType = graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "MoviePage",
+			Fields: graphql.Fields{
+				"movies": &graphql.Field{
+					Type: graphql.NewList(movieType),
+				},
+				"total": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"nextCursor": &graphql.Field{
+					Type: graphql.String,
+				},
+				"prevCursor": &graphql.Field{
+					Type: graphql.String,
+				},
 			},
 		},
 	)
@@ -62,31 +193,54 @@ func New(movies []*models.Movie) *Graph {
 	var fields = graphql.Fields{
 		"list": &graphql.Field{
 			Type:        graphql.NewList(movieType),
-			Description: "Get all movies",
+			Description: "Get all movies, or only a page of them if page, pageSize or cursor is given",
+			Args:        movieListArgs(),
 			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-				return movies, nil
+				return g.listMovies(params.Context, movieQueryFromArgs(params.Args))
+			},
+		},
+
+		"page": &graphql.Field{
+			Type:        moviePageType,
+			Description: "Get a page of movies, with the total & cursors for the pages either side",
+			Args:        movieListArgs(),
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				return db.ListMovies(params.Context, movieQueryFromArgs(params.Args))
 			},
 		},
 
 		"search": &graphql.Field{
 			Type:        graphql.NewList(movieType),
-			Description: "Search movies by title",
+			Description: "Search movies by title (titleContains), or full text search titles & descriptions (query). Every match is returned unless page, pageSize or cursor is given",
+			Args:        movieListArgs(),
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				query := movieQueryFromArgs(params.Args)
+				if query.TitleContains == "" && query.Search == "" {
+					return nil, nil
+				}
+				return g.listMovies(params.Context, query)
+			},
+		},
+
+		"person": &graphql.Field{
+			Type:        personType,
+			Description: "Get a person by id",
 			Args: graphql.FieldConfigArgument{
-				"titleContains": &graphql.ArgumentConfig{
-					Type: graphql.String,
+				"id": &graphql.ArgumentConfig{
+					Type: graphql.Int,
 				},
 			},
-			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-				var theList []*models.Movie
-				search, ok := params.Args["titleContains"].(string)
-				if ok {
-					for _, currentMovie := range movies {
-						if strings.Contains(strings.ToLower(currentMovie.Title), strings.ToLower(search)) {
-							theList = append(theList, currentMovie)
-						}
-					}
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				id, ok := p.Args["id"].(int)
+				if !ok {
+					return nil, nil
 				}
-				return theList, nil
+				person, err := db.GetPerson(id)
+				if err != nil {
+					// an unknown id is a null person, not an error
+					return nil, nil
+				}
+				return person, nil
 			},
 		},
 
@@ -101,25 +255,140 @@ func New(movies []*models.Movie) *Graph {
 			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
 				id, ok := p.Args["id"].(int)
 				if ok {
-					for _, movie := range movies {
-						if movie.ID == id {
-							return movie, nil
-						}
+					movie, err := db.OneMovie(id)
+					if err != nil {
+						// an unknown id is a null movie, not an error
+						return nil, nil
 					}
+					return movie, nil
 				}
 				return nil, nil
 			},
 		},
 	}
 
-	return &Graph{
-		Movies:    movies,
-		fields:    fields,
-		movieType: movieType,
+	g.fields = fields
+	g.movieType = movieType
+
+	return g
+}
+
+// listMovies returns the movies matching q. list & search have always returned every match, so unless
+// the query asks for a page, it follows the cursors from page to page until there are no more
+func (g *Graph) listMovies(ctx context.Context, q models.MovieQuery) ([]*models.Movie, error) {
+	paged := q.Page > 0 || q.PageSize > 0 || q.Cursor != ""
+	if !paged {
+		q.PageSize = unpagedBatchSize
+	}
+
+	var movies []*models.Movie
+	for {
+		page, err := g.DB.ListMovies(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		movies = append(movies, page.Movies...)
+
+		if paged || page.NextCursor == "" {
+			return movies, nil
+		}
+		q.Cursor = page.NextCursor
+	}
+}
+
+// movieCredits returns the cast (actors) or crew (everyone else) of the movie being resolved
+func (g *Graph) movieCredits(source interface{}, cast bool) ([]*models.Credit, error) {
+	movie, ok := source.(*models.Movie)
+	if !ok {
+		return nil, nil
 	}
+
+	g.mu.Lock()
+	credits, found := g.credits[movie.ID]
+	g.mu.Unlock()
+
+	if !found {
+		var err error
+		credits, err = g.DB.MovieCredits(movie.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		g.mu.Lock()
+		g.credits[movie.ID] = credits
+		g.mu.Unlock()
+	}
+
+	var list []*models.Credit
+	for _, credit := range credits {
+		if (credit.Role == models.CreditActor) == cast {
+			list = append(list, credit)
+		}
+	}
+
+	return list, nil
+}
+
+// movieListArgs are the paging, sorting & filtering arguments the movie list fields take
+func movieListArgs() graphql.FieldConfigArgument {
+	return graphql.FieldConfigArgument{
+		"page":          &graphql.ArgumentConfig{Type: graphql.Int},
+		"pageSize":      &graphql.ArgumentConfig{Type: graphql.Int},
+		"cursor":        &graphql.ArgumentConfig{Type: graphql.String},
+		"sort":          &graphql.ArgumentConfig{Type: graphql.String},
+		"genres":        &graphql.ArgumentConfig{Type: graphql.NewList(graphql.Int)},
+		"people":        &graphql.ArgumentConfig{Type: graphql.NewList(graphql.Int)},
+		"ratings":       &graphql.ArgumentConfig{Type: graphql.NewList(graphql.String)},
+		"titleContains": &graphql.ArgumentConfig{Type: graphql.String},
+		"query":         &graphql.ArgumentConfig{Type: graphql.String},
+		"yearFrom":      &graphql.ArgumentConfig{Type: graphql.Int},
+		"yearTo":        &graphql.ArgumentConfig{Type: graphql.Int},
+		"runtimeMin":    &graphql.ArgumentConfig{Type: graphql.Int},
+		"runtimeMax":    &graphql.ArgumentConfig{Type: graphql.Int},
+	}
+}
+
+// movieQueryFromArgs turns the arguments from movieListArgs into a MovieQuery. Anything left out keeps
+// its zero value, which the repository treats as "no filter" or the default
+func movieQueryFromArgs(args map[string]interface{}) models.MovieQuery {
+	var q models.MovieQuery
+
+	q.Page, _ = args["page"].(int)
+	q.PageSize, _ = args["pageSize"].(int)
+	q.Cursor, _ = args["cursor"].(string)
+	q.Sort, _ = args["sort"].(string)
+	q.TitleContains, _ = args["titleContains"].(string)
+	q.Search, _ = args["query"].(string)
+	q.YearFrom, _ = args["yearFrom"].(int)
+	q.YearTo, _ = args["yearTo"].(int)
+	q.RuntimeMin, _ = args["runtimeMin"].(int)
+	q.RuntimeMax, _ = args["runtimeMax"].(int)
+
+	genres, _ := args["genres"].([]interface{})
+	for _, genre := range genres {
+		if id, ok := genre.(int); ok {
+			q.Genres = append(q.Genres, id)
+		}
+	}
+
+	people, _ := args["people"].([]interface{})
+	for _, person := range people {
+		if id, ok := person.(int); ok {
+			q.People = append(q.People, id)
+		}
+	}
+
+	ratings, _ := args["ratings"].([]interface{})
+	for _, rating := range ratings {
+		if r, ok := rating.(string); ok {
+			q.MPAARatings = append(q.MPAARatings, r)
+		}
+	}
+
+	return q
 }
 
-func (g *Graph) Query() (*graphql.Result, error) {
+func (g *Graph) Query(ctx context.Context) (*graphql.Result, error) {
 	rootQuery := graphql.ObjectConfig{Name: "RootQuery", Fields: g.fields}
 	schemaConfig := graphql.SchemaConfig{Query: graphql.NewObject(rootQuery)}
 	schema, err := graphql.NewSchema(schemaConfig)
@@ -127,7 +396,7 @@ func (g *Graph) Query() (*graphql.Result, error) {
 		return nil, err
 	}
 
-	params := graphql.Params{Schema: schema, RequestString: g.QueryString}
+	params := graphql.Params{Schema: schema, RequestString: g.QueryString, Context: ctx}
 	resp := graphql.Do(params)
 	if len(resp.Errors) > 0 {
 		return nil, errors.New("error executing query")
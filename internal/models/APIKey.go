@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+// Scopes say what an API key may do. Users get theirs from their role (see RoleScopes), API keys are
+// given an explicit list when they are minted
+const (
+	ScopeMoviesRead   = "movies:read"
+	ScopeMoviesWrite  = "movies:write"
+	ScopeMoviesDelete = "movies:delete"
+)
+
+// AllScopes lists every scope an API key can be given
+var AllScopes = []string{ScopeMoviesRead, ScopeMoviesWrite, ScopeMoviesDelete}
+
+// RoleScopes maps each role to the scopes it implies
+var RoleScopes = map[string][]string{
+	RoleViewer: {},
+	RoleEditor: {ScopeMoviesRead, ScopeMoviesWrite},
+	RoleAdmin:  {ScopeMoviesRead, ScopeMoviesWrite, ScopeMoviesDelete},
+}
+
+// ValidScope reports whether scope is one of AllScopes
+func ValidScope(scope string) bool {
+	for _, s := range AllScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKey is a long lived credential for scripts & other services. Only a hash of the key is stored;
+// Prefix is the start of the key, kept so people can tell their keys apart
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  int        `json:"created_by"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Active reports whether the key can be used at time t
+func (k *APIKey) Active(t time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || k.ExpiresAt.After(t)
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AuditEvent records one change an admin (or an API key) made. Before & After only hold the fields
+// that changed: After is empty when something was deleted, Before when it was created
+type AuditEvent struct {
+	ID int `json:"id"`
+	// ActorID is the user who made the change. For API keys it is the user who created the key
+	ActorID    int             `json:"actor_id"`
+	APIKeyID   *int            `json:"api_key_id,omitempty"`
+	Action     string          `json:"action"`
+	EntityType string          `json:"entity_type"`
+	EntityID   string          `json:"entity_id"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	RequestID  string          `json:"request_id"`
+	IP         string          `json:"ip"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// AuditQuery filters & pages the audit log. Zero values don't filter
+type AuditQuery struct {
+	ActorID    int
+	EntityType string
+	EntityID   string
+	From       time.Time
+	To         time.Time
+	Page       int
+	PageSize   int
+}
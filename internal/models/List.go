@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// Who can see a list. Public lists are listed for everyone at /lists, unlisted ones can be seen by
+// anyone with their link, and private ones only by their owner
+const (
+	ListPublic   = "public"
+	ListUnlisted = "unlisted"
+	ListPrivate  = "private"
+)
+
+// ValidListVisibility reports whether visibility is one of the List* visibilities above
+func ValidListVisibility(visibility string) bool {
+	switch visibility {
+	case ListPublic, ListUnlisted, ListPrivate:
+		return true
+	}
+	return false
+}
+
+// List is a user's named, ordered list of movies. Every user also has one Watchlist, which is always
+// private & made the first time they use it. Slug is what the list is shared by, eg. /lists/heist-movies-3fa9c1
+type List struct {
+	ID          int       `json:"id"`
+	UserID      int       `json:"user_id"`
+	Owner       string    `json:"owner"`
+	Name        string    `json:"name"`
+	Slug        string    `json:"slug"`
+	Description string    `json:"description"`
+	Visibility  string    `json:"visibility"`
+	Watchlist   bool      `json:"watchlist"`
+	ItemCount   int       `json:"item_count"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	// Items are only loaded for a single list, in order
+	Items []*ListItem `json:"items,omitempty"`
+}
+
+// ListItem is a movie on a list. Lower positions come first
+type ListItem struct {
+	Position int       `json:"position"`
+	AddedAt  time.Time `json:"added_at"`
+	Movie    *Movie    `json:"movie"`
+}
+
+// ListQuery pages through lists. With UserID it is that user's lists (other than their watchlist),
+// otherwise every public list. Either way the most recently changed come first
+type ListQuery struct {
+	UserID   int
+	Page     int
+	PageSize int
+}
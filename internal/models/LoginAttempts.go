@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// LoginAttempts counts recent failed logins for one key: an account ("account:<email>") or a client
+// IP address ("ip:<address>"). While LockedUntil is in the future, logins for that key are refused
+type LoginAttempts struct {
+	Key           string     `json:"key"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty"`
+}
+
+// Locked reports whether the key is locked out at time t
+func (a *LoginAttempts) Locked(t time.Time) bool {
+	return a.LockedUntil != nil && a.LockedUntil.After(t)
+}
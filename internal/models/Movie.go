@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 type Movie struct {
 	ID           int       `json:"id"`
@@ -14,6 +17,40 @@ type Movie struct {
 	UpdatedField time.Time `json:"-"`
 	Genres       []*Genre  `json:"genres,omitempty"`
 	GenresArray  []int     `json:"genres_array,omitempty"`
+	// RatingAverage is the mean of the users' ratings (1-10) & RatingCount how many there are. The
+	// database keeps both up to date as reviews come & go
+	RatingAverage float64 `json:"rating_average"`
+	RatingCount   int     `json:"rating_count"`
+	// Cast & Crew are only loaded for a single movie, in billing order
+	Cast []*Credit `json:"cast,omitempty"`
+	Crew []*Credit `json:"crew,omitempty"`
+	// Rank, Headline & Snippet are only set on search results. Headline is the title & Snippet the best
+	// bits of the description, with the words that matched wrapped in <mark></mark>
+	Rank     float32 `json:"rank,omitempty"`
+	Headline string  `json:"headline,omitempty"`
+	Snippet  string  `json:"snippet,omitempty"`
+}
+
+// Suggestion is a movie title offered while the user is still typing. Score is 1 for titles that start
+// with what was typed, and how similar the title is otherwise
+type Suggestion struct {
+	ID    int     `json:"id"`
+	Title string  `json:"title"`
+	Score float64 `json:"score"`
+}
+
+// MovieFeatures is what similar movies are found by: a movie with the ids of its genres & of the people
+// in its cast & crew
+type MovieFeatures struct {
+	Movie     *Movie
+	GenreIDs  []int
+	PersonIDs []int
+}
+
+// SimilarMovie is a movie recommended for being like another one. Score is from 0 (nothing alike) to 1
+type SimilarMovie struct {
+	Movie *Movie  `json:"movie"`
+	Score float64 `json:"score"`
 }
 
 type Genre struct {
@@ -23,3 +60,57 @@ type Genre struct {
 	CreatedAt    time.Time `json:"-"`
 	UpdatedField time.Time `json:"-"`
 }
+
+// The ways a list of movies can be sorted. A leading "-" sorts in descending order, except for
+// relevance, which puts the best matches first (and "-relevance" the worst). Relevance needs a Search
+const (
+	MovieSortTitle       = "title"
+	MovieSortReleaseDate = "release_date"
+	MovieSortRuntime     = "runtime"
+	MovieSortRelevance   = "relevance"
+)
+
+// ValidMovieSort reports whether sort is one of the MovieSort* fields, optionally prefixed with "-"
+func ValidMovieSort(sort string) bool {
+	switch strings.TrimPrefix(sort, "-") {
+	case MovieSortTitle, MovieSortReleaseDate, MovieSortRuntime, MovieSortRelevance:
+		return true
+	}
+	return false
+}
+
+// MovieQuery filters, sorts & pages a list of movies. Zero values mean "no filter". When Cursor is set
+// (keyset pagination) Page is ignored
+type MovieQuery struct {
+	// Search is a full text search of titles & descriptions, in web search syntax, eg. `"star wars" -clone`
+	Search string
+	// Sort is one of the MovieSort* fields, optionally prefixed with "-". Defaults to relevance when
+	// searching & title otherwise
+	Sort string
+	// Genres matches movies in any of the genres
+	Genres []int
+	// People matches movies any of the people are credited in
+	People []int
+	// MPAARatings matches movies with any of the ratings
+	MPAARatings []string
+	// TitleContains matches movies with the text anywhere in their title, ignoring case
+	TitleContains string
+	// YearFrom & YearTo are the release years to include (inclusive)
+	YearFrom int
+	YearTo   int
+	// RuntimeMin & RuntimeMax are in minutes (inclusive)
+	RuntimeMin int
+	RuntimeMax int
+
+	Page     int
+	PageSize int
+	Cursor   string
+}
+
+// MoviePage is one page of a movie list. NextCursor & PrevCursor are empty when there is no page that way
+type MoviePage struct {
+	Movies     []*Movie
+	Total      int
+	NextCursor string
+	PrevCursor string
+}
@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+// The parts someone can have in a movie. Actors are the cast, everyone else is crew
+const (
+	CreditActor    = "actor"
+	CreditDirector = "director"
+	CreditWriter   = "writer"
+	CreditProducer = "producer"
+	CreditComposer = "composer"
+)
+
+// ValidCreditRole reports whether role is one of the Credit* roles above
+func ValidCreditRole(role string) bool {
+	switch role {
+	case CreditActor, CreditDirector, CreditWriter, CreditProducer, CreditComposer:
+		return true
+	}
+	return false
+}
+
+// Person is someone in the cast or crew of a movie
+type Person struct {
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	Biography string     `json:"biography"`
+	BirthDate *time.Time `json:"birth_date"`
+	Image     string     `json:"image"`
+	CreatedAt time.Time  `json:"-"`
+	UpdatedAt time.Time  `json:"-"`
+	// Filmography is only loaded for a single person, newest movie first
+	Filmography []*Credit `json:"filmography,omitempty"`
+}
+
+// Credit is one part a person had in a movie. Character is only set for actors; lower BillingOrder
+// is billed first. Person is set when listing a movie's credits, Movie when listing a person's
+type Credit struct {
+	ID           int     `json:"id"`
+	MovieID      int     `json:"movie_id"`
+	PersonID     int     `json:"person_id"`
+	Role         string  `json:"role"`
+	Character    string  `json:"character,omitempty"`
+	BillingOrder int     `json:"billing_order"`
+	Person       *Person `json:"person,omitempty"`
+	Movie        *Movie  `json:"movie,omitempty"`
+}
+
+// PersonQuery filters & pages the people list. Search matches anywhere in the name
+type PersonQuery struct {
+	Search   string
+	Page     int
+	PageSize int
+}
@@ -0,0 +1,127 @@
+package models
+
+import "time"
+
+// The orders a movie's reviews can be listed in. Helpful puts the reviews with the most helpful votes
+// first, recent the newest
+const (
+	ReviewSortHelpful = "helpful"
+	ReviewSortRecent  = "recent"
+)
+
+// Ratings are out of 10
+const (
+	MinRating = 1
+	MaxRating = 10
+)
+
+// Where a review is in moderation. Only published reviews are shown to everyone & count towards a movie's
+// rating. Pending reviews wait for a moderator before anyone else sees them, flagged ones were reported
+// or tripped the banned word filter, and rejected ones were turned down
+const (
+	ReviewPending   = "pending"
+	ReviewPublished = "published"
+	ReviewRejected  = "rejected"
+	ReviewFlagged   = "flagged"
+)
+
+// ValidReviewStatus reports whether status is one of the Review* statuses above
+func ValidReviewStatus(status string) bool {
+	switch status {
+	case ReviewPending, ReviewPublished, ReviewRejected, ReviewFlagged:
+		return true
+	}
+	return false
+}
+
+// reviewTransitions are the moves moderation can make, from each status. Publishing a rejected review
+// (and rejecting a published one) lets moderators change their minds
+var reviewTransitions = map[string][]string{
+	ReviewPending:   {ReviewPublished, ReviewRejected},
+	ReviewFlagged:   {ReviewPublished, ReviewRejected},
+	ReviewPublished: {ReviewFlagged, ReviewRejected},
+	ReviewRejected:  {ReviewPublished},
+}
+
+// ReviewTransitionAllowed reports whether moderation may move a review from one status to another
+func ReviewTransitionAllowed(from, to string) bool {
+	for _, allowed := range reviewTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// SubmittedReviewStatus is the status a review gets when its author writes or edits it. previous is its
+// status before the edit, "" for a new review. Reviews with banned words are flagged, and reviews a
+// moderator had doubts about go back to them rather than being published straight away
+func SubmittedReviewStatus(previous string, banned, premoderated bool) string {
+	switch {
+	case banned:
+		return ReviewFlagged
+	case premoderated, previous == ReviewPending, previous == ReviewFlagged, previous == ReviewRejected:
+		return ReviewPending
+	}
+	return ReviewPublished
+}
+
+// Review is a user's rating of a movie, with what they wrote about it if anything. Users have at most
+// one review per movie. Author is the reviewer's first name, which is all of them we show
+type Review struct {
+	ID           int    `json:"id"`
+	MovieID      int    `json:"movie_id"`
+	UserID       int    `json:"user_id"`
+	Author       string `json:"author"`
+	Rating       int    `json:"rating"`
+	Body         string `json:"review"`
+	HelpfulCount int    `json:"helpful_count"`
+	Status       string `json:"status"`
+	// ShadowHidden is set on every review by a shadow hidden user. Their reviews look published to them,
+	// but nobody else sees them, so it is never sent to clients
+	ShadowHidden bool      `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	// Reports is how many reports are waiting for a moderator. Only set in the moderation queue
+	Reports int `json:"reports,omitempty"`
+}
+
+// ReviewQuery pages through a movie's published reviews. Sort is one of the ReviewSort* orders, helpful
+// by default. ViewerID's own reviews are included whatever their status, so they see what they wrote
+type ReviewQuery struct {
+	MovieID  int
+	ViewerID int
+	Sort     string
+	Page     int
+	PageSize int
+}
+
+// ReviewReport is a user telling moderators a review breaks the rules. ResolvedAt is set once a
+// moderator has made a decision about the review
+type ReviewReport struct {
+	ID         int        `json:"id"`
+	ReviewID   int        `json:"review_id"`
+	UserID     int        `json:"user_id"`
+	Reason     string     `json:"reason"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+}
+
+// ModerationDecision records a review changing status. ModeratorID is the admin who decided, or nil
+// when it was automatic (the banned word filter, or enough reports)
+type ModerationDecision struct {
+	ID          int64     `json:"id"`
+	ReviewID    int       `json:"review_id"`
+	ModeratorID *int      `json:"moderator_id"`
+	FromStatus  string    `json:"from_status"`
+	ToStatus    string    `json:"to_status"`
+	Reason      string    `json:"reason"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ModerationQuery pages through the moderation queue. Statuses defaults to pending & flagged
+type ModerationQuery struct {
+	Statuses []string
+	Page     int
+	PageSize int
+}
@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+// RefreshToken is the server side record of a refresh token we handed out. We never store the token
+// itself, only a SHA-256 hash of it. Every token issued from the same login shares a FamilyID, so if a
+// token that was already rotated shows up again we can revoke everything that descended from that login
+type RefreshToken struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	TokenHash string     `json:"-"`
+	FamilyID  string     `json:"family_id"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Session is one login on one device: every refresh token issued from that login shares its ID (the
+// refresh token family id). Revoking a session revokes the whole family, so its next /refresh fails
+type Session struct {
+	ID              string     `json:"id"`
+	UserID          int        `json:"user_id"`
+	UserAgent       string     `json:"user_agent"`
+	IP              string     `json:"ip"`
+	CreatedAt       time.Time  `json:"created_at"`
+	LastRefreshedAt time.Time  `json:"last_refreshed_at"`
+	ExpiresAt       time.Time  `json:"expires_at"`
+	RevokedAt       *time.Time `json:"revoked_at,omitempty"`
+	// Current is set on the session the request listing the sessions was made from
+	Current bool `json:"current"`
+}
+
+// The things a UserToken can be used for
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+)
+
+// UserToken is a single use token we email to a user, eg. to reset their password. Like refresh
+// tokens, only a hash of the token is stored
+type UserToken struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	TokenHash string     `json:"-"`
+	Purpose   string     `json:"purpose"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -1,34 +1,61 @@
 package models
 
 import (
-	"errors"
+	"backend/internal/password"
 	"time"
+)
 
-	"golang.org/x/crypto/bcrypt"
+// The roles a user can have. Viewers can only use the public side of the app, editors can also add and
+// change movies, and admins can do everything (including deleting movies)
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
 )
 
+// ValidRole reports whether role is one of the roles above
+func ValidRole(role string) bool {
+	switch role {
+	case RoleViewer, RoleEditor, RoleAdmin:
+		return true
+	}
+	return false
+}
+
 // Lets create a structure we can store users in
 type User struct {
-	ID        int       `json:"id"`
-	FirstName string    `json:"first_name"`
-	LastName  string    `json:"last_name"`
-	Email     string    `json:"email"`
-	Password  string    `json:"password"`
-	CreatedAt time.Time `json:"-"`
-	UpdatedAt time.Time `json:"-"`
+	ID        int    `json:"id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Password  string `json:"-"`
+	Role      string `json:"role"`
+	// EmailVerifiedAt is when the user proved they own their email address, nil until they do
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	// TOTPSecret is set as soon as the user starts enrolling an authenticator app, but two-factor
+	// authentication is only on once they have confirmed a code from it (TOTPEnabledAt)
+	TOTPSecret    string     `json:"-"`
+	TOTPEnabledAt *time.Time `json:"totp_enabled_at"`
+	// TOTPLastStep is the time step of the last code used, so a code can't be used twice
+	TOTPLastStep int64 `json:"-"`
+	// DisabledAt is set when an admin disables the account. Disabled users can't log in or refresh
+	DisabledAt *time.Time `json:"disabled_at"`
+	// ShadowHiddenAt is set when a moderator hides everything the user writes from everyone else. The
+	// user isn't told, so it is only ever shown to admins
+	ShadowHiddenAt *time.Time `json:"-"`
+	CreatedAt      time.Time  `json:"-"`
+	UpdatedAt      time.Time  `json:"-"`
 }
 
-func (u *User) PasswordMatches(plainText string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(plainText))
-	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			// invalid password (no errror with our code)
-			return false, nil
-		default:
-			return false, err
-		}
-	}
+// UserQuery filters & pages the admin user list. Search matches the start of the email or either name
+type UserQuery struct {
+	Search   string
+	Role     string
+	Page     int
+	PageSize int
+}
 
-	return true, nil
+// PasswordMatches checks the plain text password against the stored hash, whichever algorithm made it
+func (u *User) PasswordMatches(plainText string) (bool, error) {
+	return password.Verify(plainText, u.Password)
 }
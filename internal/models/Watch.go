@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+// FinishedFraction is how far through a movie counts as having finished it: the credits are rolling, so
+// it drops out of continue watching
+const FinishedFraction = 0.95
+
+// WatchEvent records the user watching a movie. Every rewatch is another event. WatchedAt is when they
+// say they watched it, CreatedAt when they told us
+type WatchEvent struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"-"`
+	MovieID   int       `json:"movie_id"`
+	Title     string    `json:"title"`
+	WatchedAt time.Time `json:"watched_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WatchProgress is how far the user got through a movie they haven't finished, in seconds from the start
+type WatchProgress struct {
+	UserID          int       `json:"-"`
+	MovieID         int       `json:"movie_id"`
+	PositionSeconds int       `json:"position_seconds"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	// Progress is how far through the movie that is, see Fraction
+	Progress float64 `json:"progress"`
+	// Movie is only loaded for the continue watching feed
+	Movie *Movie `json:"movie,omitempty"`
+}
+
+// Fraction is how far through the movie the position is, from 0 to 1. It is 0 when the movie's runtime
+// (in minutes) isn't known
+func (p WatchProgress) Fraction(runTime int) float64 {
+	if runTime <= 0 {
+		return 0
+	}
+	fraction := float64(p.PositionSeconds) / float64(runTime*60)
+	if fraction > 1 {
+		return 1
+	}
+	return fraction
+}
+
+// WatchHistoryQuery pages through a user's watch history, most recently watched first
+type WatchHistoryQuery struct {
+	UserID   int
+	Page     int
+	PageSize int
+}
@@ -0,0 +1,91 @@
+// Package moderation holds the banned word filter that flags user written content for a moderator to
+// look at. Matching ignores case, accents & punctuation, and only whole words (or phrases) match, so
+// banning "ass" doesn't flag "class"
+package moderation
+
+import (
+	"backend/internal/suggest"
+	"bufio"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Filter finds banned words in text. The zero value bans nothing. Add to it before sharing it between
+// requests: it isn't safe to change while it is being used
+type Filter struct {
+	// banned holds each banned word or phrase, folded & with its words separated by single spaces
+	banned map[string]struct{}
+}
+
+// Add bans words. Each can be a single word or a phrase of several
+func (f *Filter) Add(words ...string) {
+	if f.banned == nil {
+		f.banned = make(map[string]struct{})
+	}
+
+	for _, word := range words {
+		if normalized := normalize(word); normalized != "" {
+			f.banned[normalized] = struct{}{}
+		}
+	}
+}
+
+// LoadFile bans the words in a file, one word or phrase per line. Blank lines & lines starting with #
+// are skipped. It returns how many lines were read
+func (f *Filter) LoadFile(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		f.Add(line)
+		count++
+	}
+
+	return count, scanner.Err()
+}
+
+// Len is the number of banned words & phrases
+func (f *Filter) Len() int {
+	return len(f.banned)
+}
+
+// Match returns the banned words & phrases found in text, folded & in alphabetical order, or nil if
+// there are none
+func (f *Filter) Match(text string) []string {
+	if len(f.banned) == 0 {
+		return nil
+	}
+
+	// spaces either side let us match whole words & phrases with a plain substring search
+	padded := " " + normalize(text) + " "
+
+	var found []string
+	for banned := range f.banned {
+		if strings.Contains(padded, " "+banned+" ") {
+			found = append(found, banned)
+		}
+	}
+
+	sort.Strings(found)
+	return found
+}
+
+// normalize folds s & keeps only its words, separated by single spaces
+func normalize(s string) string {
+	words := strings.FieldsFunc(suggest.Fold(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
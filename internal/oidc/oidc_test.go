@@ -0,0 +1,168 @@
+package oidc_test
+
+import (
+	"backend/internal/oidc"
+	"backend/internal/oidc/oidctest"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const redirectURL = "http://localhost:8080/auth/oidc/callback"
+
+func newProvider(t *testing.T) (*oidctest.Provider, *oidc.Provider) {
+	t.Helper()
+
+	idp := oidctest.NewProvider("go-movies", "secret")
+	t.Cleanup(idp.Close)
+
+	return idp, &oidc.Provider{
+		Issuer:       idp.Issuer,
+		ClientID:     "go-movies",
+		ClientSecret: "secret",
+		RedirectURL:  redirectURL,
+	}
+}
+
+// authorize sends the user to the provider & returns the code it redirects back with
+func authorize(t *testing.T, p *oidc.Provider, nonce, verifier string) string {
+	t.Helper()
+
+	authURL, err := p.AuthCodeURL(context.Background(), "state", nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(callback.String(), redirectURL) {
+		t.Fatalf("provider redirected to %q", res.Header.Get("Location"))
+	}
+	if callback.Query().Get("state") != "state" {
+		t.Fatalf("state came back as %q", callback.Query().Get("state"))
+	}
+	return callback.Query().Get("code")
+}
+
+// idToken goes through the whole flow & returns the raw ID token
+func idToken(t *testing.T, p *oidc.Provider, nonce string) string {
+	t.Helper()
+
+	verifier, err := oidc.NewVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := p.Exchange(context.Background(), authorize(t, p, nonce, verifier), verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens.IDToken
+}
+
+func TestDiscover(t *testing.T) {
+	idp, p := newProvider(t)
+
+	d, err := p.Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Issuer != idp.Issuer || d.TokenEndpoint != idp.Issuer+"/token" || d.JWKSURI != idp.Issuer+"/jwks" {
+		t.Errorf("got %+v", d)
+	}
+}
+
+func TestDiscoverIssuerMismatch(t *testing.T) {
+	idp, p := newProvider(t)
+
+	// the document is still found, but names the issuer without the slash
+	p.Issuer = idp.Issuer + "/"
+
+	if _, err := p.Discover(context.Background()); err == nil {
+		t.Error("accepted a discovery document for another issuer")
+	}
+}
+
+func TestPKCE(t *testing.T) {
+	_, p := newProvider(t)
+
+	verifier, err := oidc.NewVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(verifier) != 43 {
+		t.Errorf("verifier is %d characters, RFC 7636 wants 43 to 128", len(verifier))
+	}
+
+	other, err := oidc.NewVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = p.Exchange(context.Background(), authorize(t, p, "nonce", verifier), other)
+	if err == nil {
+		t.Error("exchanged a code with the wrong verifier")
+	}
+
+	_, err = p.Exchange(context.Background(), authorize(t, p, "nonce", verifier), verifier)
+	if err != nil {
+		t.Errorf("exchanging a code with its verifier: %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	idp, p := newProvider(t)
+	idp.SetUser(oidctest.User{Subject: "alice", Email: "alice@example.com", EmailVerified: true, AMR: []string{"pwd", "mfa"}})
+
+	claims, err := p.Verify(context.Background(), idToken(t, p, "nonce"), "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "alice" || claims.Email != "alice@example.com" || !claims.EmailVerified {
+		t.Errorf("got %+v", claims)
+	}
+	if len(claims.AMR) != 2 || claims.AMR[1] != "mfa" {
+		t.Errorf("got amr %v", claims.AMR)
+	}
+}
+
+func TestVerifyNonceMismatch(t *testing.T) {
+	_, p := newProvider(t)
+
+	_, err := p.Verify(context.Background(), idToken(t, p, "nonce"), "another nonce")
+	if !errors.Is(err, oidc.ErrNonce) {
+		t.Errorf("got %v, want %v", err, oidc.ErrNonce)
+	}
+}
+
+func TestVerifyAudienceMismatch(t *testing.T) {
+	idp, p := newProvider(t)
+	raw := idToken(t, p, "nonce")
+
+	// another client of the same provider must not accept a token issued to us
+	other := &oidc.Provider{Issuer: idp.Issuer, ClientID: "another-app", RedirectURL: redirectURL}
+
+	if _, err := other.Verify(context.Background(), raw, "nonce"); err == nil {
+		t.Error("accepted an id token issued to another client")
+	}
+}
+
+func TestVerifyIssuerMismatch(t *testing.T) {
+	idp, p := newProvider(t)
+	idp.TokenIssuer = "https://another-provider.example"
+
+	if _, err := p.Verify(context.Background(), idToken(t, p, "nonce"), "nonce"); err == nil {
+		t.Error("accepted an id token from another issuer")
+	}
+}
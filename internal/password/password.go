@@ -0,0 +1,180 @@
+// Package password hashes & checks user passwords. New hashes use argon2id (or bcrypt if configured),
+// stored in the PHC string format, eg. $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>. bcrypt hashes
+// keep bcrypt's own $2a$<cost>$... format, which is what the PHC format grew out of. Verify understands
+// both, and NeedsRehash tells us when a stored hash was made with weaker settings than the current ones,
+// so it can be upgraded the next time the user logs in.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The algorithms a Hasher can hash new passwords with
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+)
+
+var (
+	ErrUnknownFormat = errors.New("password: unknown hash format")
+	ErrInvalidHash   = errors.New("password: invalid hash")
+)
+
+// Argon2Params are the argon2id settings. Memory is in KiB
+type Argon2Params struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// DefaultArgon2 follows the OWASP recommendation of 64 MiB, 3 passes
+var DefaultArgon2 = Argon2Params{Memory: 64 * 1024, Time: 3, Threads: 2, SaltLen: 16, KeyLen: 32}
+
+// DefaultBcryptCost is the bcrypt cost used when Hasher.BcryptCost isn't set
+const DefaultBcryptCost = 12
+
+// Hasher hashes new passwords with Algorithm. The zero value uses argon2id with DefaultArgon2
+type Hasher struct {
+	Algorithm  string
+	Argon2     Argon2Params
+	BcryptCost int
+}
+
+func (h *Hasher) algorithm() string {
+	if h.Algorithm == "" {
+		return Argon2id
+	}
+	return h.Algorithm
+}
+
+func (h *Hasher) argon2Params() Argon2Params {
+	if h.Argon2 == (Argon2Params{}) {
+		return DefaultArgon2
+	}
+	return h.Argon2
+}
+
+func (h *Hasher) bcryptCost() int {
+	if h.BcryptCost == 0 {
+		return DefaultBcryptCost
+	}
+	return h.BcryptCost
+}
+
+// Hash hashes a plain text password with the current settings
+func (h *Hasher) Hash(plainText string) (string, error) {
+	switch h.algorithm() {
+	case Argon2id:
+		p := h.argon2Params()
+
+		salt := make([]byte, p.SaltLen)
+		_, err := rand.Read(salt)
+		if err != nil {
+			return "", err
+		}
+
+		key := argon2.IDKey([]byte(plainText), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, p.Memory, p.Time, p.Threads,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key),
+		), nil
+	case Bcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(plainText), h.bcryptCost())
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	}
+	return "", fmt.Errorf("password: unknown algorithm %q", h.Algorithm)
+}
+
+// NeedsRehash reports whether a stored hash uses another algorithm, or weaker settings, than the ones
+// we hash new passwords with
+func (h *Hasher) NeedsRehash(encoded string) bool {
+	switch h.algorithm() {
+	case Argon2id:
+		p, _, _, err := decodeArgon2id(encoded)
+		if err != nil {
+			return true
+		}
+		want := h.argon2Params()
+		return p.Memory < want.Memory || p.Time < want.Time || p.Threads != want.Threads || p.KeyLen < want.KeyLen
+	case Bcrypt:
+		cost, err := bcrypt.Cost([]byte(encoded))
+		if err != nil {
+			return true
+		}
+		return cost < h.bcryptCost()
+	}
+	return false
+}
+
+// Verify checks a plain text password against a hash made by any supported algorithm
+func Verify(plainText, encoded string) (bool, error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		p, salt, key, err := decodeArgon2id(encoded)
+		if err != nil {
+			return false, err
+		}
+		other := argon2.IDKey([]byte(plainText), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+		return subtle.ConstantTimeCompare(key, other) == 1, nil
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(plainText))
+		if err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	}
+	return false, ErrUnknownFormat
+}
+
+// decodeArgon2id splits an argon2id PHC string into its parameters, salt & key
+func decodeArgon2id(encoded string) (Argon2Params, []byte, []byte, error) {
+	var p Argon2Params
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != Argon2id {
+		return p, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return p, nil, nil, ErrInvalidHash
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads)
+	if err != nil || p.Memory == 0 || p.Time == 0 || p.Threads == 0 {
+		return p, nil, nil, ErrInvalidHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, ErrInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, ErrInvalidHash
+	}
+
+	p.SaltLen = uint32(len(salt))
+	p.KeyLen = uint32(len(key))
+
+	return p, salt, key, nil
+}
@@ -0,0 +1,147 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fastArgon2 keeps the tests quick, the format is the same whatever the settings
+var fastArgon2 = Argon2Params{Memory: 1024, Time: 1, Threads: 1, SaltLen: 16, KeyLen: 32}
+
+func TestHashVerify(t *testing.T) {
+	hashers := map[string]*Hasher{
+		Argon2id: {Algorithm: Argon2id, Argon2: fastArgon2},
+		Bcrypt:   {Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost},
+	}
+
+	for name, h := range hashers {
+		t.Run(name, func(t *testing.T) {
+			hash, err := h.Hash("correct horse battery staple")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ok, err := Verify("correct horse battery staple", hash)
+			if err != nil || !ok {
+				t.Errorf("the right password: got %v, %v", ok, err)
+			}
+			ok, err = Verify("correct horse battery stapler", hash)
+			if err != nil || ok {
+				t.Errorf("the wrong password: got %v, %v", ok, err)
+			}
+
+			// a new salt every time
+			again, err := h.Hash("correct horse battery staple")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again == hash {
+				t.Error("hashing the same password twice gave the same hash")
+			}
+
+			if h.NeedsRehash(hash) {
+				t.Error("a hash made with the current settings needs rehashing")
+			}
+		})
+	}
+}
+
+func TestHashUnknownAlgorithm(t *testing.T) {
+	h := &Hasher{Algorithm: "md5"}
+	if _, err := h.Hash("password"); err == nil {
+		t.Error("hashed with an unknown algorithm")
+	}
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+		want    error
+	}{
+		{"empty", "", ErrUnknownFormat},
+		{"plain text", "password", ErrUnknownFormat},
+		{"another algorithm", "$argon2i$v=19$m=1024,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5", ErrUnknownFormat},
+		{"argon2id prefix only", "$argon2id$", ErrInvalidHash},
+		{"missing the key", "$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHQ", ErrInvalidHash},
+		{"too many parts", "$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5$", ErrInvalidHash},
+		{"unknown version", "$argon2id$v=16$m=1024,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5", ErrInvalidHash},
+		{"garbled params", "$argon2id$v=19$m=lots,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5", ErrInvalidHash},
+		{"zero memory", "$argon2id$v=19$m=0,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5", ErrInvalidHash},
+		{"zero threads", "$argon2id$v=19$m=1024,t=1,p=0$c2FsdHNhbHQ$a2V5a2V5", ErrInvalidHash},
+		{"threads overflow", "$argon2id$v=19$m=1024,t=1,p=256$c2FsdHNhbHQ$a2V5a2V5", ErrInvalidHash},
+		{"salt not base64", "$argon2id$v=19$m=1024,t=1,p=1$!!!$a2V5a2V5", ErrInvalidHash},
+		{"empty key", "$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHQ$", ErrInvalidHash},
+		{"truncated bcrypt", "$2a$10$tooshort", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := Verify("password", tt.encoded)
+			if ok || err == nil {
+				t.Fatalf("got %v, %v, want an error", ok, err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	argon := &Hasher{Algorithm: Argon2id, Argon2: fastArgon2}
+	stronger := &Hasher{Algorithm: Argon2id, Argon2: Argon2Params{Memory: 2048, Time: 2, Threads: 1, SaltLen: 16, KeyLen: 32}}
+	cheapBcrypt := &Hasher{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost}
+	dearBcrypt := &Hasher{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost + 1}
+
+	argonHash, err := argon.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcryptHash, err := cheapBcrypt.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		hasher *Hasher
+		hash   string
+		want   bool
+	}{
+		{"legacy bcrypt hash", argon, bcryptHash, true},
+		{"legacy bcrypt hash, default hasher", &Hasher{}, bcryptHash, true},
+		{"weaker argon2id settings", stronger, argonHash, true},
+		{"same argon2id settings", argon, argonHash, false},
+		{"malformed argon2id hash", argon, "$argon2id$v=19$m=1024", true},
+		{"lower bcrypt cost", dearBcrypt, bcryptHash, true},
+		{"same bcrypt cost", cheapBcrypt, bcryptHash, false},
+		{"argon2id hash, bcrypt hasher", cheapBcrypt, argonHash, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hasher.NeedsRehash(tt.hash); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// a legacy hash still verifies, so the user can log in & have it upgraded
+func TestVerifyLegacyBcrypt(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		encoded := prefix + strings.TrimPrefix(string(hash), "$2a$")
+		if ok, err := Verify("password", encoded); err != nil || !ok {
+			t.Errorf("%s: got %v, %v", prefix, ok, err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrBreached is returned by Policy.Check for passwords on the breached password list
+var ErrBreached = errors.New("this password has appeared in a data breach, please choose another one")
+
+// Policy is what a new password must satisfy
+type Policy struct {
+	MinLength int
+	// MaxLength is in bytes. bcrypt ignores everything after the 72nd byte, so keep it at 72 with bcrypt
+	MaxLength int
+	// breached holds the upper case hex SHA-1 of every password on the breached list
+	breached map[string]struct{}
+}
+
+// Check returns a user friendly error if the password isn't allowed
+func (p *Policy) Check(plainText string) error {
+	if utf8.RuneCountInString(plainText) < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters", p.MinLength)
+	}
+	if p.MaxLength > 0 && len(plainText) > p.MaxLength {
+		return fmt.Errorf("password must be at most %d bytes", p.MaxLength)
+	}
+
+	if _, found := p.breached[sha1Hex(plainText)]; found {
+		return ErrBreached
+	}
+
+	return nil
+}
+
+// LoadBreached reads a breached password list, one per line. Lines can be plain text passwords or
+// SHA-1 hashes as published by Have I Been Pwned ("HASH" or "HASH:count"). Blank lines & lines
+// starting with # are skipped. Loading again adds to the list
+func (p *Policy) LoadBreached(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	if p.breached == nil {
+		p.breached = make(map[string]struct{})
+	}
+
+	count := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		p.breached[breachedKey(line)] = struct{}{}
+		count++
+	}
+
+	return count, scanner.Err()
+}
+
+// breachedKey turns a line of the breached list into the SHA-1 we look passwords up by
+func breachedKey(line string) string {
+	hash, _, _ := strings.Cut(line, ":")
+	if len(hash) == sha1.Size*2 {
+		if _, err := hex.DecodeString(hash); err == nil {
+			return strings.ToUpper(hash)
+		}
+	}
+	return sha1Hex(line)
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,83 @@
+package password
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	// the breached list is usually Have I Been Pwned's, in any case
+	pwned := sha1.Sum([]byte("correct horse battery staple"))
+	list := strings.Join([]string{
+		"# a few of the most common passwords",
+		"password123456",
+		"",
+		"  qwertyuiopasdf  ",
+		strings.ToLower(hex.EncodeToString(pwned[:])) + ":3730471",
+	}, "\n")
+
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(path, []byte(list), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	p := Policy{MinLength: 12, MaxLength: 72}
+	count, err := p.LoadBreached(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("loaded %d passwords, want 3", count)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		ok       bool
+		want     error
+	}{
+		{"long enough", "a perfectly fine one", true, nil},
+		{"exactly the minimum", "twelve chars", true, nil},
+		{"too short", "eleven char", false, nil},
+		{"empty", "", false, nil},
+		{"length counted in characters", "éééééééééééé", true, nil},
+		{"too long", strings.Repeat("a", 73), false, nil},
+		{"exactly the maximum", strings.Repeat("a", 72), true, nil},
+		{"too long in bytes", strings.Repeat("é", 37), false, nil},
+		{"breached, plain text", "password123456", false, ErrBreached},
+		{"breached, surrounding space trimmed from the list", "qwertyuiopasdf", false, ErrBreached},
+		{"breached, sha-1 hash", "correct horse battery staple", false, ErrBreached},
+		{"breached is case sensitive", "PASSWORD123456", true, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Check(tt.password)
+			if (err == nil) != tt.ok {
+				t.Fatalf("got %v, want ok %v", err, tt.ok)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyNoMaximum(t *testing.T) {
+	p := Policy{MinLength: 8}
+	if err := p.Check(strings.Repeat("a", 1000)); err != nil {
+		t.Errorf("got %v, a MaxLength of 0 means no maximum", err)
+	}
+}
+
+func TestLoadBreachedMissingFile(t *testing.T) {
+	var p Policy
+	if _, err := p.LoadBreached(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("no error for a missing list")
+	}
+}
@@ -0,0 +1,181 @@
+// Package playback coalesces the playback positions players send every few seconds, so the database only
+// sees the latest position of each viewer once per flush rather than every update
+package playback
+
+import (
+	"backend/internal/models"
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+type key struct {
+	userID  int
+	movieID int
+}
+
+// Buffer holds the latest position of each user & movie until it is flushed to Save. The zero value
+// holds positions but can't flush them, so set Save before using it. Positions that haven't been
+// flushed yet are lost if the process dies, which is at most one flush interval of progress
+type Buffer struct {
+	// Save writes a batch of positions. It must not let an older position overwrite a newer one
+	Save func(progress []models.WatchProgress) error
+
+	// flushMu makes flushes take turns, so there is only ever one batch being saved
+	flushMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[key]models.WatchProgress
+	// saving is the batch being saved. Until Save returns its positions are still waiting to be saved,
+	// and the ones in forgotten have been forgotten since, so aren't put back if Save fails
+	saving    map[key]models.WatchProgress
+	forgotten map[key]struct{}
+}
+
+// Record keeps p as the user's latest position in the movie, replacing any that is waiting to be saved
+func (b *Buffer) Record(p models.WatchProgress) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.record(p)
+}
+
+func (b *Buffer) record(p models.WatchProgress) {
+	if b.pending == nil {
+		b.pending = map[key]models.WatchProgress{}
+	}
+
+	k := key{p.UserID, p.MovieID}
+	if current, ok := b.pending[k]; ok && current.UpdatedAt.After(p.UpdatedAt) {
+		return
+	}
+	b.pending[k] = p
+}
+
+// Get returns the user's position in the movie if it is waiting to be saved
+func (b *Buffer) Get(userID, movieID int) (models.WatchProgress, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.get(key{userID, movieID})
+}
+
+func (b *Buffer) get(k key) (models.WatchProgress, bool) {
+	if p, ok := b.pending[k]; ok {
+		return p, true
+	}
+	if _, ok := b.forgotten[k]; ok {
+		return models.WatchProgress{}, false
+	}
+	p, ok := b.saving[k]
+	return p, ok
+}
+
+// Pending returns the user's positions that are waiting to be saved
+func (b *Buffer) Pending(userID int) []models.WatchProgress {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var progress []models.WatchProgress
+	for k := range b.waiting() {
+		if k.userID == userID {
+			p, _ := b.get(k)
+			progress = append(progress, p)
+		}
+	}
+	return progress
+}
+
+// Forget drops the user's position in the movie, eg. once they have finished it. If it is being saved
+// right now, it isn't put back should that fail
+func (b *Buffer) Forget(userID, movieID int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	k := key{userID, movieID}
+	delete(b.pending, k)
+	if _, ok := b.saving[k]; ok {
+		b.forgotten[k] = struct{}{}
+	}
+}
+
+// Len is how many positions are waiting to be saved
+func (b *Buffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.waiting())
+}
+
+// waiting is every user & movie with a position waiting to be saved, whether it is pending or being saved
+func (b *Buffer) waiting() map[key]struct{} {
+	keys := make(map[key]struct{}, len(b.pending)+len(b.saving))
+	for k := range b.pending {
+		keys[k] = struct{}{}
+	}
+	for k := range b.saving {
+		if _, ok := b.forgotten[k]; !ok {
+			keys[k] = struct{}{}
+		}
+	}
+	return keys
+}
+
+// Flush saves every position waiting to be saved. If Save fails they are put back, unless newer ones
+// have arrived or they have been forgotten in the meantime, to be tried again on the next flush
+func (b *Buffer) Flush() error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	batch := b.pending
+	if len(batch) == 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	b.pending = nil
+	b.saving, b.forgotten = batch, map[key]struct{}{}
+	b.mu.Unlock()
+
+	progress := make([]models.WatchProgress, 0, len(batch))
+	for _, p := range batch {
+		progress = append(progress, p)
+	}
+
+	err := b.Save(progress)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err != nil {
+		for k, p := range batch {
+			if _, ok := b.forgotten[k]; !ok {
+				b.record(p)
+			}
+		}
+	}
+	b.saving, b.forgotten = nil, nil
+
+	return err
+}
+
+// Run flushes every interval until ctx is done, then flushes one last time
+func (b *Buffer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := b.Flush(); err != nil {
+				log.Println("saving playback positions:", err)
+			}
+			return
+		case <-ticker.C:
+			if err := b.Flush(); err != nil {
+				log.Println("saving playback positions:", err)
+			}
+		}
+	}
+}
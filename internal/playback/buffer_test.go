@@ -0,0 +1,127 @@
+package playback
+
+import (
+	"backend/internal/models"
+	"errors"
+	"testing"
+	"time"
+)
+
+func position(movieID, seconds int, at time.Time) models.WatchProgress {
+	return models.WatchProgress{UserID: 1, MovieID: movieID, PositionSeconds: seconds, UpdatedAt: at}
+}
+
+// blockingSave returns a Save that waits for release before returning err, and a channel that says
+// when it has been called
+func blockingSave(err error) (save func([]models.WatchProgress) error, called, release chan struct{}) {
+	called, release = make(chan struct{}), make(chan struct{})
+	save = func([]models.WatchProgress) error {
+		close(called)
+		<-release
+		return err
+	}
+	return save, called, release
+}
+
+func TestBufferKeepsNewestPosition(t *testing.T) {
+	now := time.Now()
+	var b Buffer
+
+	b.Record(position(1, 200, now))
+	b.Record(position(1, 100, now.Add(-time.Second)))
+
+	p, ok := b.Get(1, 1)
+	if !ok || p.PositionSeconds != 200 {
+		t.Errorf("got %d %v, want 200", p.PositionSeconds, ok)
+	}
+}
+
+func TestBufferSavingIsStillPending(t *testing.T) {
+	save, called, release := blockingSave(nil)
+	b := Buffer{Save: save}
+	b.Record(position(1, 100, time.Now()))
+
+	done := make(chan error)
+	go func() { done <- b.Flush() }()
+	<-called
+
+	if p, ok := b.Get(1, 1); !ok || p.PositionSeconds != 100 {
+		t.Errorf("Get while saving: got %d %v, want 100", p.PositionSeconds, ok)
+	}
+	if pending := b.Pending(1); len(pending) != 1 {
+		t.Errorf("Pending while saving: got %d positions, want 1", len(pending))
+	}
+	if b.Len() != 1 {
+		t.Errorf("Len while saving: got %d, want 1", b.Len())
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := b.Get(1, 1); ok {
+		t.Error("still pending once saved")
+	}
+	if b.Len() != 0 {
+		t.Errorf("Len once saved: got %d, want 0", b.Len())
+	}
+}
+
+func TestBufferFailedSaveIsRetried(t *testing.T) {
+	now := time.Now()
+	save, called, release := blockingSave(errors.New("database is down"))
+	b := Buffer{Save: save}
+	b.Record(position(1, 100, now))
+	b.Record(position(2, 100, now))
+
+	done := make(chan error)
+	go func() { done <- b.Flush() }()
+	<-called
+
+	// a newer position for movie 2 arrives while the batch is being saved
+	b.Record(position(2, 150, now.Add(time.Second)))
+
+	close(release)
+	if err := <-done; err == nil {
+		t.Fatal("expected the save to fail")
+	}
+
+	if p, ok := b.Get(1, 1); !ok || p.PositionSeconds != 100 {
+		t.Errorf("movie 1: got %d %v, want 100", p.PositionSeconds, ok)
+	}
+	if p, ok := b.Get(1, 2); !ok || p.PositionSeconds != 150 {
+		t.Errorf("movie 2: got %d %v, want the newer 150", p.PositionSeconds, ok)
+	}
+}
+
+func TestBufferForgetWhileSaving(t *testing.T) {
+	save, called, release := blockingSave(errors.New("database is down"))
+	b := Buffer{Save: save}
+	b.Record(position(1, 100, time.Now()))
+
+	done := make(chan error)
+	go func() { done <- b.Flush() }()
+	<-called
+
+	b.Forget(1, 1)
+
+	if _, ok := b.Get(1, 1); ok {
+		t.Error("forgotten position is still pending while saving")
+	}
+	if pending := b.Pending(1); len(pending) != 0 {
+		t.Errorf("Pending: got %d positions, want 0", len(pending))
+	}
+
+	close(release)
+	if err := <-done; err == nil {
+		t.Fatal("expected the save to fail")
+	}
+
+	if _, ok := b.Get(1, 1); ok {
+		t.Error("failed save put a forgotten position back")
+	}
+	if b.Len() != 0 {
+		t.Errorf("Len: got %d, want 0", b.Len())
+	}
+}
@@ -0,0 +1,188 @@
+// Package recommend finds movies like another one from what they have in common: genres, cast & crew,
+// when they came out and their MPAA rating. Each counts for as much as its weight. An Index is read only
+// once built, so it is safe to share between requests, and its answers only depend on the catalogue &
+// the weights, so the same catalogue always gets the same recommendations.
+package recommend
+
+import (
+	"backend/internal/models"
+	"errors"
+	"math"
+	"sort"
+)
+
+// Weights say how much each thing two movies share counts towards their score. Only their sizes
+// relative to each other matter. EraYears is how many years apart two movies can come out before
+// their release dates stop counting at all
+type Weights struct {
+	Genres   float64
+	People   float64
+	Era      float64
+	Rating   float64
+	EraYears float64
+}
+
+// DefaultWeights make genres count the most, then shared cast & crew
+var DefaultWeights = Weights{Genres: 3, People: 2, Era: 1, Rating: 0.5, EraYears: 10}
+
+// Validate checks the weights can score anything
+func (w Weights) Validate() error {
+	if w.Genres < 0 || w.People < 0 || w.Era < 0 || w.Rating < 0 {
+		return errors.New("weights can't be negative")
+	}
+	if w.Genres+w.People+w.Era+w.Rating == 0 {
+		return errors.New("at least one weight must be more than 0")
+	}
+	if w.EraYears <= 0 {
+		return errors.New("era years must be more than 0")
+	}
+	return nil
+}
+
+// mpaaOrder puts the ratings from the most to the least suitable for children. Neighbours are half alike
+var mpaaOrder = map[string]int{"G": 0, "PG": 1, "PG-13": 2, "R": 3, "NC-17": 4}
+
+type entry struct {
+	movie   *models.Movie
+	genres  map[int]struct{}
+	people  map[int]struct{}
+	year    float64
+	hasYear bool
+}
+
+// Index scores every movie in a catalogue against the others
+type Index struct {
+	weights Weights
+	entries []entry
+	byID    map[int]int
+}
+
+// New indexes a catalogue, to be scored with w. w must be valid
+func New(movies []*models.MovieFeatures, w Weights) *Index {
+	ix := &Index{weights: w, byID: make(map[int]int, len(movies))}
+
+	for _, m := range movies {
+		e := entry{movie: m.Movie, genres: set(m.GenreIDs), people: set(m.PersonIDs)}
+		if !m.Movie.ReleaseDate.IsZero() {
+			e.year = float64(m.Movie.ReleaseDate.Unix()) / (365.25 * 24 * 60 * 60)
+			e.hasYear = true
+		}
+		ix.entries = append(ix.entries, e)
+		ix.byID[m.Movie.ID] = len(ix.entries) - 1
+	}
+
+	return ix
+}
+
+// Len is the number of movies in the index
+func (ix *Index) Len() int {
+	return len(ix.entries)
+}
+
+// Similar returns up to limit movies like the one with movieID, most alike first. Ties go to the lower
+// id. Only movies that share a genre or someone in their cast & crew are similar at all: a release date
+// & rating in common aren't enough. It returns nil if the movie isn't in the index
+func (ix *Index) Similar(movieID, limit int) []*models.SimilarMovie {
+	i, ok := ix.byID[movieID]
+	if !ok || limit < 1 {
+		return nil
+	}
+	target := ix.entries[i]
+
+	var similar []*models.SimilarMovie
+	for j, other := range ix.entries {
+		if j == i {
+			continue
+		}
+		score, related := ix.score(target, other)
+		if !related {
+			continue
+		}
+		similar = append(similar, &models.SimilarMovie{Movie: other.movie, Score: score})
+	}
+
+	sort.Slice(similar, func(a, b int) bool {
+		if similar[a].Score != similar[b].Score {
+			return similar[a].Score > similar[b].Score
+		}
+		return similar[a].Movie.ID < similar[b].Movie.ID
+	})
+
+	if len(similar) > limit {
+		similar = similar[:limit]
+	}
+	return similar
+}
+
+// score weighs up what two movies have in common, from 0 to 1. related is false if they share neither
+// a genre nor a person
+func (ix *Index) score(a, b entry) (score float64, related bool) {
+	w := ix.weights
+
+	sharedGenres := shared(a.genres, b.genres)
+	sharedPeople := shared(a.people, b.people)
+	if sharedGenres == 0 && sharedPeople == 0 {
+		return 0, false
+	}
+
+	var total float64
+
+	// genres by how much of both movies' genres they share, so a movie isn't like everything just
+	// because it has many genres
+	if union := len(a.genres) + len(b.genres) - sharedGenres; union > 0 {
+		total += w.Genres * float64(sharedGenres) / float64(union)
+	}
+
+	// people by how much of the smaller cast & crew is shared, so a big cast doesn't drown out a
+	// director they have in common
+	if smaller := min(len(a.people), len(b.people)); smaller > 0 {
+		total += w.People * float64(sharedPeople) / float64(smaller)
+	}
+
+	if a.hasYear && b.hasYear {
+		total += w.Era * math.Max(0, 1-math.Abs(a.year-b.year)/w.EraYears)
+	}
+
+	total += w.Rating * ratingAlike(a.movie.MPAARating, b.movie.MPAARating)
+
+	// rounded, so floating point noise can't reorder movies that are equally alike
+	score = total / (w.Genres + w.People + w.Era + w.Rating)
+	return math.Round(score*10000) / 10000, true
+}
+
+// ratingAlike is 1 for the same MPAA rating, 0.5 for neighbouring ones & 0 otherwise
+func ratingAlike(a, b string) float64 {
+	if a == "" || b == "" {
+		return 0
+	}
+	if a == b {
+		return 1
+	}
+	i, iok := mpaaOrder[a]
+	j, jok := mpaaOrder[b]
+	if iok && jok && (i-j == 1 || j-i == 1) {
+		return 0.5
+	}
+	return 0
+}
+
+func set(ids []int) map[int]struct{} {
+	s := make(map[int]struct{}, len(ids))
+	for _, id := range ids {
+		s[id] = struct{}{}
+	}
+	return s
+}
+
+func shared(a, b map[int]struct{}) int {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	n := 0
+	for id := range a {
+		if _, ok := b[id]; ok {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,104 @@
+package recommend
+
+import (
+	"backend/internal/models"
+	"testing"
+	"time"
+)
+
+// year is a year's worth of seconds, as the index counts them
+const year = 365.25 * 24 * 60 * 60
+
+func movie(id int, genres, people []int, released time.Time, rating string) *models.MovieFeatures {
+	return &models.MovieFeatures{
+		Movie:     &models.Movie{ID: id, ReleaseDate: released, MPAARating: rating},
+		GenreIDs:  genres,
+		PersonIDs: people,
+	}
+}
+
+// catalogue is scored against movie 1
+func catalogue() []*models.MovieFeatures {
+	released := time.Unix(0, 0)
+	fiveYearsLater := time.Unix(5*year, 0)
+
+	return []*models.MovieFeatures{
+		movie(1, []int{1, 2}, []int{10, 11}, released, "PG-13"),
+		// everything in common
+		movie(2, []int{1, 2}, []int{10, 11}, released, "PG-13"),
+		// half the genres, five years apart & a neighbouring rating
+		movie(3, []int{1}, nil, fiveYearsLater, "R"),
+		// only one of the seed's two people
+		movie(4, []int{3}, []int{10, 12, 13}, time.Time{}, ""),
+		// same release date & rating, but no genre or person in common
+		movie(5, []int{3}, nil, released, "PG-13"),
+		// equally alike, listed out of order to check ties go to the lower id
+		movie(7, []int{2}, nil, time.Time{}, "G"),
+		movie(6, []int{2}, nil, time.Time{}, "G"),
+	}
+}
+
+type scored struct {
+	id    int
+	score float64
+}
+
+func TestSimilar(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights Weights
+		want    []scored
+	}{
+		{
+			name:    "default weights",
+			weights: DefaultWeights,
+			// out of 6.5: 2 scores 3 + 2 + 1 + 0.5, 3 scores 1.5 + 0.5 + 0.25, 4 scores 1 and 6 & 7 1.5
+			want: []scored{{2, 1}, {3, 0.3462}, {6, 0.2308}, {7, 0.2308}, {4, 0.1538}},
+		},
+		{
+			name:    "people only",
+			weights: Weights{People: 1, EraYears: 10},
+			// movies sharing only a genre are still similar, just not at all alike
+			want: []scored{{2, 1}, {4, 0.5}, {3, 0}, {6, 0}, {7, 0}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ix := New(catalogue(), tt.weights)
+
+			similar := ix.Similar(1, 10)
+
+			var got []scored
+			for _, s := range similar {
+				got = append(got, scored{s.Movie.ID, s.Score})
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSimilarLimit(t *testing.T) {
+	ix := New(catalogue(), DefaultWeights)
+
+	similar := ix.Similar(1, 2)
+	if len(similar) != 2 || similar[0].Movie.ID != 2 || similar[1].Movie.ID != 3 {
+		t.Errorf("got %d movies, want the best 2", len(similar))
+	}
+}
+
+func TestSimilarUnknownMovie(t *testing.T) {
+	ix := New(catalogue(), DefaultWeights)
+
+	if similar := ix.Similar(99, 10); similar != nil {
+		t.Errorf("got %d movies for a movie that isn't in the index", len(similar))
+	}
+}
@@ -0,0 +1,110 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"context"
+	"strings"
+	"time"
+)
+
+// AllUsers returns a page of users matching the query, ordered by email, plus how many users match in total
+func (m *PostgresDBRepo) AllUsers(q models.UserQuery) ([]*models.User, int, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	// escape LIKE's wildcards, so searching for "a_b" doesn't also match "axb"
+	search := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.TrimSpace(q.Search)) + "%"
+
+	where := `
+		WHERE ($1 = '%' OR email ILIKE $1 OR first_name ILIKE $1 OR last_name ILIKE $1)
+		AND ($2 = '' OR role = $2)
+	`
+
+	var total int
+	err := m.DB.QueryRowContext(context, `SELECT count(*) FROM users `+where, search, q.Role).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT ` + userColumns + ` FROM users ` + where + ` ORDER BY email LIMIT $3 OFFSET $4`
+
+	rows, err := m.DB.QueryContext(context, query, search, q.Role, q.PageSize, (q.Page-1)*q.PageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var users []*models.User
+
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, user)
+	}
+
+	return users, total, rows.Err()
+}
+
+func (m *PostgresDBRepo) SetUserRole(id int, role string) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `UPDATE users SET role = $1, updated_at = $2 WHERE id = $3`
+
+	_, err := m.DB.ExecContext(context, stmt, role, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *PostgresDBRepo) SetUserDisabled(id int, disabled bool) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `UPDATE users SET disabled_at = CASE WHEN $1 THEN coalesce(disabled_at, $2) END, updated_at = $2 WHERE id = $3`
+
+	_, err := m.DB.ExecContext(context, stmt, disabled, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteUserReassign hands what the user made for others over to another user, then deletes them:
+// their API keys and their public & unlisted lists (other than their watchlist), which keep their slugs
+// so shared links keep working. What is personal to them goes with them rather than being put in
+// someone else's name: their private lists, reviews & ratings, helpful votes & reports, watchlist,
+// watch history & progress, and their sessions, tokens, identities & recovery codes. Moderation
+// decisions & audit events keep their id
+func (m *PostgresDBRepo) DeleteUserReassign(id, reassignTo int) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(context, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(context, `UPDATE api_keys SET created_by = $1 WHERE created_by = $2`, reassignTo, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(context, `UPDATE lists SET user_id = $1 WHERE user_id = $2 AND NOT watchlist AND visibility IN ('public', 'unlisted')`, reassignTo, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(context, `DELETE FROM users WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
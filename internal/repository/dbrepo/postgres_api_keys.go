@@ -0,0 +1,148 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"context"
+	"strings"
+	"time"
+)
+
+// apiKeyLastUsedResolution stops us writing last_used_at on every single request made with a key
+const apiKeyLastUsedResolution = time.Minute
+
+const apiKeyColumns = `id, name, prefix, key_hash, scopes, created_by, expires_at, last_used_at, revoked_at, created_at`
+
+// scopes are stored as a comma separated list
+func scanAPIKey(row rowScanner) (*models.APIKey, error) {
+	var key models.APIKey
+	var scopes string
+
+	err := row.Scan(
+		&key.ID,
+		&key.Name,
+		&key.Prefix,
+		&key.KeyHash,
+		&scopes,
+		&key.CreatedBy,
+		&key.ExpiresAt,
+		&key.LastUsedAt,
+		&key.RevokedAt,
+		&key.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Scopes = []string{}
+	if scopes != "" {
+		key.Scopes = strings.Split(scopes, ",")
+	}
+
+	return &key, nil
+}
+
+func (m *PostgresDBRepo) InsertAPIKey(key models.APIKey) (int, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var expiresAt *time.Time
+	if key.ExpiresAt != nil {
+		t := key.ExpiresAt.UTC()
+		expiresAt = &t
+	}
+
+	stmt := `INSERT INTO api_keys (name, prefix, key_hash, scopes, created_by, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	var newID int
+
+	err := m.DB.QueryRowContext(context, stmt,
+		key.Name,
+		key.Prefix,
+		key.KeyHash,
+		strings.Join(key.Scopes, ","),
+		key.CreatedBy,
+		expiresAt,
+		time.Now().UTC(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+func (m *PostgresDBRepo) GetAPIKey(id int) (*models.APIKey, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE id = $1`
+
+	return scanAPIKey(m.DB.QueryRowContext(context, query, id))
+}
+
+func (m *PostgresDBRepo) GetAPIKeyByHash(hash string) (*models.APIKey, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE key_hash = $1`
+
+	return scanAPIKey(m.DB.QueryRowContext(context, query, hash))
+}
+
+func (m *PostgresDBRepo) AllAPIKeys() ([]*models.APIKey, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys ORDER BY created_at DESC`
+
+	rows, err := m.DB.QueryContext(context, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*models.APIKey
+
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (m *PostgresDBRepo) RevokeAPIKey(id int) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `UPDATE api_keys SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL`
+
+	_, err := m.DB.ExecContext(context, stmt, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// TouchAPIKey records that the key was just used
+func (m *PostgresDBRepo) TouchAPIKey(id int) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	now := time.Now().UTC()
+
+	stmt := `UPDATE api_keys SET last_used_at = $1
+		WHERE id = $2 AND (last_used_at IS NULL OR last_used_at < $3)`
+
+	_, err := m.DB.ExecContext(context, stmt, now, id, now.Add(-apiKeyLastUsedResolution))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"context"
+	"time"
+)
+
+func (m *PostgresDBRepo) InsertAuditEvent(event models.AuditEvent) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `INSERT INTO audit_events (actor_id, api_key_id, action, entity_type, entity_id, before, after, request_id, ip, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+
+	_, err := m.DB.ExecContext(context, stmt,
+		event.ActorID,
+		event.APIKeyID,
+		event.Action,
+		event.EntityType,
+		event.EntityID,
+		jsonOrNull(event.Before),
+		jsonOrNull(event.After),
+		event.RequestID,
+		event.IP,
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AllAuditEvents returns a page of audit events matching the query, newest first, plus how many match in total
+func (m *PostgresDBRepo) AllAuditEvents(q models.AuditQuery) ([]*models.AuditEvent, int, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	where := `
+		WHERE ($1 = 0 OR actor_id = $1)
+		AND ($2 = '' OR entity_type = $2)
+		AND ($3 = '' OR entity_id = $3)
+		AND ($4::timestamp IS NULL OR created_at >= $4)
+		AND ($5::timestamp IS NULL OR created_at < $5)
+	`
+	args := []any{q.ActorID, q.EntityType, q.EntityID, timeOrNull(q.From), timeOrNull(q.To)}
+
+	var total int
+	err := m.DB.QueryRowContext(context, `SELECT count(*) FROM audit_events `+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+		SELECT id, actor_id, api_key_id, action, entity_type, entity_id, before, after, request_id, ip, created_at
+		FROM audit_events ` + where + `
+		ORDER BY id DESC
+		LIMIT $6 OFFSET $7
+	`
+
+	rows, err := m.DB.QueryContext(context, query, append(args, q.PageSize, (q.Page-1)*q.PageSize)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var events []*models.AuditEvent
+
+	for rows.Next() {
+		var event models.AuditEvent
+		var before, after []byte
+		err := rows.Scan(
+			&event.ID,
+			&event.ActorID,
+			&event.APIKeyID,
+			&event.Action,
+			&event.EntityType,
+			&event.EntityID,
+			&before,
+			&after,
+			&event.RequestID,
+			&event.IP,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		event.Before = before
+		event.After = after
+		events = append(events, &event)
+	}
+
+	return events, total, rows.Err()
+}
+
+// jsonOrNull stores empty JSON as NULL
+func jsonOrNull(data []byte) any {
+	if len(data) == 0 {
+		return nil
+	}
+	return string(data)
+}
+
+// timeOrNull turns the zero time into NULL, for optional filters
+func timeOrNull(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC()
+}
@@ -2,95 +2,44 @@ package dbrepo
 
 import (
 	"backend/internal/models"
+	"backend/internal/password"
+	"backend/internal/repository"
 	"context"
 	"database/sql"
-	"fmt"
+	"errors"
 	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 type PostgresDBRepo struct {
 	DB *sql.DB
+	// Hasher hashes passwords in InsertUser & UpdatePassword. The zero value hashes with argon2id
+	Hasher password.Hasher
 }
 
 // its a good practice to always timeout DB connection sessions
 const dbTimeout = time.Second * 3
 
-func (m *PostgresDBRepo) Connection() *sql.DB {
-	return m.DB
-}
-
-func (m *PostgresDBRepo) AllMovies(genre ...int) ([]*models.Movie, error) {
-	// NOTES: Here is how u set a timeout on a db connection session
-	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
-
-	where := ""
-	if len(genre) > 0 {
-		where = fmt.Sprintf("WHERE id IN (SELECT movie_id FROM movies_genres WHERE genre_id = %d)", genre[0])
-	}
-
-	query := fmt.Sprintf(`
-		SELECT id, title, release_date, runtime, mpaa_rating, description, coalesce(image, ''), created_at, updated_at
-		FROM movies %s
-		ORDER BY title
-	`, where)
-
-	rows, err := m.DB.QueryContext(context, query)
-	if err != nil {
-		return nil, err
-	}
-	// NOTES: always close rows
-	defer rows.Close()
-
-	var movies []*models.Movie
-
-	for rows.Next() {
-		var movie models.Movie
-		// NOTES: when u scan returned query recs, u must scan em in same order as they are in the query
-		err := rows.Scan(
-			&movie.ID,
-			&movie.Title,
-			&movie.ReleaseDate,
-			&movie.RunTime,
-			&movie.MPAARating,
-			&movie.Description,
-			&movie.Image,
-			&movie.CreatedAt,
-			&movie.UpdatedField,
-		)
-		if err != nil {
-			return nil, err
-		}
-
-		movies = append(movies, &movie)
+// isUniqueViolation reports whether err is a Postgres unique constraint violation on the named constraint
+func isUniqueViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "23505" && pgErr.ConstraintName == constraint
 	}
+	return false
+}
 
-	return movies, nil
+func (m *PostgresDBRepo) Connection() *sql.DB {
+	return m.DB
 }
 
 func (m *PostgresDBRepo) OneMovie(id int) (*models.Movie, error) {
 	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `
-		SELECT id, title, release_date, runtime, mpaa_rating, description, coalesce(image, ''), created_at, updated_at
-		FROM movies
-		WHERE id = $1
-	`
-	row := m.DB.QueryRowContext(context, query, id)
-	var movie models.Movie
-
-	err := row.Scan(
-		&movie.ID,
-		&movie.Title,
-		&movie.ReleaseDate,
-		&movie.RunTime,
-		&movie.MPAARating,
-		&movie.Description,
-		&movie.Image,
-		&movie.CreatedAt,
-		&movie.UpdatedField,
-	)
+	query := `SELECT ` + movieColumns + ` FROM movies WHERE id = $1`
+	movie, err := scanMovie(m.DB.QueryRowContext(context, query, id))
 	if err != nil {
 		return nil, err
 	}
@@ -126,32 +75,15 @@ func (m *PostgresDBRepo) OneMovie(id int) (*models.Movie, error) {
 
 	movie.Genres = genres
 
-	return &movie, err
+	return movie, err
 }
 
 func (m *PostgresDBRepo) OneMovieForEdit(id int) (*models.Movie, []*models.Genre, error) {
 	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `
-		SELECT id, title, release_date, runtime, mpaa_rating, description, coalesce(image, ''), created_at, updated_at
-		FROM movies
-		WHERE id = $1
-	`
-	row := m.DB.QueryRowContext(context, query, id)
-	var movie models.Movie
-
-	err := row.Scan(
-		&movie.ID,
-		&movie.Title,
-		&movie.ReleaseDate,
-		&movie.RunTime,
-		&movie.MPAARating,
-		&movie.Description,
-		&movie.Image,
-		&movie.CreatedAt,
-		&movie.UpdatedField,
-	)
+	query := `SELECT ` + movieColumns + ` FROM movies WHERE id = $1`
+	movie, err := scanMovie(m.DB.QueryRowContext(context, query, id))
 	if err != nil {
 		return nil, nil, err
 	}
@@ -212,21 +144,21 @@ func (m *PostgresDBRepo) OneMovieForEdit(id int) (*models.Movie, []*models.Genre
 		allGenres = append(allGenres, &g)
 	}
 
-	return &movie, allGenres, err
+	return movie, allGenres, err
 }
 
-func (m *PostgresDBRepo) GetUserByEmail(email string) (*models.User, error) {
-	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
+// userColumns are the columns scanUser expects, in order
+const userColumns = `id, email, first_name, last_name, password, role, email_verified_at,
+	coalesce(totp_secret, ''), totp_enabled_at, coalesce(totp_last_step, 0), disabled_at, shadow_hidden_at, created_at, updated_at`
 
-	query := `
-		SELECT id, email, first_name, last_name, password, created_at, updated_at
-		FROM users 
-		WHERE email = $1
-	`
+// rowScanner is satisfied by both *sql.Row & *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
 
+// scanUser reads a user selected with userColumns
+func scanUser(row rowScanner) (*models.User, error) {
 	var user models.User
-	row := m.DB.QueryRowContext(context, query, email)
 
 	err := row.Scan(
 		&user.ID,
@@ -234,6 +166,13 @@ func (m *PostgresDBRepo) GetUserByEmail(email string) (*models.User, error) {
 		&user.FirstName,
 		&user.LastName,
 		&user.Password,
+		&user.Role,
+		&user.EmailVerifiedAt,
+		&user.TOTPSecret,
+		&user.TOTPEnabledAt,
+		&user.TOTPLastStep,
+		&user.DisabledAt,
+		&user.ShadowHiddenAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -244,33 +183,129 @@ func (m *PostgresDBRepo) GetUserByEmail(email string) (*models.User, error) {
 	return &user, nil
 }
 
+func (m *PostgresDBRepo) GetUserByEmail(email string) (*models.User, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT ` + userColumns + ` FROM users WHERE email = $1`
+
+	row := m.DB.QueryRowContext(context, query, email)
+	return scanUser(row)
+}
+
 func (m *PostgresDBRepo) GetUserById(id int) (*models.User, error) {
 	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `
-		SELECT id, email, first_name, last_name, password, created_at, updated_at
-		FROM users 
-		WHERE id = $1
-	`
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
 
-	var user models.User
 	row := m.DB.QueryRowContext(context, query, id)
+	return scanUser(row)
+}
 
-	err := row.Scan(
-		&user.ID,
-		&user.Email,
-		&user.FirstName,
-		&user.LastName,
-		&user.Password,
-		&user.CreatedAt,
-		&user.UpdatedAt,
+// InsertUser hashes the user's plain text password with m.Hasher & saves the user, returning the new id.
+// Users are created as viewers unless another role is set on the user
+func (m *PostgresDBRepo) InsertUser(user models.User) (int, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if user.Role == "" {
+		user.Role = models.RoleViewer
+	}
+
+	hashedPassword, err := m.Hasher.Hash(user.Password)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `INSERT INTO users (first_name, last_name, email, password, role, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	var newID int
+
+	err = m.DB.QueryRowContext(context, stmt,
+		user.FirstName,
+		user.LastName,
+		user.Email,
+		hashedPassword,
+		user.Role,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		if isUniqueViolation(err, "users_email_key") {
+			return 0, repository.ErrDuplicateEmail
+		}
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateUser updates a user's profile fields. Passwords are changed separately with UpdatePassword.
+// Changing the email address means it has to be verified again
+func (m *PostgresDBRepo) UpdateUser(user models.User) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `
+		UPDATE users SET first_name = $1,
+		last_name = $2,
+		email_verified_at = CASE WHEN email = $3 THEN email_verified_at ELSE NULL END,
+		email = $3,
+		updated_at = $4
+		WHERE id = $5`
+
+	_, err := m.DB.ExecContext(context, stmt,
+		user.FirstName,
+		user.LastName,
+		user.Email,
+		time.Now(),
+		user.ID,
 	)
 	if err != nil {
-		return nil, err
+		if isUniqueViolation(err, "users_email_key") {
+			return repository.ErrDuplicateEmail
+		}
+		return err
 	}
 
-	return &user, nil
+	return nil
+}
+
+// UpdatePassword hashes the given plain text password with m.Hasher & stores it against the user
+func (m *PostgresDBRepo) UpdatePassword(id int, plainText string) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	hashedPassword, err := m.Hasher.Hash(plainText)
+	if err != nil {
+		return err
+	}
+
+	stmt := `UPDATE users SET password = $1, updated_at = $2 WHERE id = $3`
+
+	_, err = m.DB.ExecContext(context, stmt, hashedPassword, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *PostgresDBRepo) DeleteUser(id int) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `DELETE FROM users WHERE id = $1`
+
+	_, err := m.DB.ExecContext(context, stmt, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
 }
 
 func (m *PostgresDBRepo) AllGenres() ([]*models.Genre, error) {
@@ -387,16 +422,31 @@ func (m *PostgresDBRepo) UpdateMovieGenres(id int, genreIDs []int) error {
 	return nil
 }
 
+// DeleteMovie deletes a movie. Its list entries would go with it anyway (list_items cascades), but they
+// are taken off here so the lists they were on show up as changed
 func (m *PostgresDBRepo) DeleteMovie(id int) error {
 	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	stmt := `DELETE FROM movies WHERE id = $1`
+	tx, err := m.DB.BeginTx(context, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	_, err := m.DB.ExecContext(context, stmt, id)
+	stmt := `
+		WITH removed AS (DELETE FROM list_items WHERE movie_id = $1 RETURNING list_id)
+		UPDATE lists SET updated_at = $2 WHERE id IN (SELECT list_id FROM removed)`
+
+	_, err = tx.ExecContext(context, stmt, id, time.Now().UTC())
 	if err != nil {
 		return err
 	}
 
-	return nil
+	_, err = tx.ExecContext(context, `DELETE FROM movies WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
@@ -0,0 +1,35 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"context"
+	"time"
+)
+
+func (m *PostgresDBRepo) GetUserByIdentity(issuer, subject string) (*models.User, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT ` + userColumns + ` FROM users
+		WHERE id = (SELECT user_id FROM user_identities WHERE issuer = $1 AND subject = $2)`
+
+	row := m.DB.QueryRowContext(context, query, issuer, subject)
+	return scanUser(row)
+}
+
+// LinkIdentity lets the user log in with an external identity. Linking the same identity twice is a no-op
+func (m *PostgresDBRepo) LinkIdentity(userID int, issuer, subject string) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `INSERT INTO user_identities (user_id, issuer, subject, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (issuer, subject) DO NOTHING`
+
+	_, err := m.DB.ExecContext(context, stmt, userID, issuer, subject, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
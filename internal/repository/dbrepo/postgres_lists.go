@@ -0,0 +1,355 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"time"
+)
+
+// listColumns are the columns scanList expects, in order. They need lists l joined to users u
+const listColumns = `l.id, l.user_id, u.first_name, l.name, l.slug, l.description, l.visibility, l.watchlist,
+	(SELECT count(*) FROM list_items li WHERE li.list_id = l.id), l.created_at, l.updated_at`
+
+// scanList reads a list selected with listColumns
+func scanList(row rowScanner) (*models.List, error) {
+	var list models.List
+	err := row.Scan(
+		&list.ID,
+		&list.UserID,
+		&list.Owner,
+		&list.Name,
+		&list.Slug,
+		&list.Description,
+		&list.Visibility,
+		&list.Watchlist,
+		&list.ItemCount,
+		&list.CreatedAt,
+		&list.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// withExtra scans the columns selected after the ones a scanX helper expects into extra
+type withExtra struct {
+	rowScanner
+	extra []any
+}
+
+func (w withExtra) Scan(dest ...any) error {
+	return w.rowScanner.Scan(append(dest, w.extra...)...)
+}
+
+// AllLists returns a page of lists, see models.ListQuery, plus how many there are in total
+func (m *PostgresDBRepo) AllLists(q models.ListQuery) ([]*models.List, int, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	where, limit := `WHERE l.visibility = 'public'`, `LIMIT $1 OFFSET $2`
+	args := []any{}
+	if q.UserID != 0 {
+		where, limit = `WHERE l.user_id = $1 AND NOT l.watchlist`, `LIMIT $2 OFFSET $3`
+		args = append(args, q.UserID)
+	}
+
+	var total int
+	err := m.DB.QueryRowContext(context, `SELECT count(*) FROM lists l `+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+		SELECT ` + listColumns + `
+		FROM lists l
+		JOIN users u ON (u.id = l.user_id)
+		` + where + `
+		ORDER BY l.updated_at DESC, l.id DESC
+		` + limit
+
+	rows, err := m.DB.QueryContext(context, query, append(args, q.PageSize, (q.Page-1)*q.PageSize)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var lists []*models.List
+
+	for rows.Next() {
+		list, err := scanList(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		lists = append(lists, list)
+	}
+
+	return lists, total, rows.Err()
+}
+
+// GetList returns a list, without its items
+func (m *PostgresDBRepo) GetList(id int) (*models.List, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT ` + listColumns + ` FROM lists l JOIN users u ON (u.id = l.user_id) WHERE l.id = $1`
+
+	return scanList(m.DB.QueryRowContext(context, query, id))
+}
+
+// GetListBySlug returns the list shared by slug, without its items
+func (m *PostgresDBRepo) GetListBySlug(slug string) (*models.List, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT ` + listColumns + ` FROM lists l JOIN users u ON (u.id = l.user_id) WHERE l.slug = $1`
+
+	return scanList(m.DB.QueryRowContext(context, query, slug))
+}
+
+// Watchlist returns the user's watchlist, making it the first time it is asked for. slug is only used
+// when it is made
+func (m *PostgresDBRepo) Watchlist(userID int, slug string) (*models.List, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `
+		INSERT INTO lists (user_id, name, slug, visibility, watchlist, created_at, updated_at)
+		VALUES ($1, 'Watchlist', $2, 'private', true, $3, $3)
+		ON CONFLICT (user_id) WHERE watchlist DO NOTHING`
+
+	_, err := m.DB.ExecContext(context, stmt, userID, slug, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + listColumns + ` FROM lists l JOIN users u ON (u.id = l.user_id) WHERE l.user_id = $1 AND l.watchlist`
+
+	return scanList(m.DB.QueryRowContext(context, query, userID))
+}
+
+// ListItems returns the movies on a list, in order
+func (m *PostgresDBRepo) ListItems(listID int) ([]*models.ListItem, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + movieColumns + `, li.position, li.added_at
+		FROM list_items li
+		JOIN movies ON (movies.id = li.movie_id)
+		WHERE li.list_id = $1
+		ORDER BY li.position, li.added_at
+	`
+
+	rows, err := m.DB.QueryContext(context, query, listID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []*models.ListItem
+
+	for rows.Next() {
+		var item models.ListItem
+		item.Movie, err = scanMovie(withExtra{rows, []any{&item.Position, &item.AddedAt}})
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, &item)
+	}
+
+	return items, rows.Err()
+}
+
+// InsertList makes a new list, returning ErrDuplicateSlug if the slug is taken
+func (m *PostgresDBRepo) InsertList(list models.List) (int, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `INSERT INTO lists (user_id, name, slug, description, visibility, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $6) RETURNING id`
+
+	var newID int
+	err := m.DB.QueryRowContext(context, stmt,
+		list.UserID,
+		list.Name,
+		list.Slug,
+		list.Description,
+		list.Visibility,
+		time.Now().UTC(),
+	).Scan(&newID)
+	if err != nil {
+		if isUniqueViolation(err, "lists_slug_key") {
+			return 0, repository.ErrDuplicateSlug
+		}
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateList changes a list's name, description & visibility. Its slug stays the same, so links to it
+// keep working
+func (m *PostgresDBRepo) UpdateList(list models.List) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `UPDATE lists SET name = $1, description = $2, visibility = $3, updated_at = $4 WHERE id = $5`
+
+	_, err := m.DB.ExecContext(context, stmt,
+		list.Name,
+		list.Description,
+		list.Visibility,
+		time.Now().UTC(),
+		list.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteList deletes a list & its items
+func (m *PostgresDBRepo) DeleteList(id int) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(context, `DELETE FROM lists WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AddListMovie puts a movie at the end of a list. It returns false if it was already on it
+func (m *PostgresDBRepo) AddListMovie(listID, movieID int) (bool, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(context, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+
+	// locking the list keeps two movies added at once from getting the same position
+	_, err = tx.ExecContext(context, `SELECT id FROM lists WHERE id = $1 FOR UPDATE`, listID)
+	if err != nil {
+		return false, err
+	}
+
+	stmt := `
+		INSERT INTO list_items (list_id, movie_id, position, added_at)
+		SELECT $1, $2, coalesce(max(position), 0) + 1, $3 FROM list_items WHERE list_id = $1
+		ON CONFLICT (list_id, movie_id) DO NOTHING`
+
+	result, err := tx.ExecContext(context, stmt, listID, movieID, now)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+
+	_, err = tx.ExecContext(context, `UPDATE lists SET updated_at = $1 WHERE id = $2`, now, listID)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// RemoveListMovie takes a movie off a list. It returns false if it wasn't on it
+func (m *PostgresDBRepo) RemoveListMovie(listID, movieID int) (bool, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(context, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(context, `DELETE FROM list_items WHERE list_id = $1 AND movie_id = $2`, listID, movieID)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+
+	_, err = tx.ExecContext(context, `UPDATE lists SET updated_at = $1 WHERE id = $2`, time.Now().UTC(), listID)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// ReorderList puts a list's movies in the order of movieIDs, which must hold every movie on the list
+// exactly once. Otherwise nothing changes & ErrListOrder is returned
+func (m *PostgresDBRepo) ReorderList(listID int, movieIDs []int) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(context, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(context, `SELECT id FROM lists WHERE id = $1 FOR UPDATE`, listID)
+	if err != nil {
+		return err
+	}
+
+	var count int
+	err = tx.QueryRowContext(context, `SELECT count(*) FROM list_items WHERE list_id = $1`, listID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count != len(movieIDs) {
+		return repository.ErrListOrder
+	}
+
+	stmt := `
+		UPDATE list_items li SET position = o.position
+		FROM unnest($2::integer[]) WITH ORDINALITY AS o(movie_id, position)
+		WHERE li.list_id = $1 AND li.movie_id = o.movie_id`
+
+	result, err := tx.ExecContext(context, stmt, listID, movieIDs)
+	if err != nil {
+		return err
+	}
+
+	// a movie that isn't on the list, or one named twice, leaves some of the list's movies unmoved
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if int(n) != count {
+		return repository.ErrListOrder
+	}
+
+	_, err = tx.ExecContext(context, `UPDATE lists SET updated_at = $1 WHERE id = $2`, time.Now().UTC(), listID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,135 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"context"
+	"time"
+)
+
+func (m *PostgresDBRepo) GetLoginAttempts(key string) (*models.LoginAttempts, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT key, failures, last_failure_at, locked_until FROM login_attempts WHERE key = $1`
+
+	var attempts models.LoginAttempts
+	row := m.DB.QueryRowContext(context, query, key)
+
+	err := row.Scan(
+		&attempts.Key,
+		&attempts.Failures,
+		&attempts.LastFailureAt,
+		&attempts.LockedUntil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &attempts, nil
+}
+
+// RecordLoginFailure counts the failure in a single upsert, so concurrent guesses can't lose updates
+func (m *PostgresDBRepo) RecordLoginFailure(key string, window time.Duration) (int, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	now := time.Now().UTC()
+
+	stmt := `
+		INSERT INTO login_attempts (key, failures, last_failure_at)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failure_at < $3 THEN 1 ELSE login_attempts.failures + 1 END,
+			last_failure_at = $2
+		RETURNING failures
+	`
+
+	var failures int
+	err := m.DB.QueryRowContext(context, stmt, key, now, now.Add(-window)).Scan(&failures)
+	if err != nil {
+		return 0, err
+	}
+
+	return failures, nil
+}
+
+func (m *PostgresDBRepo) LockLogin(key string, until time.Time) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `UPDATE login_attempts SET locked_until = $1 WHERE key = $2`
+
+	_, err := m.DB.ExecContext(context, stmt, until.UTC(), key)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *PostgresDBRepo) ClearLoginAttempts(key string) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `DELETE FROM login_attempts WHERE key = $1`
+
+	_, err := m.DB.ExecContext(context, stmt, key)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *PostgresDBRepo) PruneLoginAttempts(before time.Time) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `
+		DELETE FROM login_attempts
+		WHERE last_failure_at < $1 AND (locked_until IS NULL OR locked_until <= $2)
+	`
+
+	_, err := m.DB.ExecContext(context, stmt, before.UTC(), time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *PostgresDBRepo) AllLoginAttempts() ([]*models.LoginAttempts, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `
+		SELECT key, failures, last_failure_at, locked_until
+		FROM login_attempts
+		ORDER BY last_failure_at DESC
+	`
+
+	rows, err := m.DB.QueryContext(context, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []*models.LoginAttempts
+
+	for rows.Next() {
+		var attempts models.LoginAttempts
+		err := rows.Scan(
+			&attempts.Key,
+			&attempts.Failures,
+			&attempts.LastFailureAt,
+			&attempts.LockedUntil,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, &attempts)
+	}
+
+	return all, nil
+}
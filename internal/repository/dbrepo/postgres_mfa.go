@@ -0,0 +1,136 @@
+package dbrepo
+
+import (
+	"context"
+	"time"
+)
+
+// SetTOTPSecret stores a new, not yet confirmed, TOTP secret for the user. Two-factor authentication
+// stays off until EnableTOTP is called
+func (m *PostgresDBRepo) SetTOTPSecret(userID int, secret string) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `UPDATE users SET totp_secret = $1, totp_enabled_at = NULL, totp_last_step = NULL, updated_at = $2
+		WHERE id = $3`
+
+	_, err := m.DB.ExecContext(context, stmt, secret, time.Now().UTC(), userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// EnableTOTP turns two-factor authentication on once the user has proved their app works.
+// step is the time step of the code they confirmed with, so it can't be used again to log in
+func (m *PostgresDBRepo) EnableTOTP(userID int, step int64) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `UPDATE users SET totp_enabled_at = $1, totp_last_step = $2, updated_at = $1
+		WHERE id = $3 AND totp_secret IS NOT NULL`
+
+	_, err := m.DB.ExecContext(context, stmt, time.Now().UTC(), step, userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DisableTOTP turns two-factor authentication off & throws away the secret & recovery codes
+func (m *PostgresDBRepo) DisableTOTP(userID int) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(context, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL, updated_at = $1
+		WHERE id = $2`
+
+	_, err = tx.ExecContext(context, stmt, time.Now().UTC(), userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(context, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep records that the code for step was used. It returns false if that step (or a later
+// one) was already used, which means the code is being replayed
+func (m *PostgresDBRepo) UseTOTPStep(userID int, step int64) (bool, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `UPDATE users SET totp_last_step = $1
+		WHERE id = $2 AND (totp_last_step IS NULL OR totp_last_step < $1)`
+
+	result, err := m.DB.ExecContext(context, stmt, step, userID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+// ReplaceRecoveryCodes swaps all of a user's recovery codes for the given (hashed) ones
+func (m *PostgresDBRepo) ReplaceRecoveryCodes(userID int, hashes []string) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(context, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(context, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		stmt := `INSERT INTO recovery_codes (user_id, code_hash, created_at) VALUES ($1, $2, $3)`
+		_, err = tx.ExecContext(context, stmt, userID, hash, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UseRecoveryCode uses up one of the user's recovery codes, returning false if it doesn't exist or was used already
+func (m *PostgresDBRepo) UseRecoveryCode(userID int, hash string) (bool, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `UPDATE recovery_codes SET used_at = $1 WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL`
+
+	result, err := m.DB.ExecContext(context, stmt, time.Now().UTC(), userID, hash)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
@@ -0,0 +1,246 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"time"
+)
+
+// ModerationQueue returns a page of the reviews waiting for a moderator, with how many open reports
+// each has, plus how many are waiting in total. The most reported come first, then the oldest
+func (m *PostgresDBRepo) ModerationQueue(q models.ModerationQuery) ([]*models.Review, int, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	statuses := q.Statuses
+	if len(statuses) == 0 {
+		statuses = []string{models.ReviewPending, models.ReviewFlagged}
+	}
+
+	var total int
+	err := m.DB.QueryRowContext(context, `SELECT count(*) FROM reviews WHERE status = ANY($1)`, statuses).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+		SELECT ` + reviewColumns + `, reports.n
+		FROM reviews r
+		JOIN users u ON (u.id = r.user_id)
+		CROSS JOIN LATERAL (
+			SELECT count(*) AS n FROM review_reports rr WHERE rr.review_id = r.id AND rr.resolved_at IS NULL
+		) reports
+		WHERE r.status = ANY($1)
+		ORDER BY reports.n DESC, r.created_at, r.id
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := m.DB.QueryContext(context, query, statuses, q.PageSize, (q.Page-1)*q.PageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var reviews []*models.Review
+
+	for rows.Next() {
+		var review models.Review
+		err := rows.Scan(
+			&review.ID,
+			&review.MovieID,
+			&review.UserID,
+			&review.Author,
+			&review.Rating,
+			&review.Body,
+			&review.HelpfulCount,
+			&review.Status,
+			&review.ShadowHidden,
+			&review.CreatedAt,
+			&review.UpdatedAt,
+			&review.Reports,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		reviews = append(reviews, &review)
+	}
+
+	return reviews, total, rows.Err()
+}
+
+// insertDecision is shared by everything that records a moderation decision
+const insertDecision = `INSERT INTO moderation_decisions (review_id, moderator_id, from_status, to_status, reason, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6)`
+
+// ModerateReview moves a review from decision.FromStatus to decision.ToStatus & records the decision.
+// If the review is no longer in FromStatus nothing changes & ErrReviewStatusChanged is returned, so two
+// moderators can't overrule each other without seeing it. Publishing or rejecting a review closes its
+// open reports
+func (m *PostgresDBRepo) ModerateReview(decision models.ModerationDecision) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(context, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(context, `UPDATE reviews SET status = $1 WHERE id = $2 AND status = $3`,
+		decision.ToStatus, decision.ReviewID, decision.FromStatus)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.ErrReviewStatusChanged
+	}
+
+	now := time.Now().UTC()
+
+	_, err = tx.ExecContext(context, insertDecision,
+		decision.ReviewID,
+		decision.ModeratorID,
+		decision.FromStatus,
+		decision.ToStatus,
+		decision.Reason,
+		now,
+	)
+	if err != nil {
+		return err
+	}
+
+	if decision.ToStatus == models.ReviewPublished || decision.ToStatus == models.ReviewRejected {
+		_, err = tx.ExecContext(context, `UPDATE review_reports SET resolved_at = $1 WHERE review_id = $2 AND resolved_at IS NULL`,
+			now, decision.ReviewID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// InsertModerationDecision records a decision that was made as the review was saved, such as the banned
+// word filter flagging it
+func (m *PostgresDBRepo) InsertModerationDecision(decision models.ModerationDecision) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(context, insertDecision,
+		decision.ReviewID,
+		decision.ModeratorID,
+		decision.FromStatus,
+		decision.ToStatus,
+		decision.Reason,
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ReviewDecisions returns every moderation decision about a review, oldest first
+func (m *PostgresDBRepo) ReviewDecisions(reviewID int) ([]*models.ModerationDecision, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `
+		SELECT id, review_id, moderator_id, from_status, to_status, reason, created_at
+		FROM moderation_decisions
+		WHERE review_id = $1
+		ORDER BY created_at, id
+	`
+
+	rows, err := m.DB.QueryContext(context, query, reviewID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var decisions []*models.ModerationDecision
+
+	for rows.Next() {
+		var decision models.ModerationDecision
+		err := rows.Scan(
+			&decision.ID,
+			&decision.ReviewID,
+			&decision.ModeratorID,
+			&decision.FromStatus,
+			&decision.ToStatus,
+			&decision.Reason,
+			&decision.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		decisions = append(decisions, &decision)
+	}
+
+	return decisions, rows.Err()
+}
+
+// ReportReview records a user's report of a review and returns how many open reports it now has. A
+// user's second report of the same review, before a moderator has looked at it, isn't counted again
+func (m *PostgresDBRepo) ReportReview(report models.ReviewReport) (int, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `
+		INSERT INTO review_reports (review_id, user_id, reason, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (review_id, user_id) WHERE resolved_at IS NULL DO NOTHING`
+
+	_, err := m.DB.ExecContext(context, stmt, report.ReviewID, report.UserID, report.Reason, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	var open int
+	err = m.DB.QueryRowContext(context, `SELECT count(*) FROM review_reports WHERE review_id = $1 AND resolved_at IS NULL`,
+		report.ReviewID).Scan(&open)
+	if err != nil {
+		return 0, err
+	}
+
+	return open, nil
+}
+
+// SetUserShadowHidden hides (or shows again) everything the user has written from everyone but them.
+// Hiding their reviews takes their ratings out of the movies' averages, through the
+// reviews_rating_aggregates trigger
+func (m *PostgresDBRepo) SetUserShadowHidden(userID int, hidden bool) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(context, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var hiddenAt *time.Time
+	if hidden {
+		now := time.Now().UTC()
+		hiddenAt = &now
+	}
+
+	_, err = tx.ExecContext(context, `UPDATE users SET shadow_hidden_at = $1 WHERE id = $2`, hiddenAt, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(context, `UPDATE reviews SET shadow_hidden = $1 WHERE user_id = $2 AND shadow_hidden <> $1`, hidden, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
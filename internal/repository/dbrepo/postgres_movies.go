@@ -0,0 +1,427 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMoviePageSize = 20
+	maxMoviePageSize     = 100
+)
+
+// movieColumns are the columns scanMovie expects, in order
+const movieColumns = `id, title, release_date, runtime, mpaa_rating, description, coalesce(image, ''), created_at, updated_at,
+	coalesce(round(rating_total::numeric / nullif(rating_count, 0), 2), 0)::float8, rating_count`
+
+// searchColumns are the extra columns of a search, see scanSearchResult. They need search_query in the FROM
+const searchColumns = `ts_rank(search_vector, search_query),
+	ts_headline('english', title, search_query, 'HighlightAll=true, StartSel=<mark>, StopSel=</mark>'),
+	ts_headline('english', coalesce(description, ''), search_query, 'MaxWords=35, MinWords=15, MaxFragments=2, StartSel=<mark>, StopSel=</mark>')`
+
+// searchRank is the sort expression for relevance
+const searchRank = `ts_rank(search_vector, search_query)`
+
+// highlightMarks are the tags ts_headline wraps matching words in. Everything else in a headline is
+// escaped, so titles & descriptions can't smuggle HTML into the page
+var highlightMarks = strings.NewReplacer("&lt;mark&gt;", "<mark>", "&lt;/mark&gt;", "</mark>")
+
+func highlight(s string) string {
+	return highlightMarks.Replace(html.EscapeString(s))
+}
+
+func scanMovie(row rowScanner) (*models.Movie, error) {
+	return scanSearchResult(row, false)
+}
+
+// scanSearchResult scans movieColumns, followed by searchColumns when search is true
+func scanSearchResult(row rowScanner, search bool) (*models.Movie, error) {
+	var movie models.Movie
+	dest := []any{
+		&movie.ID,
+		&movie.Title,
+		&movie.ReleaseDate,
+		&movie.RunTime,
+		&movie.MPAARating,
+		&movie.Description,
+		&movie.Image,
+		&movie.CreatedAt,
+		&movie.UpdatedField,
+		&movie.RatingAverage,
+		&movie.RatingCount,
+	}
+	if search {
+		dest = append(dest, &movie.Rank, &movie.Headline, &movie.Snippet)
+	}
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	if search {
+		movie.Headline = highlight(movie.Headline)
+		movie.Snippet = highlight(movie.Snippet)
+	}
+
+	return &movie, nil
+}
+
+// movieCursor is what a keyset pagination cursor holds: the sort order it was made for, and the sort
+// value & id of the movie to carry on from. Backward cursors fetch the page before that movie
+type movieCursor struct {
+	Sort     string `json:"s"`
+	Value    string `json:"v"`
+	ID       int    `json:"id"`
+	Backward bool   `json:"b,omitempty"`
+}
+
+func encodeMovieCursor(sort string, movie *models.Movie, backward bool) string {
+	c := movieCursor{Sort: sort, ID: movie.ID, Backward: backward}
+
+	switch strings.TrimPrefix(sort, "-") {
+	case models.MovieSortRelevance:
+		c.Value = strconv.FormatFloat(float64(movie.Rank), 'g', -1, 32)
+	case models.MovieSortReleaseDate:
+		c.Value = movie.ReleaseDate.Format(time.DateOnly)
+	case models.MovieSortRuntime:
+		c.Value = strconv.Itoa(movie.RunTime)
+	default:
+		c.Value = movie.Title
+	}
+
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeMovieCursor returns the cursor & its sort value, typed to match the sort column
+func decodeMovieCursor(cursor, sort string) (*movieCursor, any, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, nil, repository.ErrInvalidCursor
+	}
+
+	var c movieCursor
+	err = json.Unmarshal(data, &c)
+	if err != nil || c.Sort != sort {
+		return nil, nil, repository.ErrInvalidCursor
+	}
+
+	switch strings.TrimPrefix(sort, "-") {
+	case models.MovieSortRelevance:
+		value, err := strconv.ParseFloat(c.Value, 32)
+		if err != nil {
+			return nil, nil, repository.ErrInvalidCursor
+		}
+		return &c, float32(value), nil
+	case models.MovieSortReleaseDate:
+		value, err := time.Parse(time.DateOnly, c.Value)
+		if err != nil {
+			return nil, nil, repository.ErrInvalidCursor
+		}
+		return &c, value, nil
+	case models.MovieSortRuntime:
+		value, err := strconv.Atoi(c.Value)
+		if err != nil {
+			return nil, nil, repository.ErrInvalidCursor
+		}
+		return &c, value, nil
+	default:
+		return &c, c.Value, nil
+	}
+}
+
+// ListMovies returns a page of the movies matching q, plus how many match in total. Pages can be asked
+// for by number (q.Page) or by a cursor from a previous page (q.Cursor). Cursors stay stable while
+// movies are added & removed, page numbers don't. Ties in the sort order are broken by id.
+// With q.Search, only movies matching the full text search are listed, ranked & highlighted (titles
+// weigh more than descriptions, see the search_vector column)
+func (m *PostgresDBRepo) ListMovies(ctx context.Context, q models.MovieQuery) (*models.MoviePage, error) {
+	context, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	search := strings.TrimSpace(q.Search) != ""
+
+	if q.Sort == "" {
+		q.Sort = models.MovieSortTitle
+		if search {
+			q.Sort = models.MovieSortRelevance
+		}
+	}
+	if !models.ValidMovieSort(q.Sort) {
+		return nil, fmt.Errorf("unknown sort %q", q.Sort)
+	}
+	if q.PageSize < 1 {
+		q.PageSize = defaultMoviePageSize
+	}
+	if q.PageSize > maxMoviePageSize {
+		q.PageSize = maxMoviePageSize
+	}
+	if q.Page < 1 {
+		q.Page = 1
+	}
+
+	column := strings.TrimPrefix(q.Sort, "-")
+	descending := strings.HasPrefix(q.Sort, "-")
+
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	from := "movies"
+	columns := movieColumns
+	var filters []string
+
+	if search {
+		from = "movies, websearch_to_tsquery('english', " + arg(q.Search) + ") search_query"
+		columns += ", " + searchColumns
+		filters = append(filters, "search_vector @@ search_query")
+	}
+
+	// the best matches have the highest rank
+	if column == models.MovieSortRelevance {
+		if !search {
+			return nil, errors.New("sorting by relevance needs a search")
+		}
+		column = searchRank
+		descending = !descending
+	}
+
+	if len(q.Genres) > 0 {
+		filters = append(filters, "id IN (SELECT movie_id FROM movies_genres WHERE genre_id = ANY("+arg(q.Genres)+"))")
+	}
+	if len(q.People) > 0 {
+		filters = append(filters, "id IN (SELECT movie_id FROM movie_credits WHERE person_id = ANY("+arg(q.People)+"))")
+	}
+	if len(q.MPAARatings) > 0 {
+		filters = append(filters, "mpaa_rating = ANY("+arg(q.MPAARatings)+")")
+	}
+	if q.TitleContains != "" {
+		// escape LIKE's wildcards, so searching for "100%" doesn't match everything starting with "100"
+		pattern := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(q.TitleContains)
+		filters = append(filters, "title ILIKE "+arg("%"+pattern+"%"))
+	}
+	if q.YearFrom > 0 {
+		filters = append(filters, "release_date >= "+arg(time.Date(q.YearFrom, time.January, 1, 0, 0, 0, 0, time.UTC)))
+	}
+	if q.YearTo > 0 {
+		filters = append(filters, "release_date < "+arg(time.Date(q.YearTo+1, time.January, 1, 0, 0, 0, 0, time.UTC)))
+	}
+	if q.RuntimeMin > 0 {
+		filters = append(filters, "runtime >= "+arg(q.RuntimeMin))
+	}
+	if q.RuntimeMax > 0 {
+		filters = append(filters, "runtime <= "+arg(q.RuntimeMax))
+	}
+
+	where := ""
+	if len(filters) > 0 {
+		where = "WHERE " + strings.Join(filters, " AND ")
+	}
+
+	var total int
+	err := m.DB.QueryRowContext(context, `SELECT count(*) FROM `+from+` `+where, args...).Scan(&total)
+	if err != nil {
+		return nil, err
+	}
+
+	// walking backwards from a cursor means reading in the opposite order, then flipping the page round
+	var cursor *movieCursor
+	backward := false
+	if q.Cursor != "" {
+		var value any
+		cursor, value, err = decodeMovieCursor(q.Cursor, q.Sort)
+		if err != nil {
+			return nil, err
+		}
+		backward = cursor.Backward
+
+		op := ">"
+		if descending != backward {
+			op = "<"
+		}
+		keyset := fmt.Sprintf("(%s, id) %s (%s, %s)", column, op, arg(value), arg(cursor.ID))
+		if where == "" {
+			where = "WHERE " + keyset
+		} else {
+			where += " AND " + keyset
+		}
+	}
+
+	direction := "ASC"
+	if descending != backward {
+		direction = "DESC"
+	}
+
+	// one extra row tells us whether there's another page after this one
+	query := fmt.Sprintf(`SELECT %s FROM %s %s ORDER BY %s %s, id %s LIMIT %s`,
+		columns, from, where, column, direction, direction, arg(q.PageSize+1))
+	offset := 0
+	if cursor == nil {
+		offset = (q.Page - 1) * q.PageSize
+		query += " OFFSET " + arg(offset)
+	}
+
+	rows, err := m.DB.QueryContext(context, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var movies []*models.Movie
+
+	for rows.Next() {
+		movie, err := scanSearchResult(rows, search)
+		if err != nil {
+			return nil, err
+		}
+		movies = append(movies, movie)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	more := len(movies) > q.PageSize
+	if more {
+		movies = movies[:q.PageSize]
+	}
+	if backward {
+		for i, j := 0, len(movies)-1; i < j; i, j = i+1, j-1 {
+			movies[i], movies[j] = movies[j], movies[i]
+		}
+	}
+
+	page := &models.MoviePage{
+		Movies: movies,
+		Total:  total,
+	}
+
+	if len(movies) > 0 {
+		first, last := movies[0], movies[len(movies)-1]
+
+		// going backwards, there's always the page we came from after this one. Going forwards, there's
+		// always one before, unless this is the very first page
+		if more || backward {
+			page.NextCursor = encodeMovieCursor(q.Sort, last, false)
+		}
+		if (backward && more) || (!backward && (cursor != nil || offset > 0)) {
+			page.PrevCursor = encodeMovieCursor(q.Sort, first, true)
+		}
+	}
+
+	return page, nil
+}
+
+// SuggestTitles returns up to limit movie titles for what the user has typed so far: titles starting
+// with it first, then titles with a word similar to it (pg_trgm's <% operator, so "intersteller" still
+// finds Interstellar). Both ignore case & accents, and are served by the movies_title_trgm_idx index
+func (m *PostgresDBRepo) SuggestTitles(ctx context.Context, prefix string, limit int) ([]*models.Suggestion, error) {
+	context, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `
+		SELECT id, title,
+			CASE WHEN f_unaccent(lower(title)) LIKE f_unaccent(lower($2)) THEN 1
+			ELSE word_similarity(f_unaccent(lower($1)), f_unaccent(lower(title))) END AS score
+		FROM movies
+		WHERE f_unaccent(lower(title)) LIKE f_unaccent(lower($2))
+		OR f_unaccent(lower($1)) <% f_unaccent(lower(title))
+		ORDER BY score DESC, title
+		LIMIT $3
+	`
+
+	// escape LIKE's wildcards, so typing "100%" doesn't match every title starting with "100"
+	pattern := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix) + "%"
+
+	rows, err := m.DB.QueryContext(context, query, prefix, pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var suggestions []*models.Suggestion
+
+	for rows.Next() {
+		var s models.Suggestion
+		err := rows.Scan(&s.ID, &s.Title, &s.Score)
+		if err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, &s)
+	}
+
+	return suggestions, rows.Err()
+}
+
+// MovieFeatures returns every movie with its genre & cast/crew ids, for finding similar movies
+func (m *PostgresDBRepo) MovieFeatures(ctx context.Context) ([]*models.MovieFeatures, error) {
+	rows, err := m.DB.QueryContext(ctx, `SELECT `+movieColumns+` FROM movies ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var features []*models.MovieFeatures
+	byID := map[int]*models.MovieFeatures{}
+
+	for rows.Next() {
+		movie, err := scanMovie(rows)
+		if err != nil {
+			return nil, err
+		}
+		f := &models.MovieFeatures{Movie: movie}
+		features = append(features, f)
+		byID[movie.ID] = f
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// genres & people are read as pairs rather than arrays, and added to their movies here
+	pairs := func(query string, add func(f *models.MovieFeatures, id int)) error {
+		rows, err := m.DB.QueryContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var movieID, id int
+			err := rows.Scan(&movieID, &id)
+			if err != nil {
+				return err
+			}
+			if f, ok := byID[movieID]; ok {
+				add(f, id)
+			}
+		}
+		return rows.Err()
+	}
+
+	err = pairs(`SELECT movie_id, genre_id FROM movies_genres ORDER BY movie_id, genre_id`, func(f *models.MovieFeatures, id int) {
+		f.GenreIDs = append(f.GenreIDs, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = pairs(`SELECT DISTINCT movie_id, person_id FROM movie_credits ORDER BY movie_id, person_id`, func(f *models.MovieFeatures, id int) {
+		f.PersonIDs = append(f.PersonIDs, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return features, nil
+}
@@ -0,0 +1,289 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// personColumns are the columns scanPerson expects, in order
+const personColumns = `id, name, biography, birth_date, image, created_at, updated_at`
+
+// scanPerson reads a person selected with personColumns
+func scanPerson(row rowScanner) (*models.Person, error) {
+	var person models.Person
+	err := row.Scan(
+		&person.ID,
+		&person.Name,
+		&person.Biography,
+		&person.BirthDate,
+		&person.Image,
+		&person.CreatedAt,
+		&person.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &person, nil
+}
+
+// AllPeople returns a page of people matching the query, ordered by name, plus how many match in total
+func (m *PostgresDBRepo) AllPeople(q models.PersonQuery) ([]*models.Person, int, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	// escape LIKE's wildcards, so searching for "a_b" doesn't also match "axb"
+	search := "%" + strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.TrimSpace(q.Search)) + "%"
+
+	where := `WHERE ($1 = '%%' OR name ILIKE $1)`
+
+	var total int
+	err := m.DB.QueryRowContext(context, `SELECT count(*) FROM people `+where, search).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT ` + personColumns + ` FROM people ` + where + ` ORDER BY name, id LIMIT $2 OFFSET $3`
+
+	rows, err := m.DB.QueryContext(context, query, search, q.PageSize, (q.Page-1)*q.PageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var people []*models.Person
+
+	for rows.Next() {
+		person, err := scanPerson(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		people = append(people, person)
+	}
+
+	return people, total, rows.Err()
+}
+
+// GetPerson returns a person with their filmography, newest movie first
+func (m *PostgresDBRepo) GetPerson(id int) (*models.Person, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	row := m.DB.QueryRowContext(context, `SELECT `+personColumns+` FROM people WHERE id = $1`, id)
+	person, err := scanPerson(row)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT c.id, c.movie_id, c.person_id, c.role, coalesce(c.character_name, ''), c.billing_order,
+			m.id, m.title, m.release_date, m.runtime, m.mpaa_rating, m.description, coalesce(m.image, '')
+		FROM movie_credits c
+		JOIN movies m ON (m.id = c.movie_id)
+		WHERE c.person_id = $1
+		ORDER BY m.release_date DESC, m.title, c.billing_order
+	`
+
+	rows, err := m.DB.QueryContext(context, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var credit models.Credit
+		var movie models.Movie
+		err := rows.Scan(
+			&credit.ID,
+			&credit.MovieID,
+			&credit.PersonID,
+			&credit.Role,
+			&credit.Character,
+			&credit.BillingOrder,
+			&movie.ID,
+			&movie.Title,
+			&movie.ReleaseDate,
+			&movie.RunTime,
+			&movie.MPAARating,
+			&movie.Description,
+			&movie.Image,
+		)
+		if err != nil {
+			return nil, err
+		}
+		credit.Movie = &movie
+		person.Filmography = append(person.Filmography, &credit)
+	}
+
+	return person, rows.Err()
+}
+
+func (m *PostgresDBRepo) InsertPerson(person models.Person) (int, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `INSERT INTO people (name, biography, birth_date, image, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
+
+	var newID int
+	err := m.DB.QueryRowContext(context, stmt,
+		person.Name,
+		person.Biography,
+		person.BirthDate,
+		person.Image,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+func (m *PostgresDBRepo) UpdatePerson(person models.Person) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `UPDATE people SET name = $1, biography = $2, birth_date = $3, image = $4, updated_at = $5 WHERE id = $6`
+
+	_, err := m.DB.ExecContext(context, stmt,
+		person.Name,
+		person.Biography,
+		person.BirthDate,
+		person.Image,
+		time.Now(),
+		person.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeletePerson deletes a person & all their credits
+func (m *PostgresDBRepo) DeletePerson(id int) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(context, `DELETE FROM people WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// MovieCredits returns everyone credited in a movie, with their person, in billing order
+func (m *PostgresDBRepo) MovieCredits(movieID int) ([]*models.Credit, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `
+		SELECT c.id, c.movie_id, c.person_id, c.role, coalesce(c.character_name, ''), c.billing_order,
+			p.id, p.name, p.biography, p.birth_date, p.image, p.created_at, p.updated_at
+		FROM movie_credits c
+		JOIN people p ON (p.id = c.person_id)
+		WHERE c.movie_id = $1
+		ORDER BY c.billing_order, p.name, c.id
+	`
+
+	rows, err := m.DB.QueryContext(context, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var credits []*models.Credit
+
+	for rows.Next() {
+		var credit models.Credit
+		var person models.Person
+		err := rows.Scan(
+			&credit.ID,
+			&credit.MovieID,
+			&credit.PersonID,
+			&credit.Role,
+			&credit.Character,
+			&credit.BillingOrder,
+			&person.ID,
+			&person.Name,
+			&person.Biography,
+			&person.BirthDate,
+			&person.Image,
+			&person.CreatedAt,
+			&person.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		credit.Person = &person
+		credits = append(credits, &credit)
+	}
+
+	return credits, rows.Err()
+}
+
+// GetCredit returns one credit, without its person or movie
+func (m *PostgresDBRepo) GetCredit(id int) (*models.Credit, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT id, movie_id, person_id, role, coalesce(character_name, ''), billing_order FROM movie_credits WHERE id = $1`
+
+	var credit models.Credit
+	err := m.DB.QueryRowContext(context, query, id).Scan(
+		&credit.ID,
+		&credit.MovieID,
+		&credit.PersonID,
+		&credit.Role,
+		&credit.Character,
+		&credit.BillingOrder,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &credit, nil
+}
+
+func (m *PostgresDBRepo) InsertCredit(credit models.Credit) (int, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `INSERT INTO movie_credits (movie_id, person_id, role, character_name, billing_order, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
+
+	// crew have no character
+	character := sql.NullString{String: credit.Character, Valid: credit.Character != ""}
+
+	var newID int
+	err := m.DB.QueryRowContext(context, stmt,
+		credit.MovieID,
+		credit.PersonID,
+		credit.Role,
+		character,
+		credit.BillingOrder,
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+func (m *PostgresDBRepo) DeleteCredit(id int) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(context, `DELETE FROM movie_credits WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
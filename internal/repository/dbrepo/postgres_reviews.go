@@ -0,0 +1,191 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"context"
+	"fmt"
+	"time"
+)
+
+// reviewColumns are the columns scanReview expects, in order. They need reviews r joined to users u
+const reviewColumns = `r.id, r.movie_id, r.user_id, u.first_name, r.rating, r.body, r.helpful_count, r.status, r.shadow_hidden,
+	r.created_at, r.updated_at`
+
+// scanReview reads a review selected with reviewColumns
+func scanReview(row rowScanner) (*models.Review, error) {
+	var review models.Review
+	err := row.Scan(
+		&review.ID,
+		&review.MovieID,
+		&review.UserID,
+		&review.Author,
+		&review.Rating,
+		&review.Body,
+		&review.HelpfulCount,
+		&review.Status,
+		&review.ShadowHidden,
+		&review.CreatedAt,
+		&review.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+// reviewVisible is the condition for a review to be shown to the viewer ($2): published & not shadow
+// hidden, unless it is theirs
+const reviewVisible = `((r.status = 'published' AND NOT r.shadow_hidden) OR r.user_id = $2)`
+
+// MovieReviews returns a page of a movie's reviews the viewer can see, plus how many there are in total.
+// Ties are broken by id, newest first, so pages don't shift while people vote
+func (m *PostgresDBRepo) MovieReviews(q models.ReviewQuery) ([]*models.Review, int, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var order string
+	switch q.Sort {
+	case models.ReviewSortHelpful, "":
+		order = "r.helpful_count DESC, r.id DESC"
+	case models.ReviewSortRecent:
+		order = "r.created_at DESC, r.id DESC"
+	default:
+		return nil, 0, fmt.Errorf("unknown sort %q", q.Sort)
+	}
+
+	var total int
+	err := m.DB.QueryRowContext(context, `SELECT count(*) FROM reviews r WHERE r.movie_id = $1 AND `+reviewVisible, q.MovieID, q.ViewerID).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+		SELECT ` + reviewColumns + `
+		FROM reviews r
+		JOIN users u ON (u.id = r.user_id)
+		WHERE r.movie_id = $1 AND ` + reviewVisible + `
+		ORDER BY ` + order + `
+		LIMIT $3 OFFSET $4
+	`
+
+	rows, err := m.DB.QueryContext(context, query, q.MovieID, q.ViewerID, q.PageSize, (q.Page-1)*q.PageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var reviews []*models.Review
+
+	for rows.Next() {
+		review, err := scanReview(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		reviews = append(reviews, review)
+	}
+
+	return reviews, total, rows.Err()
+}
+
+func (m *PostgresDBRepo) GetReview(id int) (*models.Review, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT ` + reviewColumns + ` FROM reviews r JOIN users u ON (u.id = r.user_id) WHERE r.id = $1`
+
+	return scanReview(m.DB.QueryRowContext(context, query, id))
+}
+
+// UserReview returns the user's review of a movie, or sql.ErrNoRows if they haven't reviewed it
+func (m *PostgresDBRepo) UserReview(movieID, userID int) (*models.Review, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT ` + reviewColumns + ` FROM reviews r JOIN users u ON (u.id = r.user_id) WHERE r.movie_id = $1 AND r.user_id = $2`
+
+	return scanReview(m.DB.QueryRowContext(context, query, movieID, userID))
+}
+
+// SaveReview adds the user's review of a movie, or replaces the one they already wrote, and returns its
+// id. Reviews by shadow hidden users are hidden too. The movie's rating_count & rating_total are kept up
+// to date by the reviews_rating_aggregates trigger
+func (m *PostgresDBRepo) SaveReview(review models.Review) (int, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `
+		INSERT INTO reviews (movie_id, user_id, rating, body, status, shadow_hidden, created_at, updated_at)
+		SELECT $1, $2, $3, $4, $5, shadow_hidden_at IS NOT NULL, $6, $6 FROM users WHERE id = $2
+		ON CONFLICT (movie_id, user_id) DO UPDATE SET rating = EXCLUDED.rating, body = EXCLUDED.body, status = EXCLUDED.status,
+			updated_at = EXCLUDED.updated_at
+		RETURNING id`
+
+	var id int
+	err := m.DB.QueryRowContext(context, stmt,
+		review.MovieID,
+		review.UserID,
+		review.Rating,
+		review.Body,
+		review.Status,
+		time.Now().UTC(),
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// DeleteReview deletes a review & its helpful votes
+func (m *PostgresDBRepo) DeleteReview(id int) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(context, `DELETE FROM reviews WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// VoteReviewHelpful records that the user found a review helpful. It returns false if they already
+// had. The review's helpful_count is kept up to date by the review_votes_helpful_count trigger
+func (m *PostgresDBRepo) VoteReviewHelpful(reviewID, userID int) (bool, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `INSERT INTO review_votes (review_id, user_id, created_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`
+
+	result, err := m.DB.ExecContext(context, stmt, reviewID, userID, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// UnvoteReviewHelpful takes back the user's helpful vote. It returns false if they hadn't voted
+func (m *PostgresDBRepo) UnvoteReviewHelpful(reviewID, userID int) (bool, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `DELETE FROM review_votes WHERE review_id = $1 AND user_id = $2`
+
+	result, err := m.DB.ExecContext(context, stmt, reviewID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
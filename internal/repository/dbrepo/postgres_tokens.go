@@ -0,0 +1,221 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"context"
+	"time"
+)
+
+func (m *PostgresDBRepo) InsertRefreshToken(token models.RefreshToken) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `INSERT INTO refresh_tokens (user_id, token_hash, family_id, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)`
+
+	_, err := m.DB.ExecContext(context, stmt,
+		token.UserID,
+		token.TokenHash,
+		token.FamilyID,
+		token.ExpiresAt.UTC(),
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *PostgresDBRepo) GetRefreshTokenByHash(hash string) (*models.RefreshToken, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `
+		SELECT id, user_id, token_hash, family_id, expires_at, used_at, revoked_at, created_at
+		FROM refresh_tokens
+		WHERE token_hash = $1
+	`
+
+	var token models.RefreshToken
+	row := m.DB.QueryRowContext(context, query, hash)
+
+	err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.TokenHash,
+		&token.FamilyID,
+		&token.ExpiresAt,
+		&token.UsedAt,
+		&token.RevokedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// MarkRefreshTokenUsed only flags the token if nobody else has, so two requests racing with the same
+// token can't both rotate it
+func (m *PostgresDBRepo) MarkRefreshTokenUsed(id int) (bool, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `UPDATE refresh_tokens SET used_at = $1 WHERE id = $2 AND used_at IS NULL AND revoked_at IS NULL`
+
+	result, err := m.DB.ExecContext(context, stmt, time.Now().UTC(), id)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+func (m *PostgresDBRepo) RevokeRefreshTokenFamily(familyID string) error {
+	return m.revokeTokens(`family_id = $2`, `id = $2`, familyID)
+}
+
+func (m *PostgresDBRepo) RevokeUserRefreshTokens(userID int) error {
+	return m.revokeTokens(`user_id = $2`, `user_id = $2`, userID)
+}
+
+// RevokeOtherRefreshTokens revokes every token & session the user has, except those of the keepFamilyID login
+func (m *PostgresDBRepo) RevokeOtherRefreshTokens(userID int, keepFamilyID string) error {
+	return m.revokeTokens(`user_id = $2 AND family_id <> $3`, `user_id = $2 AND id <> $3`, userID, keepFamilyID)
+}
+
+// revokeTokens revokes the matching refresh tokens & sessions together, so a session never shows as
+// active once its tokens stop working. The conditions' arguments start at $2
+func (m *PostgresDBRepo) revokeTokens(tokenWhere, sessionWhere string, args ...any) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(context, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	args = append([]any{time.Now().UTC()}, args...)
+
+	stmt := `UPDATE refresh_tokens SET revoked_at = $1 WHERE ` + tokenWhere + ` AND revoked_at IS NULL`
+	_, err = tx.ExecContext(context, stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	stmt = `UPDATE sessions SET revoked_at = $1 WHERE ` + sessionWhere + ` AND revoked_at IS NULL`
+	_, err = tx.ExecContext(context, stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// sessionColumns are the columns scanSession expects, in order
+const sessionColumns = `id, user_id, user_agent, ip, created_at, last_refreshed_at, expires_at, revoked_at`
+
+func scanSession(row rowScanner) (*models.Session, error) {
+	var session models.Session
+
+	err := row.Scan(
+		&session.ID,
+		&session.UserID,
+		&session.UserAgent,
+		&session.IP,
+		&session.CreatedAt,
+		&session.LastRefreshedAt,
+		&session.ExpiresAt,
+		&session.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func (m *PostgresDBRepo) InsertSession(session models.Session) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `INSERT INTO sessions (id, user_id, user_agent, ip, created_at, last_refreshed_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $5, $6)`
+
+	_, err := m.DB.ExecContext(context, stmt,
+		session.ID,
+		session.UserID,
+		session.UserAgent,
+		session.IP,
+		time.Now().UTC(),
+		session.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *PostgresDBRepo) GetSession(id string) (*models.Session, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE id = $1`
+
+	row := m.DB.QueryRowContext(context, query, id)
+	return scanSession(row)
+}
+
+func (m *PostgresDBRepo) TouchSession(id, ip, userAgent string, expiresAt time.Time) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `UPDATE sessions SET ip = $1, user_agent = $2, last_refreshed_at = $3, expires_at = $4
+		WHERE id = $5 AND revoked_at IS NULL`
+
+	_, err := m.DB.ExecContext(context, stmt, ip, userAgent, time.Now().UTC(), expiresAt.UTC(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *PostgresDBRepo) UserSessions(userID int) ([]*models.Session, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + sessionColumns + `
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2
+		ORDER BY last_refreshed_at DESC
+	`
+
+	rows, err := m.DB.QueryContext(context, query, userID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*models.Session
+
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
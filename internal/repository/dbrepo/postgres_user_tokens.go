@@ -0,0 +1,89 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"context"
+	"time"
+)
+
+func (m *PostgresDBRepo) SetEmailVerified(id int) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `UPDATE users SET email_verified_at = $1, updated_at = $1 WHERE id = $2 AND email_verified_at IS NULL`
+
+	_, err := m.DB.ExecContext(context, stmt, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *PostgresDBRepo) InsertUserToken(token models.UserToken) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `INSERT INTO user_tokens (user_id, token_hash, purpose, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)`
+
+	_, err := m.DB.ExecContext(context, stmt,
+		token.UserID,
+		token.TokenHash,
+		token.Purpose,
+		token.ExpiresAt.UTC(),
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ConsumeUserToken checks & uses up the token in a single statement, so a token can't be used twice
+// even if two requests arrive with it at the same time
+func (m *PostgresDBRepo) ConsumeUserToken(hash, purpose string) (*models.UserToken, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `
+		UPDATE user_tokens SET used_at = $1
+		WHERE token_hash = $2 AND purpose = $3 AND used_at IS NULL AND expires_at > $1
+		RETURNING id, user_id, token_hash, purpose, expires_at, used_at, created_at
+	`
+
+	var token models.UserToken
+	row := m.DB.QueryRowContext(context, stmt, time.Now().UTC(), hash, purpose)
+
+	err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.TokenHash,
+		&token.Purpose,
+		&token.ExpiresAt,
+		&token.UsedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// DeleteUserTokens removes a user's outstanding tokens for a purpose, eg. so only the most recent
+// password reset email works
+func (m *PostgresDBRepo) DeleteUserTokens(userID int, purpose string) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `DELETE FROM user_tokens WHERE user_id = $1 AND purpose = $2`
+
+	_, err := m.DB.ExecContext(context, stmt, userID, purpose)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,247 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"context"
+	"time"
+)
+
+// watchEventColumns are the columns scanWatchEvent expects, in order. They need watch_events e joined to movies
+const watchEventColumns = `e.id, e.user_id, e.movie_id, movies.title, e.watched_at, e.created_at`
+
+// scanWatchEvent reads a watch event selected with watchEventColumns
+func scanWatchEvent(row rowScanner) (*models.WatchEvent, error) {
+	var event models.WatchEvent
+	err := row.Scan(
+		&event.ID,
+		&event.UserID,
+		&event.MovieID,
+		&event.Title,
+		&event.WatchedAt,
+		&event.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// InsertWatchEvent records the user watching a movie and returns its id. Having watched it, they are
+// no longer part way through it, so their position in it is dropped
+func (m *PostgresDBRepo) InsertWatchEvent(event models.WatchEvent) (int, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(context, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO watch_events (user_id, movie_id, watched_at, created_at) VALUES ($1, $2, $3, $4) RETURNING id`
+
+	var id int
+	err = tx.QueryRowContext(context, stmt, event.UserID, event.MovieID, event.WatchedAt, time.Now().UTC()).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(context, `DELETE FROM watch_progress WHERE user_id = $1 AND movie_id = $2`, event.UserID, event.MovieID)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// GetWatchEvent returns a watch event, with its movie's title
+func (m *PostgresDBRepo) GetWatchEvent(id int) (*models.WatchEvent, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT ` + watchEventColumns + ` FROM watch_events e JOIN movies ON (movies.id = e.movie_id) WHERE e.id = $1`
+
+	return scanWatchEvent(m.DB.QueryRowContext(context, query, id))
+}
+
+// DeleteWatchEvent deletes a watch event, eg. one recorded by mistake
+func (m *PostgresDBRepo) DeleteWatchEvent(id int) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(context, `DELETE FROM watch_events WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// WatchHistory returns a page of the user's watch history, most recently watched first, plus how many
+// events there are in total
+func (m *PostgresDBRepo) WatchHistory(q models.WatchHistoryQuery) ([]*models.WatchEvent, int, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var total int
+	err := m.DB.QueryRowContext(context, `SELECT count(*) FROM watch_events WHERE user_id = $1`, q.UserID).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+		SELECT ` + watchEventColumns + `
+		FROM watch_events e
+		JOIN movies ON (movies.id = e.movie_id)
+		WHERE e.user_id = $1
+		ORDER BY e.watched_at DESC, e.id DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := m.DB.QueryContext(context, query, q.UserID, q.PageSize, (q.Page-1)*q.PageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var events []*models.WatchEvent
+
+	for rows.Next() {
+		event, err := scanWatchEvent(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		events = append(events, event)
+	}
+
+	return events, total, rows.Err()
+}
+
+// EachWatchEvent calls fn with every one of the user's watch events, oldest first, stopping at the first
+// error fn returns. It streams them, so a long history doesn't have to fit in memory to be exported
+func (m *PostgresDBRepo) EachWatchEvent(ctx context.Context, userID int, fn func(*models.WatchEvent) error) error {
+	query := `
+		SELECT ` + watchEventColumns + `
+		FROM watch_events e
+		JOIN movies ON (movies.id = e.movie_id)
+		WHERE e.user_id = $1
+		ORDER BY e.watched_at, e.id
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event, err := scanWatchEvent(rows)
+		if err != nil {
+			return err
+		}
+		err = fn(event)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// SaveWatchProgress writes a batch of playback positions in one statement. A position older than the one
+// already saved is ignored, and so is one from before the user last marked the movie watched, so a
+// position that was still waiting to be saved can't bring a finished movie back to continue watching
+func (m *PostgresDBRepo) SaveWatchProgress(progress []models.WatchProgress) error {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userIDs := make([]int, len(progress))
+	movieIDs := make([]int, len(progress))
+	positions := make([]int, len(progress))
+	updatedAt := make([]time.Time, len(progress))
+	for i, p := range progress {
+		userIDs[i] = p.UserID
+		movieIDs[i] = p.MovieID
+		positions[i] = p.PositionSeconds
+		updatedAt[i] = p.UpdatedAt
+	}
+
+	stmt := `
+		INSERT INTO watch_progress (user_id, movie_id, position_seconds, updated_at)
+		SELECT p.user_id, p.movie_id, p.position_seconds, p.updated_at
+		FROM unnest($1::integer[], $2::integer[], $3::integer[], $4::timestamp[]) AS p(user_id, movie_id, position_seconds, updated_at)
+		WHERE EXISTS (SELECT 1 FROM movies WHERE movies.id = p.movie_id)
+			AND NOT EXISTS (
+				SELECT 1 FROM watch_events e
+				WHERE e.user_id = p.user_id AND e.movie_id = p.movie_id AND e.created_at >= p.updated_at
+			)
+		ON CONFLICT (user_id, movie_id) DO UPDATE SET position_seconds = EXCLUDED.position_seconds, updated_at = EXCLUDED.updated_at
+			WHERE watch_progress.updated_at < EXCLUDED.updated_at`
+
+	_, err := m.DB.ExecContext(context, stmt, userIDs, movieIDs, positions, updatedAt)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// WatchProgress returns the user's saved position in a movie, or sql.ErrNoRows
+func (m *PostgresDBRepo) WatchProgress(userID, movieID int) (*models.WatchProgress, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT user_id, movie_id, position_seconds, updated_at FROM watch_progress WHERE user_id = $1 AND movie_id = $2`
+
+	var p models.WatchProgress
+	err := m.DB.QueryRowContext(context, query, userID, movieID).Scan(
+		&p.UserID,
+		&p.MovieID,
+		&p.PositionSeconds,
+		&p.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// ContinueWatching returns the movies the user is part way through, with their saved positions, most
+// recently watched first. Movies they are nearly at the end of (see models.FinishedFraction) are left out
+func (m *PostgresDBRepo) ContinueWatching(userID, limit int) ([]*models.WatchProgress, error) {
+	context, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	// movieColumns aren't qualified, so the progress columns are renamed where they would clash
+	query := `
+		SELECT ` + movieColumns + `, p.movie_id, p.position_seconds, p.progress_updated_at
+		FROM movies
+		JOIN (
+			SELECT movie_id, position_seconds, updated_at AS progress_updated_at FROM watch_progress WHERE user_id = $1
+		) p ON (p.movie_id = movies.id)
+		WHERE p.position_seconds > 0
+			AND (movies.runtime IS NULL OR movies.runtime <= 0 OR p.position_seconds < movies.runtime * 60 * $2)
+		ORDER BY p.progress_updated_at DESC
+		LIMIT $3
+	`
+
+	rows, err := m.DB.QueryContext(context, query, userID, models.FinishedFraction, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var progress []*models.WatchProgress
+
+	for rows.Next() {
+		p := models.WatchProgress{UserID: userID}
+		p.Movie, err = scanMovie(withExtra{rows, []any{&p.MovieID, &p.PositionSeconds, &p.UpdatedAt}})
+		if err != nil {
+			return nil, err
+		}
+		progress = append(progress, &p)
+	}
+
+	return progress, rows.Err()
+}
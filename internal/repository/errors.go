@@ -0,0 +1,20 @@
+package repository
+
+import "errors"
+
+// ErrDuplicateEmail is returned when a user is inserted or updated with an email address that
+// already belongs to another user (the users_email_key unique constraint was violated)
+var ErrDuplicateEmail = errors.New("a user with that email address already exists")
+
+// ErrInvalidCursor is returned when a pagination cursor can't be decoded, or was made for another sort order
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ErrReviewStatusChanged is returned when a review is moderated from a status it is no longer in, because
+// someone else got there first
+var ErrReviewStatusChanged = errors.New("the review's status has changed, reload it & try again")
+
+// ErrDuplicateSlug is returned when a list is inserted with a slug another list already has
+var ErrDuplicateSlug = errors.New("a list with that slug already exists")
+
+// ErrListOrder is returned when a list is reordered with movies that aren't exactly the ones on it
+var ErrListOrder = errors.New("the new order must name every movie on the list exactly once")
@@ -0,0 +1,104 @@
+package memrepo
+
+import (
+	"backend/internal/models"
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LoginAttemptRepo is an in-memory repository.LoginAttemptRepo. The zero value is ready to use.
+// Counters aren't shared between instances of the API, so only use it when running a single instance
+type LoginAttemptRepo struct {
+	mu       sync.Mutex
+	attempts map[string]*models.LoginAttempts
+}
+
+func (m *LoginAttemptRepo) GetLoginAttempts(key string) (*models.LoginAttempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempts, ok := m.attempts[key]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	a := *attempts
+	return &a, nil
+}
+
+func (m *LoginAttemptRepo) RecordLoginFailure(key string, window time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.attempts == nil {
+		m.attempts = map[string]*models.LoginAttempts{}
+	}
+
+	now := time.Now().UTC()
+	attempts, ok := m.attempts[key]
+	if !ok {
+		attempts = &models.LoginAttempts{Key: key}
+		m.attempts[key] = attempts
+	}
+
+	if attempts.LastFailureAt.Before(now.Add(-window)) {
+		attempts.Failures = 0
+	}
+	attempts.Failures++
+	attempts.LastFailureAt = now
+
+	return attempts.Failures, nil
+}
+
+func (m *LoginAttemptRepo) LockLogin(key string, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if attempts, ok := m.attempts[key]; ok {
+		until = until.UTC()
+		attempts.LockedUntil = &until
+	}
+
+	return nil
+}
+
+func (m *LoginAttemptRepo) ClearLoginAttempts(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.attempts, key)
+	return nil
+}
+
+func (m *LoginAttemptRepo) AllLoginAttempts() ([]*models.LoginAttempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var all []*models.LoginAttempts
+	for _, attempts := range m.attempts {
+		a := *attempts
+		all = append(all, &a)
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].LastFailureAt.After(all[j].LastFailureAt)
+	})
+
+	return all, nil
+}
+
+func (m *LoginAttemptRepo) PruneLoginAttempts(before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for key, attempts := range m.attempts {
+		if attempts.LastFailureAt.Before(before) && !attempts.Locked(now) {
+			delete(m.attempts, key)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+// Package memrepo holds in-memory implementations of the repository interfaces. They are handy for
+// tests & local development where running Postgres isn't worth it. Nothing in here survives a restart.
+package memrepo
+
+import (
+	"backend/internal/models"
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TokenRepo is an in-memory repository.RefreshTokenRepo. The zero value is ready to use
+type TokenRepo struct {
+	mu       sync.Mutex
+	tokens   []*models.RefreshToken
+	sessions map[string]*models.Session
+	nextID   int
+}
+
+func (m *TokenRepo) InsertRefreshToken(token models.RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	token.ID = m.nextID
+	token.CreatedAt = time.Now().UTC()
+	m.tokens = append(m.tokens, &token)
+
+	return nil
+}
+
+func (m *TokenRepo) GetRefreshTokenByHash(hash string) (*models.RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, token := range m.tokens {
+		if token.TokenHash == hash {
+			// hand back a copy so callers can't change our state without going through the repo
+			t := *token
+			return &t, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (m *TokenRepo) MarkRefreshTokenUsed(id int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, token := range m.tokens {
+		if token.ID == id {
+			if token.UsedAt != nil || token.RevokedAt != nil {
+				return false, nil
+			}
+			now := time.Now().UTC()
+			token.UsedAt = &now
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (m *TokenRepo) RevokeRefreshTokenFamily(familyID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	for _, token := range m.tokens {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+
+	if session, ok := m.sessions[familyID]; ok && session.RevokedAt == nil {
+		session.RevokedAt = &now
+	}
+
+	return nil
+}
+
+func (m *TokenRepo) RevokeUserRefreshTokens(userID int) error {
+	return m.RevokeOtherRefreshTokens(userID, "")
+}
+
+func (m *TokenRepo) RevokeOtherRefreshTokens(userID int, keepFamilyID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	for _, token := range m.tokens {
+		if token.UserID == userID && token.FamilyID != keepFamilyID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+
+	for _, session := range m.sessions {
+		if session.UserID == userID && session.ID != keepFamilyID && session.RevokedAt == nil {
+			session.RevokedAt = &now
+		}
+	}
+
+	return nil
+}
+
+func (m *TokenRepo) InsertSession(session models.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.sessions == nil {
+		m.sessions = make(map[string]*models.Session)
+	}
+
+	now := time.Now().UTC()
+	session.CreatedAt = now
+	session.LastRefreshedAt = now
+	m.sessions[session.ID] = &session
+
+	return nil
+}
+
+func (m *TokenRepo) GetSession(id string) (*models.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	s := *session
+	return &s, nil
+}
+
+func (m *TokenRepo) TouchSession(id, ip, userAgent string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if session, ok := m.sessions[id]; ok && session.RevokedAt == nil {
+		session.IP = ip
+		session.UserAgent = userAgent
+		session.LastRefreshedAt = time.Now().UTC()
+		session.ExpiresAt = expiresAt
+	}
+
+	return nil
+}
+
+func (m *TokenRepo) UserSessions(userID int) ([]*models.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var sessions []*models.Session
+
+	for _, session := range m.sessions {
+		if session.UserID == userID && session.RevokedAt == nil && session.ExpiresAt.After(now) {
+			s := *session
+			sessions = append(sessions, &s)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastRefreshedAt.After(sessions[j].LastRefreshedAt)
+	})
+
+	return sessions, nil
+}
@@ -2,19 +2,165 @@ package repository
 
 import (
 	"backend/internal/models"
+	"context"
 	"database/sql"
+	"time"
 )
 
+// TitleSuggester is implemented by repositories that can suggest movie titles themselves. For the ones
+// that can't, the API falls back to an in-memory index (see the suggest package)
+type TitleSuggester interface {
+	SuggestTitles(ctx context.Context, prefix string, limit int) ([]*models.Suggestion, error)
+}
+
 type DatabaseRepo interface {
 	Connection() *sql.DB
-	AllMovies(genre ...int) ([]*models.Movie, error)
+	// ListMovies returns one page of the movies matching q, see models.MovieQuery
+	ListMovies(ctx context.Context, q models.MovieQuery) (*models.MoviePage, error)
+	// MovieFeatures returns every movie with its genre & cast/crew ids
+	MovieFeatures(ctx context.Context) ([]*models.MovieFeatures, error)
+	// AllPeople returns one page of the people matching q, and the total number of matches
+	AllPeople(q models.PersonQuery) ([]*models.Person, int, error)
+	// GetPerson returns a person with their filmography
+	GetPerson(id int) (*models.Person, error)
+	InsertPerson(person models.Person) (int, error)
+	UpdatePerson(person models.Person) error
+	DeletePerson(id int) error
+	// MovieCredits returns the cast & crew of a movie in billing order, each with their person
+	MovieCredits(movieID int) ([]*models.Credit, error)
+	GetCredit(id int) (*models.Credit, error)
+	InsertCredit(credit models.Credit) (int, error)
+	DeleteCredit(id int) error
+	// MovieReviews returns one page of a movie's reviews, and how many it has in total
+	MovieReviews(q models.ReviewQuery) ([]*models.Review, int, error)
+	GetReview(id int) (*models.Review, error)
+	// UserReview returns the user's review of the movie, or sql.ErrNoRows
+	UserReview(movieID, userID int) (*models.Review, error)
+	// SaveReview adds or replaces the user's review of the movie. The movie's rating aggregates follow
+	SaveReview(review models.Review) (int, error)
+	DeleteReview(id int) error
+	// VoteReviewHelpful & UnvoteReviewHelpful return false when there was nothing to change
+	VoteReviewHelpful(reviewID, userID int) (bool, error)
+	UnvoteReviewHelpful(reviewID, userID int) (bool, error)
+	// ModerationQueue returns one page of the reviews in q.Statuses, most reported first, and the total
+	ModerationQueue(q models.ModerationQuery) ([]*models.Review, int, error)
+	// ModerateReview changes a review's status & records the decision, or returns ErrReviewStatusChanged
+	// if the review is no longer in decision.FromStatus
+	ModerateReview(decision models.ModerationDecision) error
+	InsertModerationDecision(decision models.ModerationDecision) error
+	ReviewDecisions(reviewID int) ([]*models.ModerationDecision, error)
+	// ReportReview records a report & returns how many open reports the review has
+	ReportReview(report models.ReviewReport) (int, error)
+	SetUserShadowHidden(userID int, hidden bool) error
+	// AllLists returns one page of the lists matching q, and the total number of matches
+	AllLists(q models.ListQuery) ([]*models.List, int, error)
+	GetList(id int) (*models.List, error)
+	GetListBySlug(slug string) (*models.List, error)
+	// Watchlist returns the user's watchlist, making it (with slug) if they don't have one yet
+	Watchlist(userID int, slug string) (*models.List, error)
+	// ListItems returns the movies on a list, in order
+	ListItems(listID int) ([]*models.ListItem, error)
+	// InsertList returns ErrDuplicateSlug if the slug is taken
+	InsertList(list models.List) (int, error)
+	UpdateList(list models.List) error
+	DeleteList(id int) error
+	// AddListMovie & RemoveListMovie return false when there was nothing to change
+	AddListMovie(listID, movieID int) (bool, error)
+	RemoveListMovie(listID, movieID int) (bool, error)
+	// ReorderList returns ErrListOrder unless movieIDs holds every movie on the list exactly once
+	ReorderList(listID int, movieIDs []int) error
+	// InsertWatchEvent records a watch & drops the user's saved position in the movie
+	InsertWatchEvent(event models.WatchEvent) (int, error)
+	GetWatchEvent(id int) (*models.WatchEvent, error)
+	DeleteWatchEvent(id int) error
+	// WatchHistory returns one page of the user's watches, most recent first, and how many there are
+	WatchHistory(q models.WatchHistoryQuery) ([]*models.WatchEvent, int, error)
+	// EachWatchEvent streams every one of the user's watches to fn, oldest first
+	EachWatchEvent(ctx context.Context, userID int, fn func(*models.WatchEvent) error) error
+	// SaveWatchProgress writes a batch of positions, never replacing a newer one with an older one
+	SaveWatchProgress(progress []models.WatchProgress) error
+	// WatchProgress returns the user's saved position in the movie, or sql.ErrNoRows
+	WatchProgress(userID, movieID int) (*models.WatchProgress, error)
+	// ContinueWatching returns the movies the user is part way through, most recent first
+	ContinueWatching(userID, limit int) ([]*models.WatchProgress, error)
 	GetUserByEmail(email string) (*models.User, error)
 	GetUserById(id int) (*models.User, error)
+	InsertUser(user models.User) (int, error)
+	UpdateUser(user models.User) error
+	UpdatePassword(id int, password string) error
+	DeleteUser(id int) error
+	// AllUsers returns one page of the users matching q, and the total number of matches
+	AllUsers(q models.UserQuery) ([]*models.User, int, error)
+	SetUserRole(id int, role string) error
+	SetUserDisabled(id int, disabled bool) error
+	// DeleteUserReassign deletes a user, handing their API keys & public & unlisted lists over to
+	// reassignTo. Their personal data (private lists, reviews, watch history...) is deleted with them
+	DeleteUserReassign(id, reassignTo int) error
+	SetEmailVerified(id int) error
+	InsertUserToken(token models.UserToken) error
+	// ConsumeUserToken marks an unused, unexpired token as used & returns it, or sql.ErrNoRows
+	ConsumeUserToken(hash, purpose string) (*models.UserToken, error)
+	DeleteUserTokens(userID int, purpose string) error
+	SetTOTPSecret(userID int, secret string) error
+	EnableTOTP(userID int, step int64) error
+	DisableTOTP(userID int) error
+	UseTOTPStep(userID int, step int64) (bool, error)
+	ReplaceRecoveryCodes(userID int, hashes []string) error
+	UseRecoveryCode(userID int, hash string) (bool, error)
+	// GetUserByIdentity finds the user linked to an external identity (OIDC issuer & subject), or sql.ErrNoRows
+	GetUserByIdentity(issuer, subject string) (*models.User, error)
+	LinkIdentity(userID int, issuer, subject string) error
+	InsertAPIKey(key models.APIKey) (int, error)
+	GetAPIKey(id int) (*models.APIKey, error)
+	GetAPIKeyByHash(hash string) (*models.APIKey, error)
+	AllAPIKeys() ([]*models.APIKey, error)
+	RevokeAPIKey(id int) error
+	TouchAPIKey(id int) error
+	// InsertAuditEvent appends to the audit log. Audit events can never be changed or deleted
+	InsertAuditEvent(event models.AuditEvent) error
+	AllAuditEvents(q models.AuditQuery) ([]*models.AuditEvent, int, error)
 	OneMovie(id int) (*models.Movie, error)
 	OneMovieForEdit(id int) (*models.Movie, []*models.Genre, error)
 	AllGenres() ([]*models.Genre, error)
 	InsertMovie(movie models.Movie) (int, error)
 	UpdateMovieGenres(id int, genreIDs []int) error
 	UpdateMovie(movie models.Movie) error
+	// DeleteMovie deletes a movie & takes it off every list it was on
 	DeleteMovie(id int) error
 }
+
+// RefreshTokenRepo stores hashed refresh tokens, and the sessions they belong to, so they can be rotated
+// & revoked server side. It is kept apart from DatabaseRepo so the token rotation logic can run against
+// an in-memory store
+type RefreshTokenRepo interface {
+	InsertRefreshToken(token models.RefreshToken) error
+	GetRefreshTokenByHash(hash string) (*models.RefreshToken, error)
+	// MarkRefreshTokenUsed returns false if the token had already been used (or revoked) by someone else
+	MarkRefreshTokenUsed(id int) (bool, error)
+	// RevokeRefreshTokenFamily revokes every token from one login, and the session itself
+	RevokeRefreshTokenFamily(familyID string) error
+	// RevokeUserRefreshTokens revokes every token & session the user has
+	RevokeUserRefreshTokens(userID int) error
+	// RevokeOtherRefreshTokens revokes every token & session the user has except one login's
+	RevokeOtherRefreshTokens(userID int, keepFamilyID string) error
+	InsertSession(session models.Session) error
+	// GetSession returns sql.ErrNoRows if there is no such session
+	GetSession(id string) (*models.Session, error)
+	// TouchSession records a refresh: where it came from & when the session now expires
+	TouchSession(id, ip, userAgent string, expiresAt time.Time) error
+	// UserSessions lists the user's sessions that are neither revoked nor expired, newest first
+	UserSessions(userID int) ([]*models.Session, error)
+}
+
+// LoginAttemptRepo keeps the failed login counters used to slow down password guessing
+type LoginAttemptRepo interface {
+	// GetLoginAttempts returns sql.ErrNoRows if the key has no recent failures
+	GetLoginAttempts(key string) (*models.LoginAttempts, error)
+	// RecordLoginFailure adds a failure & returns the new count. Failures older than window are forgotten
+	RecordLoginFailure(key string, window time.Duration) (int, error)
+	LockLogin(key string, until time.Time) error
+	ClearLoginAttempts(key string) error
+	AllLoginAttempts() ([]*models.LoginAttempts, error)
+	// PruneLoginAttempts forgets every key whose last failure was before the given time, unless it is still locked
+	PruneLoginAttempts(before time.Time) error
+}
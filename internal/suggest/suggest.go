@@ -0,0 +1,145 @@
+// Package suggest is an in-memory index of movie titles for autocomplete that tolerates typos. It works
+// like Postgres' pg_trgm: titles & what the user typed are folded (lower case, accents removed) & split
+// into trigrams, and titles sharing enough trigrams with the query are suggested. Titles that start with
+// the query always come first. An Index is read only once built, so it is safe to share between requests.
+package suggest
+
+import (
+	"backend/internal/models"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Threshold is the share of the query's trigrams a title must have to be suggested when it doesn't
+// start with the query. pg_trgm's word similarity defaults to 0.6
+const Threshold = 0.6
+
+type entry struct {
+	id     int
+	title  string
+	folded string
+}
+
+// Index finds titles by prefix & trigram similarity
+type Index struct {
+	entries []entry
+	// grams maps every trigram to the entries whose titles contain it
+	grams map[string][]int
+}
+
+// New indexes the titles of movies
+func New(movies []*models.Movie) *Index {
+	ix := &Index{grams: make(map[string][]int)}
+
+	for _, movie := range movies {
+		e := entry{id: movie.ID, title: movie.Title, folded: Fold(movie.Title)}
+		ix.entries = append(ix.entries, e)
+
+		i := len(ix.entries) - 1
+		for gram := range trigrams(e.folded, true) {
+			ix.grams[gram] = append(ix.grams[gram], i)
+		}
+	}
+
+	return ix
+}
+
+// Len is the number of titles in the index
+func (ix *Index) Len() int {
+	return len(ix.entries)
+}
+
+// Suggest returns up to limit titles for what the user has typed so far, best first
+func (ix *Index) Suggest(prefix string, limit int) []*models.Suggestion {
+	query := Fold(prefix)
+	if query == "" || limit < 1 {
+		return nil
+	}
+
+	scores := make(map[int]float64)
+
+	// the last word is probably still being typed, so it isn't padded at the end
+	queryGrams := trigrams(query, false)
+	if len(queryGrams) > 0 {
+		shared := make(map[int]int)
+		for gram := range queryGrams {
+			for _, i := range ix.grams[gram] {
+				shared[i]++
+			}
+		}
+		for i, n := range shared {
+			if score := float64(n) / float64(len(queryGrams)); score >= Threshold {
+				scores[i] = score
+			}
+		}
+	}
+
+	for i, e := range ix.entries {
+		if strings.HasPrefix(e.folded, query) {
+			scores[i] = 1
+		}
+	}
+
+	suggestions := make([]*models.Suggestion, 0, len(scores))
+	for i, score := range scores {
+		suggestions = append(suggestions, &models.Suggestion{
+			ID:    ix.entries[i].id,
+			Title: ix.entries[i].title,
+			Score: score,
+		})
+	}
+
+	sort.Slice(suggestions, func(a, b int) bool {
+		if suggestions[a].Score != suggestions[b].Score {
+			return suggestions[a].Score > suggestions[b].Score
+		}
+		return suggestions[a].Title < suggestions[b].Title
+	})
+
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+
+	return suggestions
+}
+
+// foldAccents strips accents, eg. "é" becomes "e"
+var foldAccents = transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+// Fold lower cases s, removes accents & collapses runs of white space, so "  Amélie " and "amelie" match
+func Fold(s string) string {
+	folded, _, err := transform.String(foldAccents, s)
+	if err != nil {
+		folded = s
+	}
+	return strings.Join(strings.Fields(strings.ToLower(folded)), " ")
+}
+
+// trigrams splits s into words of letters & digits, pads each with two spaces in front & one behind
+// (as pg_trgm does) and returns the set of three letter slices. With padEnd false the last word isn't
+// padded behind, so a half typed word isn't penalised for not ending yet
+func trigrams(s string, padEnd bool) map[string]struct{} {
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	grams := make(map[string]struct{})
+	for i, word := range words {
+		padded := "  " + word
+		if padEnd || i < len(words)-1 {
+			padded += " "
+		}
+
+		r := []rune(padded)
+		for j := 0; j+3 <= len(r); j++ {
+			grams[string(r[j:j+3])] = struct{}{}
+		}
+	}
+
+	return grams
+}
@@ -0,0 +1,56 @@
+package suggest
+
+import (
+	"backend/internal/models"
+	"testing"
+)
+
+func index() *Index {
+	return New([]*models.Movie{
+		{ID: 1, Title: "Interstellar"},
+		{ID: 2, Title: "Amélie"},
+		{ID: 3, Title: "The Godfather"},
+		{ID: 4, Title: "The Godfather Part II"},
+		{ID: 5, Title: "Godzilla"},
+		{ID: 6, Title: "Inception"},
+		{ID: 7, Title: "An Interstate Tale"},
+	})
+}
+
+func titles(suggestions []*models.Suggestion) []string {
+	var titles []string
+	for _, s := range suggestions {
+		titles = append(titles, s.Title)
+	}
+	return titles
+}
+
+func TestSuggest(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		want   []string
+	}{
+		{"typo", "intersteller", []string{"Interstellar"}},
+		{"accents in the title", "amelie", []string{"Amélie"}},
+		{"accents in the query", "AMÉLIE", []string{"Amélie"}},
+		{"extra white space", "  the   godf ", []string{"The Godfather", "The Godfather Part II"}},
+		// a title starting with the query comes before one with a similar word, whatever their names
+		{"prefix first", "interste", []string{"Interstellar", "An Interstate Tale"}},
+		{"nothing alike", "zzzz", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := titles(index().Suggest(tt.prefix, 10))
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}